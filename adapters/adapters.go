@@ -0,0 +1,38 @@
+// Package adapters holds the helpers shared by the framework integrations in
+// its sub-packages: ginauth, echoauth, chiauth and fiberauth. Each provides
+// the auth middleware in the framework's own style and mounts the routes of
+// router.Routes, with the same behaviour as router.SetupRoutes. The
+// conformance package checks that they do.
+package adapters
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Run runs net/http middleware mw with a next handler that only records the
+// request it receives. It reports whether mw let the request through and
+// returns the request mw passed on, whose context carries the claims. If mw
+// rejected the request it has already written the response.
+//
+// Work mw does after calling its next handler runs before the framework's
+// own handlers; the auth middleware does none.
+func Run(mw func(http.Handler) http.Handler, w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	var passed *http.Request
+	mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		passed = r
+	})).ServeHTTP(w, r)
+	return passed, passed != nil
+}
+
+// ColonPattern converts a net/http path pattern to the ":name" syntax used
+// by Gin, Echo and Fiber, e.g. "/api-keys/{id}" to "/api-keys/:id"
+func ColonPattern(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			segments[i] = ":" + segment[1:len(segment)-1]
+		}
+	}
+	return strings.Join(segments, "/")
+}
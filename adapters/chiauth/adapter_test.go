@@ -0,0 +1,41 @@
+package chiauth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rb4807/Golang-Utlis/adapters/conformance"
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/authtest"
+	"github.com/rb4807/Golang-Utlis/router"
+)
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, authtest.New(t), conformanceAdapter())
+}
+
+// conformanceAdapter describes this adapter to the conformance suite
+func conformanceAdapter() conformance.Adapter {
+	return conformance.Adapter{
+		Name: "chi",
+		New: func(authService *auth.Service, routes []router.Route) http.Handler {
+			r := chi.NewRouter()
+			MountRoutes(r, routes)
+
+			probe := func(w http.ResponseWriter, r *http.Request) {
+				claims, err := Claims(r)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				w.Write([]byte(conformance.ProbeBody(claims)))
+			}
+			r.With(Auth(authService)).Get(conformance.UserPath, probe)
+			r.With(Admin(authService)).Get(conformance.AdminPath, probe)
+			r.With(Superuser(authService)).Get(conformance.SuperuserPath, probe)
+			r.With(RequireAuth(authService, conformance.Check, conformance.CheckMessage)).Get(conformance.CheckPath, probe)
+			return r
+		},
+	}
+}
@@ -0,0 +1,51 @@
+// Package chiauth integrates the auth service with chi. Chi uses net/http
+// middleware directly, so this package mostly gives the service's
+// middleware chi-style names and mounts the routes.
+package chiauth
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/router"
+)
+
+// Auth requires a valid token or session, like auth.Service.AuthMiddleware
+func Auth(authService *auth.Service) func(http.Handler) http.Handler {
+	return authService.AuthMiddleware
+}
+
+// Admin requires an admin user, like auth.Service.AdminMiddleware
+func Admin(authService *auth.Service) func(http.Handler) http.Handler {
+	return authService.AdminMiddleware
+}
+
+// Superuser requires a superuser, like auth.Service.SuperuserMiddleware
+func Superuser(authService *auth.Service) func(http.Handler) http.Handler {
+	return authService.SuperuserMiddleware
+}
+
+// RequireAuth requires a user whose claims pass check, like
+// auth.Service.RequireAuth
+func RequireAuth(authService *auth.Service, check func(*auth.TokenClaims) bool, message string) func(http.Handler) http.Handler {
+	return authService.RequireAuth(check, message)
+}
+
+// Claims returns the claims of the authenticated user
+func Claims(r *http.Request) (*auth.TokenClaims, error) {
+	return auth.GetUserFromContext(r.Context())
+}
+
+// Mount registers the routes of router.SetupRoutes on r
+func Mount(r chi.Router, authService *auth.Service, opts ...router.Option) {
+	MountRoutes(r, router.Routes(authService, opts...))
+}
+
+// MountRoutes registers routes on r, for every method. Chi uses the same
+// "{name}" pattern syntax and sets Request.PathValue itself.
+func MountRoutes(r chi.Router, routes []router.Route) {
+	for _, route := range routes {
+		r.Handle(route.Pattern, route.Handler)
+	}
+}
@@ -0,0 +1,152 @@
+// Package conformance is a test suite that checks a framework adapter
+// behaves like the net/http middleware and router it wraps. Each adapter
+// package describes itself in its tests and runs the suite against an
+// authtest harness:
+//
+//	func TestConformance(t *testing.T) {
+//		conformance.Run(t, authtest.New(t), conformanceAdapter())
+//	}
+//
+// The package imports testing, so import it only from _test.go files.
+package conformance
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/authtest"
+	"github.com/rb4807/Golang-Utlis/router"
+)
+
+// Probe routes the adapter serves with its own middleware
+const (
+	UserPath      = "/conformance/user"      // Auth
+	AdminPath     = "/conformance/admin"     // Admin
+	SuperuserPath = "/conformance/superuser" // Superuser
+	CheckPath     = "/conformance/check"     // RequireAuth(Check, CheckMessage)
+)
+
+// CheckMessage is the message RequireAuth must send when Check fails
+const CheckMessage = "conformance check failed"
+
+// User IDs of the tokens the suite sends
+const (
+	UserID  int64 = 910001
+	AdminID int64 = 910002
+)
+
+// Check is the RequireAuth check used on CheckPath; it admits AdminID only
+func Check(claims *auth.TokenClaims) bool {
+	return claims.UserID == AdminID
+}
+
+// Adapter describes a framework integration under test
+type Adapter struct {
+	Name string
+
+	// New builds an application that mounts routes with the adapter's route
+	// mounting, and serves GET requests to the probe paths with the
+	// adapter's middleware. Probe handlers read the claims with the
+	// adapter's own accessor and respond 200 with ProbeBody(claims).
+	New func(authService *auth.Service, routes []router.Route) http.Handler
+}
+
+// ProbeBody is the response body of the probe routes
+func ProbeBody(claims *auth.TokenClaims) string {
+	return fmt.Sprintf("user:%d", claims.UserID)
+}
+
+// paramsPattern is mounted by the suite to check path parameters reach
+// handlers through Request.PathValue
+const paramsPattern = "/conformance/params/{first}/{second}"
+
+// Run checks adapter against the net/http behaviour, adding the users the
+// suite sends tokens for to the harness
+func Run(t *testing.T, h *authtest.Harness, adapter Adapter) {
+	authService := h.Service
+	userToken := h.Token(h.AddUser(auth.User{ID: UserID, Username: "conformance-user", IsActive: true}))
+	adminToken := h.Token(h.AddUser(auth.User{ID: AdminID, Username: "conformance-admin", IsActive: true, IsSuperuser: true}))
+
+	routes := append(router.Routes(authService), router.Route{
+		Pattern: paramsPattern,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.PathValue("first")+","+r.PathValue("second"))
+		}),
+	})
+	app := adapter.New(authService, routes)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+		body   string
+	}{
+		{"auth rejects missing token", http.MethodGet, UserPath, "", http.StatusUnauthorized, ""},
+		{"auth rejects invalid token", http.MethodGet, UserPath, "invalid", http.StatusUnauthorized, ""},
+		{"auth passes claims", http.MethodGet, UserPath, userToken, http.StatusOK, "user:910001"},
+		{"admin rejects user", http.MethodGet, AdminPath, userToken, http.StatusForbidden, "Admin access required"},
+		{"admin admits superuser", http.MethodGet, AdminPath, adminToken, http.StatusOK, "user:910002"},
+		{"superuser rejects user", http.MethodGet, SuperuserPath, userToken, http.StatusForbidden, "Superuser access required"},
+		{"superuser admits superuser", http.MethodGet, SuperuserPath, adminToken, http.StatusOK, "user:910002"},
+		{"require auth rejects failed check", http.MethodGet, CheckPath, userToken, http.StatusForbidden, CheckMessage},
+		{"require auth admits passed check", http.MethodGet, CheckPath, adminToken, http.StatusOK, "user:910002"},
+		{"mounted public route", http.MethodGet, "/csrf", "", http.StatusOK, ""},
+		{"mounted route checks method", http.MethodGet, "/register", "", http.StatusMethodNotAllowed, ""},
+		{"mounted protected route", http.MethodGet, "/profile", "", http.StatusUnauthorized, ""},
+		{"mounted admin route", http.MethodGet, "/admin", userToken, http.StatusForbidden, ""},
+		{"mounted route path values", http.MethodGet, "/conformance/params/a/b", "", http.StatusOK, "a,b"},
+	}
+
+	for _, tc := range cases {
+		t.Run(adapter.Name+"/"+tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rec := httptest.NewRecorder()
+			app.ServeHTTP(rec, req)
+
+			if rec.Code != tc.status {
+				t.Fatalf("%s %s: status %d, want %d (body %q)", tc.method, tc.path, rec.Code, tc.status, rec.Body.String())
+			}
+			if tc.body != "" && !strings.Contains(rec.Body.String(), tc.body) {
+				t.Fatalf("%s %s: body %q, want it to contain %q", tc.method, tc.path, rec.Body.String(), tc.body)
+			}
+		})
+	}
+}
+
+// NetHTTP is the reference adapter: the net/http middleware and a ServeMux,
+// as used by router.SetupRoutes
+func NetHTTP() Adapter {
+	return Adapter{
+		Name: "net/http",
+		New: func(authService *auth.Service, routes []router.Route) http.Handler {
+			mux := http.NewServeMux()
+			for _, route := range routes {
+				mux.Handle(route.Pattern, route.Handler)
+			}
+
+			probe := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				claims, err := auth.GetUserFromContext(r.Context())
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				io.WriteString(w, ProbeBody(claims))
+			})
+			mux.Handle("GET "+UserPath, authService.AuthMiddleware(probe))
+			mux.Handle("GET "+AdminPath, authService.AdminMiddleware(probe))
+			mux.Handle("GET "+SuperuserPath, authService.SuperuserMiddleware(probe))
+			mux.Handle("GET "+CheckPath, authService.RequireAuth(Check, CheckMessage)(probe))
+			return mux
+		},
+	}
+}
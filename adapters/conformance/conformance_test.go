@@ -0,0 +1,11 @@
+package conformance
+
+import (
+	"testing"
+
+	"github.com/rb4807/Golang-Utlis/authtest"
+)
+
+func TestNetHTTP(t *testing.T) {
+	Run(t, authtest.New(t), NetHTTP())
+}
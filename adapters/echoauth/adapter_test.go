@@ -0,0 +1,40 @@
+package echoauth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rb4807/Golang-Utlis/adapters/conformance"
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/authtest"
+	"github.com/rb4807/Golang-Utlis/router"
+)
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, authtest.New(t), conformanceAdapter())
+}
+
+// conformanceAdapter describes this adapter to the conformance suite
+func conformanceAdapter() conformance.Adapter {
+	return conformance.Adapter{
+		Name: "echo",
+		New: func(authService *auth.Service, routes []router.Route) http.Handler {
+			e := echo.New()
+			MountRoutes(e, routes)
+
+			probe := func(c echo.Context) error {
+				claims, err := Claims(c)
+				if err != nil {
+					return err
+				}
+				return c.String(http.StatusOK, conformance.ProbeBody(claims))
+			}
+			e.GET(conformance.UserPath, probe, Auth(authService))
+			e.GET(conformance.AdminPath, probe, Admin(authService))
+			e.GET(conformance.SuperuserPath, probe, Superuser(authService))
+			e.GET(conformance.CheckPath, probe, RequireAuth(authService, conformance.Check, conformance.CheckMessage))
+			return e
+		},
+	}
+}
@@ -0,0 +1,61 @@
+// Package echoauth integrates the auth service with Echo
+package echoauth
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/rb4807/Golang-Utlis/adapters"
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/router"
+)
+
+// Router is implemented by *echo.Echo and *echo.Group
+type Router interface {
+	Any(path string, handler echo.HandlerFunc, middleware ...echo.MiddlewareFunc) []*echo.Route
+}
+
+// Auth requires a valid token or session, like auth.Service.AuthMiddleware
+func Auth(authService *auth.Service) echo.MiddlewareFunc {
+	return echo.WrapMiddleware(authService.AuthMiddleware)
+}
+
+// Admin requires an admin user, like auth.Service.AdminMiddleware
+func Admin(authService *auth.Service) echo.MiddlewareFunc {
+	return echo.WrapMiddleware(authService.AdminMiddleware)
+}
+
+// Superuser requires a superuser, like auth.Service.SuperuserMiddleware
+func Superuser(authService *auth.Service) echo.MiddlewareFunc {
+	return echo.WrapMiddleware(authService.SuperuserMiddleware)
+}
+
+// RequireAuth requires a user whose claims pass check, like
+// auth.Service.RequireAuth
+func RequireAuth(authService *auth.Service, check func(*auth.TokenClaims) bool, message string) echo.MiddlewareFunc {
+	return echo.WrapMiddleware(authService.RequireAuth(check, message))
+}
+
+// Claims returns the claims of the authenticated user
+func Claims(c echo.Context) (*auth.TokenClaims, error) {
+	return auth.GetUserFromContext(c.Request().Context())
+}
+
+// Mount registers the routes of router.SetupRoutes on r
+func Mount(r Router, authService *auth.Service, opts ...router.Option) {
+	MountRoutes(r, router.Routes(authService, opts...))
+}
+
+// MountRoutes registers routes on r, for every method. Path parameters are
+// available to the handlers through Request.PathValue.
+func MountRoutes(r Router, routes []router.Route) {
+	for _, route := range routes {
+		h := route.Handler
+		r.Any(adapters.ColonPattern(route.Pattern), func(c echo.Context) error {
+			req := c.Request()
+			for i, name := range c.ParamNames() {
+				req.SetPathValue(name, c.ParamValues()[i])
+			}
+			h.ServeHTTP(c.Response(), req)
+			return nil
+		})
+	}
+}
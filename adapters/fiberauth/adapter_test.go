@@ -0,0 +1,41 @@
+package fiberauth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/rb4807/Golang-Utlis/adapters/conformance"
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/authtest"
+	"github.com/rb4807/Golang-Utlis/router"
+)
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, authtest.New(t), conformanceAdapter())
+}
+
+// conformanceAdapter describes this adapter to the conformance suite
+func conformanceAdapter() conformance.Adapter {
+	return conformance.Adapter{
+		Name: "fiber",
+		New: func(authService *auth.Service, routes []router.Route) http.Handler {
+			app := fiber.New(fiber.Config{DisableStartupMessage: true})
+			MountRoutes(app, routes)
+
+			probe := func(c *fiber.Ctx) error {
+				claims, err := Claims(c)
+				if err != nil {
+					return err
+				}
+				return c.SendString(conformance.ProbeBody(claims))
+			}
+			app.Get(conformance.UserPath, Auth(authService), probe)
+			app.Get(conformance.AdminPath, Admin(authService), probe)
+			app.Get(conformance.SuperuserPath, Superuser(authService), probe)
+			app.Get(conformance.CheckPath, RequireAuth(authService, conformance.Check, conformance.CheckMessage), probe)
+			return adaptor.FiberApp(app)
+		},
+	}
+}
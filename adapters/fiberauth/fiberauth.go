@@ -0,0 +1,93 @@
+// Package fiberauth integrates the auth service with Fiber. Fiber is not
+// built on net/http, so requests are converted at the boundary and claims
+// are passed to Fiber handlers through Ctx.Locals.
+package fiberauth
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/rb4807/Golang-Utlis/adapters"
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/router"
+)
+
+// ClaimsKey is the Ctx.Locals key holding the authenticated user's claims
+const ClaimsKey = "auth.claims"
+
+// Auth requires a valid token or session, like auth.Service.AuthMiddleware
+func Auth(authService *auth.Service) fiber.Handler {
+	return Wrap(authService.AuthMiddleware)
+}
+
+// Admin requires an admin user, like auth.Service.AdminMiddleware
+func Admin(authService *auth.Service) fiber.Handler {
+	return Wrap(authService.AdminMiddleware)
+}
+
+// Superuser requires a superuser, like auth.Service.SuperuserMiddleware
+func Superuser(authService *auth.Service) fiber.Handler {
+	return Wrap(authService.SuperuserMiddleware)
+}
+
+// RequireAuth requires a user whose claims pass check, like
+// auth.Service.RequireAuth
+func RequireAuth(authService *auth.Service, check func(*auth.TokenClaims) bool, message string) fiber.Handler {
+	return Wrap(authService.RequireAuth(check, message))
+}
+
+// Wrap converts net/http middleware to Fiber middleware. Requests the
+// middleware rejects get its response; others continue with the claims it
+// found stored under ClaimsKey.
+func Wrap(mw func(http.Handler) http.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var claims *auth.TokenClaims
+		var passed bool
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var next *http.Request
+			if next, passed = adapters.Run(mw, w, r); passed {
+				claims, _ = auth.GetUserFromContext(next.Context())
+			}
+		})
+		if err := adaptor.HTTPHandler(h)(c); err != nil {
+			return err
+		}
+		if !passed {
+			return nil
+		}
+		c.Locals(ClaimsKey, claims)
+		return c.Next()
+	}
+}
+
+// Claims returns the claims of the authenticated user
+func Claims(c *fiber.Ctx) (*auth.TokenClaims, error) {
+	claims, ok := c.Locals(ClaimsKey).(*auth.TokenClaims)
+	if !ok || claims == nil {
+		return nil, auth.ErrUserNotInContext
+	}
+	return claims, nil
+}
+
+// Mount registers the routes of router.SetupRoutes on r
+func Mount(r fiber.Router, authService *auth.Service, opts ...router.Option) {
+	MountRoutes(r, router.Routes(authService, opts...))
+}
+
+// MountRoutes registers routes on r, for every method. Path parameters are
+// available to the handlers through Request.PathValue.
+func MountRoutes(r fiber.Router, routes []router.Route) {
+	for _, route := range routes {
+		h := route.Handler
+		r.All(adapters.ColonPattern(route.Pattern), func(c *fiber.Ctx) error {
+			params := c.AllParams()
+			return adaptor.HTTPHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for name, value := range params {
+					r.SetPathValue(name, value)
+				}
+				h.ServeHTTP(w, r)
+			})(c)
+		})
+	}
+}
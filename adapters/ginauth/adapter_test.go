@@ -0,0 +1,42 @@
+package ginauth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rb4807/Golang-Utlis/adapters/conformance"
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/authtest"
+	"github.com/rb4807/Golang-Utlis/router"
+)
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, authtest.New(t), conformanceAdapter())
+}
+
+// conformanceAdapter describes this adapter to the conformance suite
+func conformanceAdapter() conformance.Adapter {
+	return conformance.Adapter{
+		Name: "gin",
+		New: func(authService *auth.Service, routes []router.Route) http.Handler {
+			gin.SetMode(gin.ReleaseMode)
+			engine := gin.New()
+			MountRoutes(engine, routes)
+
+			probe := func(c *gin.Context) {
+				claims, err := Claims(c)
+				if err != nil {
+					c.String(http.StatusInternalServerError, err.Error())
+					return
+				}
+				c.String(http.StatusOK, conformance.ProbeBody(claims))
+			}
+			engine.GET(conformance.UserPath, Auth(authService), probe)
+			engine.GET(conformance.AdminPath, Admin(authService), probe)
+			engine.GET(conformance.SuperuserPath, Superuser(authService), probe)
+			engine.GET(conformance.CheckPath, RequireAuth(authService, conformance.Check, conformance.CheckMessage), probe)
+			return engine
+		},
+	}
+}
@@ -0,0 +1,71 @@
+// Package ginauth integrates the auth service with Gin
+package ginauth
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rb4807/Golang-Utlis/adapters"
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/router"
+)
+
+// Auth requires a valid token or session, like auth.Service.AuthMiddleware
+func Auth(authService *auth.Service) gin.HandlerFunc {
+	return Wrap(authService.AuthMiddleware)
+}
+
+// Admin requires an admin user, like auth.Service.AdminMiddleware
+func Admin(authService *auth.Service) gin.HandlerFunc {
+	return Wrap(authService.AdminMiddleware)
+}
+
+// Superuser requires a superuser, like auth.Service.SuperuserMiddleware
+func Superuser(authService *auth.Service) gin.HandlerFunc {
+	return Wrap(authService.SuperuserMiddleware)
+}
+
+// RequireAuth requires a user whose claims pass check, like
+// auth.Service.RequireAuth
+func RequireAuth(authService *auth.Service, check func(*auth.TokenClaims) bool, message string) gin.HandlerFunc {
+	return Wrap(authService.RequireAuth(check, message))
+}
+
+// Wrap converts net/http middleware to Gin middleware. Requests the
+// middleware rejects are aborted; others continue with the request it
+// passed on.
+func Wrap(mw func(http.Handler) http.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r, ok := adapters.Run(mw, c.Writer, c.Request)
+		if !ok {
+			c.Abort()
+			return
+		}
+		c.Request = r
+		c.Next()
+	}
+}
+
+// Claims returns the claims of the authenticated user
+func Claims(c *gin.Context) (*auth.TokenClaims, error) {
+	return auth.GetUserFromContext(c.Request.Context())
+}
+
+// Mount registers the routes of router.SetupRoutes on r
+func Mount(r gin.IRoutes, authService *auth.Service, opts ...router.Option) {
+	MountRoutes(r, router.Routes(authService, opts...))
+}
+
+// MountRoutes registers routes on r, for every method. Path parameters are
+// available to the handlers through Request.PathValue.
+func MountRoutes(r gin.IRoutes, routes []router.Route) {
+	for _, route := range routes {
+		h := route.Handler
+		r.Any(adapters.ColonPattern(route.Pattern), func(c *gin.Context) {
+			for _, param := range c.Params {
+				c.Request.SetPathValue(param.Key, param.Value)
+			}
+			h.ServeHTTP(c.Writer, c.Request)
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// APIKeyPrefix marks API keys so they can be told apart from JWTs and
+// detected by secret scanners
+const APIKeyPrefix = "gu_"
+
+// apiKeyLookupLength is the length of the public lookup prefix of a key
+const apiKeyLookupLength = 12
+
+// Scopes that AuthMiddleware and AdminMiddleware require of scoped API keys
+const (
+	ScopeRead  = "read"  // GET, HEAD and OPTIONS requests
+	ScopeWrite = "write" // Requests that change state
+	ScopeAdmin = "admin" // Admin and superuser routes, in addition to read or write
+)
+
+// APIKey is a long-lived personal access token. The key itself is only
+// returned once, when it is created. A key with an OrganizationID selects
+// that organization, as a token from LoginToOrganization does.
+type APIKey struct {
+	ID             int64      `json:"id"`
+	UserID         int64      `json:"user_id"`
+	OrganizationID int64      `json:"organization_id,omitempty"`
+	Name           string     `json:"name"`
+	Prefix         string     `json:"prefix"`
+	Scopes         []string   `json:"scopes"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	LastUsedAt     *time.Time `json:"last_used_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// CreateAPIKey creates a named API key for a user and returns the key.
+// A nil expiresAt creates a key that does not expire. A key with scopes
+// only passes AuthMiddleware for requests those scopes allow: ScopeRead
+// for safe methods, ScopeWrite for others and ScopeAdmin on admin routes.
+// A key without scopes can do anything its owner can, except manage keys.
+// Scopes other than those three return ErrInvalidScope. When the
+// credentials in ctx select an organization, the key selects it too, and
+// the user must be one of its members.
+func (s *Service) CreateAPIKey(ctx context.Context, userID int64, name string, scopes []string, expiresAt *time.Time) (_ *APIKey, _ string, err error) {
+	ctx, span := s.startSpan(ctx, "CreateAPIKey", userIDAttr(userID))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, EventAPIKeyCreate, userID, err) }()
+
+	if usingAPIKey(ctx) {
+		return nil, "", ErrAPIKeyManagement
+	}
+	if strings.TrimSpace(name) == "" {
+		return nil, "", ErrAPIKeyNameRequired
+	}
+	if !validScopes(scopes) {
+		return nil, "", ErrInvalidScope
+	}
+	// expires_at is a TIMESTAMP column, so it is written in UTC
+	if expiresAt != nil {
+		utc := expiresAt.UTC()
+		expiresAt = &utc
+	}
+
+	exists, err := s.userExists(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if !exists {
+		return nil, "", ErrUserNotFound
+	}
+	orgID := callerOrgID(ctx)
+	if orgID != 0 {
+		if _, err := s.GetMembership(ctx, orgID, userID); err != nil {
+			return nil, "", err
+		}
+	}
+
+	lookup, err := s.randomBytes(apiKeyLookupLength / 2)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := s.randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	prefix := hex.EncodeToString(lookup)
+	key := APIKeyPrefix + prefix + "_" + secret
+
+	apiKey := APIKey{
+		UserID:         userID,
+		OrganizationID: orgID,
+		Name:           name,
+		Prefix:         prefix,
+		Scopes:         scopes,
+		ExpiresAt:      expiresAt,
+	}
+	err = s.db.QueryRowContext(
+		ctx,
+		"INSERT INTO api_keys (user_id, organization_id, name, prefix, key_hash, scopes, expires_at) VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, $7) RETURNING id, created_at",
+		userID, orgID, name, prefix, hashAPIKey(key), strings.Join(scopes, " "), expiresAt,
+	).Scan(&apiKey.ID, &apiKey.CreatedAt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &apiKey, key, nil
+}
+
+// validScopes reports whether every scope is one AuthMiddleware knows. An
+// empty scope would be read back as no scopes, turning the key into an
+// unrestricted one.
+func validScopes(scopes []string) bool {
+	for _, scope := range scopes {
+		switch scope {
+		case ScopeRead, ScopeWrite, ScopeAdmin:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ListAPIKeys returns a user's API keys that have not been revoked
+func (s *Service) ListAPIKeys(ctx context.Context, userID int64) (_ []APIKey, err error) {
+	ctx, span := s.startSpan(ctx, "ListAPIKeys", userIDAttr(userID))
+	defer func() { endSpan(span, err) }()
+
+	query := `
+		SELECT id, user_id, COALESCE(organization_id, 0), name, prefix, scopes, expires_at, last_used_at, created_at
+		FROM api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var apiKey APIKey
+		var scopes string
+		err := rows.Scan(
+			&apiKey.ID,
+			&apiKey.UserID,
+			&apiKey.OrganizationID,
+			&apiKey.Name,
+			&apiKey.Prefix,
+			&scopes,
+			&apiKey.ExpiresAt,
+			&apiKey.LastUsedAt,
+			&apiKey.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		apiKey.Scopes = strings.Fields(scopes)
+		keys = append(keys, apiKey)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes one of a user's API keys
+func (s *Service) RevokeAPIKey(ctx context.Context, userID, keyID int64) (err error) {
+	ctx, span := s.startSpan(ctx, "RevokeAPIKey", userIDAttr(userID))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, EventAPIKeyRevoke, userID, err) }()
+
+	if usingAPIKey(ctx) {
+		return ErrAPIKeyManagement
+	}
+
+	result, err := s.db.ExecContext(
+		ctx,
+		"UPDATE api_keys SET revoked_at = $3 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL",
+		keyID, userID, s.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// VerifyAPIKey validates an API key and returns claims for its owner,
+// equivalent to those of a JWT but limited to the key's scopes
+func (s *Service) VerifyAPIKey(key string) (*TokenClaims, error) {
+	return s.VerifyAPIKeyContext(context.Background(), key)
+}
+
+// VerifyAPIKeyContext is like VerifyAPIKey, and traces the verification as
+// part of ctx
+func (s *Service) VerifyAPIKeyContext(ctx context.Context, key string) (_ *TokenClaims, err error) {
+	ctx, span := s.startSpan(ctx, "VerifyAPIKey")
+	defer func() { endSpan(span, err) }()
+	defer func() { s.metrics.TokenVerification("api_key", verificationResult(err)) }()
+
+	prefix, ok := parseAPIKey(key)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	query := `
+		SELECT k.id, k.key_hash, k.scopes, k.expires_at, COALESCE(k.organization_id, 0), COALESCE(m.role, ''), u.id, u.username, u.is_superuser
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		LEFT JOIN organization_members m ON m.organization_id = k.organization_id AND m.user_id = k.user_id
+		WHERE k.prefix = $1 AND k.revoked_at IS NULL AND u.is_active = true
+			AND (k.expires_at IS NULL OR k.expires_at > $2)
+			AND (k.organization_id IS NULL OR m.user_id IS NOT NULL)
+	`
+	now := s.Now()
+
+	var keyID int64
+	var keyHash, scopes string
+	var expiresAt *time.Time
+	var claims TokenClaims
+	err = s.db.QueryRowContext(ctx, query, prefix, now).Scan(
+		&keyID,
+		&keyHash,
+		&scopes,
+		&expiresAt,
+		&claims.OrgID,
+		&claims.OrgRole,
+		&claims.UserID,
+		&claims.Username,
+		&claims.IsSuperuser,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(keyHash), []byte(hashAPIKey(key))) != 1 {
+		return nil, ErrInvalidToken
+	}
+
+	// Record usage, at most once a minute to avoid a write per request
+	_, err = s.db.ExecContext(
+		ctx,
+		"UPDATE api_keys SET last_used_at = $2 WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $3)",
+		keyID, now, now.Add(-time.Minute),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	claims.Scope = scopes
+	claims.APIKeyID = keyID
+	if expiresAt != nil {
+		claims.ExpiresAt = expiresAt.Unix()
+	}
+	return &claims, nil
+}
+
+// parseAPIKey checks the key format and returns its lookup prefix
+func parseAPIKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return "", false
+	}
+	rest := key[len(APIKeyPrefix):]
+	if len(rest) <= apiKeyLookupLength+1 || rest[apiKeyLookupLength] != '_' {
+		return "", false
+	}
+	return rest[:apiKeyLookupLength], true
+}
+
+// usingAPIKey reports whether the caller in ctx authenticated with an API
+// key. Keys cannot manage keys, or a scoped or expiring key could mint
+// itself an unrestricted, permanent one.
+func usingAPIKey(ctx context.Context) bool {
+	claims, err := GetUserFromContext(ctx)
+	return err == nil && claims.APIKeyID != 0
+}
+
+// hashAPIKey hashes an API key for storage. Keys carry 256 bits of entropy,
+// so a fast hash is sufficient and keeps per-request verification cheap.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,17 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCreateAPIKeyRejectsInvalidScopes(t *testing.T) {
+	s := newTxService(t, &txDriver{})
+
+	for _, scopes := range [][]string{{""}, {"delete"}, {ScopeRead, "Read"}, {" "}} {
+		if _, _, err := s.CreateAPIKey(context.Background(), 1, "key", scopes, nil); !errors.Is(err, ErrInvalidScope) {
+			t.Errorf("scopes %q: err %v, want ErrInvalidScope", scopes, err)
+		}
+	}
+}
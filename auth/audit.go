@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Audit event types
+const (
+	EventRegister         = "register"
+	EventLogin            = "login"
+	EventExternalLogin    = "external_login"
+	EventPasswordChange   = "password_change"
+	EventPasswordReset    = "password_reset"
+	EventOTPGenerate      = "otp_generate"
+	EventOTPVerify        = "otp_verify"
+	EventUserUpdate       = "user_update"
+	EventTokenRevoke      = "token_revoke"
+	EventSessionRevoke    = "session_revoke"
+	EventAPIKeyCreate     = "api_key_create"
+	EventAPIKeyRevoke     = "api_key_revoke"
+	EventOrgCreate        = "organization_create"
+	EventOrgSwitch        = "organization_switch"
+	EventOrgMemberAdd     = "organization_member_add"
+	EventOrgMemberUpdate  = "organization_member_update"
+	EventOrgMemberRemove  = "organization_member_remove"
+	EventInvitationCreate = "invitation_create"
+	EventInvitationRevoke = "invitation_revoke"
+	EventInvitationResend = "invitation_resend"
+	EventInvitationAccept = "invitation_accept"
+)
+
+// Audit event outcomes
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// AuditEvent records a security-relevant authentication event.
+// ActorID is the signed-in user who performed the action, if any;
+// TargetUserID is the user the action applied to. OrganizationID is the
+// organization the actor's credentials selected, or the one being logged
+// in to.
+type AuditEvent struct {
+	ID             int64     `json:"id"`
+	Type           string    `json:"type"`
+	ActorID        *int64    `json:"actor_id"`
+	TargetUserID   *int64    `json:"target_user_id"`
+	OrganizationID *int64    `json:"organization_id"`
+	IP             string    `json:"ip"`
+	UserAgent      string    `json:"user_agent"`
+	Outcome        string    `json:"outcome"`
+	Reason         string    `json:"reason,omitempty"`
+	OccurredAt     time.Time `json:"occurred_at"`
+}
+
+// auditOrgContextKey stores the organization a login is made to, for the
+// audit events recorded before the user has credentials selecting it
+const auditOrgContextKey contextKey = "audit_org"
+
+// withAuditOrganization attributes the audit events recorded with ctx to
+// an organization
+func withAuditOrganization(ctx context.Context, orgID int64) context.Context {
+	return context.WithValue(ctx, auditOrgContextKey, orgID)
+}
+
+// AuditSink receives audit events
+type AuditSink interface {
+	Record(ctx context.Context, event AuditEvent) error
+}
+
+// SQLAuditSink appends audit events to the auth_events table
+type SQLAuditSink struct {
+	DB *sql.DB
+
+	pool *instrumentedDB // The service's pool over DB, when the service created the sink
+}
+
+// conn returns the pool statements run on
+func (sink *SQLAuditSink) conn() querier {
+	if sink.pool != nil {
+		return sink.pool
+	}
+	return sink.DB
+}
+
+// NewSQLAuditSink creates an audit sink backed by the auth_events table
+func NewSQLAuditSink(db *sql.DB) *SQLAuditSink {
+	return &SQLAuditSink{DB: db}
+}
+
+// Record appends an event to the auth_events table
+func (sink *SQLAuditSink) Record(ctx context.Context, event AuditEvent) error {
+	_, err := sink.conn().ExecContext(ctx, `
+		INSERT INTO auth_events (event_type, actor_id, target_user_id, organization_id, ip, user_agent, outcome, reason, occurred_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`,
+		event.Type,
+		event.ActorID,
+		event.TargetUserID,
+		event.OrganizationID,
+		event.IP,
+		event.UserAgent,
+		event.Outcome,
+		event.Reason,
+		event.OccurredAt,
+	)
+	return err
+}
+
+// AuditQuery filters audit events. Zero values match everything.
+type AuditQuery struct {
+	UserID int64 // Matches events where the user is the actor or the target
+	Type   string
+	From   time.Time // In any zone; compared as UTC
+	To     time.Time // In any zone; compared as UTC
+	Limit  int       // Default: 100, maximum: 1000
+}
+
+// ListAuditEvents returns audit events from the auth_events table, newest
+// first. When the credentials in ctx select an organization, only its events
+// are returned.
+func (s *Service) ListAuditEvents(ctx context.Context, q AuditQuery) (_ []AuditEvent, err error) {
+	ctx, span := s.startSpan(ctx, "ListAuditEvents")
+	defer func() { endSpan(span, err) }()
+
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if orgID := callerOrgID(ctx); orgID != 0 {
+		addCondition("organization_id = $%d", orgID)
+	}
+	if q.UserID != 0 {
+		addCondition("(actor_id = $%[1]d OR target_user_id = $%[1]d)", q.UserID)
+	}
+	if q.Type != "" {
+		addCondition("event_type = $%d", q.Type)
+	}
+	// occurred_at is a TIMESTAMP column holding UTC
+	if !q.From.IsZero() {
+		addCondition("occurred_at >= $%d", q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		addCondition("occurred_at < $%d", q.To.UTC())
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	if limit > 1000 {
+		limit = 1000
+	}
+
+	query := `
+		SELECT id, event_type, actor_id, target_user_id, organization_id, ip, user_agent, outcome, reason, occurred_at
+		FROM auth_events
+	`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY occurred_at DESC, id DESC LIMIT %d", limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		err := rows.Scan(
+			&event.ID,
+			&event.Type,
+			&event.ActorID,
+			&event.TargetUserID,
+			&event.OrganizationID,
+			&event.IP,
+			&event.UserAgent,
+			&event.Outcome,
+			&event.Reason,
+			&event.OccurredAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// audit records the outcome of an operation on targetUserID (0 if unknown).
+// A nil err records a success; otherwise the error becomes the reason.
+func (s *Service) audit(ctx context.Context, eventType string, targetUserID int64, err error) {
+	if err != nil {
+		s.auditFailure(ctx, eventType, targetUserID, err.Error())
+		return
+	}
+	s.recordAudit(ctx, eventType, targetUserID, OutcomeSuccess, "")
+}
+
+// auditFailure records a failed operation with an explicit reason
+func (s *Service) auditFailure(ctx context.Context, eventType string, targetUserID int64, reason string) {
+	s.recordAudit(ctx, eventType, targetUserID, OutcomeFailure, reason)
+}
+
+// recordAudit fills in the actor and client from ctx and sends the event to the sink
+func (s *Service) recordAudit(ctx context.Context, eventType string, targetUserID int64, outcome, reason string) {
+	s.metrics.Operation(eventType, outcome)
+
+	client := ClientInfoFromContext(ctx)
+	event := AuditEvent{
+		Type:       eventType,
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
+		Outcome:    outcome,
+		Reason:     reason,
+		OccurredAt: s.Now(),
+	}
+	if claims, err := GetUserFromContext(ctx); err == nil {
+		event.ActorID = &claims.UserID
+		if claims.OrgID != 0 {
+			event.OrganizationID = &claims.OrgID
+		}
+	}
+	if orgID, ok := ctx.Value(auditOrgContextKey).(int64); ok && event.OrganizationID == nil {
+		event.OrganizationID = &orgID
+	}
+	if targetUserID != 0 {
+		event.TargetUserID = &targetUserID
+	}
+
+	// The operation has already happened, so a failure to record it must not
+	// change its result
+	if err := s.auditSink.Record(context.WithoutCancel(ctx), event); err != nil {
+		s.logger.ErrorContext(ctx, "audit event not recorded", "event_type", eventType, "outcome", outcome, "error", err)
+	}
+}
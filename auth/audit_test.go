@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// queryRecorder is a database that answers every query with no rows and
+// keeps the arguments of the last one
+type queryRecorder struct {
+	args []driver.NamedValue
+}
+
+func (r *queryRecorder) Connect(context.Context) (driver.Conn, error) { return recorderConn{r}, nil }
+func (r *queryRecorder) Driver() driver.Driver                        { return nil }
+
+type recorderConn struct{ r *queryRecorder }
+
+func (c recorderConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c recorderConn) Close() error                        { return nil }
+func (c recorderConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c recorderConn) QueryContext(_ context.Context, _ string, args []driver.NamedValue) (driver.Rows, error) {
+	c.r.args = args
+	return noRows{}, nil
+}
+
+type noRows struct{}
+
+func (noRows) Columns() []string         { return nil }
+func (noRows) Close() error              { return nil }
+func (noRows) Next([]driver.Value) error { return io.EOF }
+
+func TestListAuditEventsComparesInUTC(t *testing.T) {
+	recorder := &queryRecorder{}
+	db := sql.OpenDB(recorder)
+	t.Cleanup(func() { db.Close() })
+	s, err := NewService(Config{JWTSecret: "test", DBConnection: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zone := time.FixedZone("UTC+5", 5*60*60)
+	from := time.Date(2026, 3, 1, 17, 0, 0, 0, zone)
+	if _, err := s.ListAuditEvents(context.Background(), AuditQuery{From: from, To: from.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []time.Time{time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC), time.Date(2026, 3, 1, 13, 0, 0, 0, time.UTC)}
+	if len(recorder.args) != len(want) {
+		t.Fatalf("query had %d arguments, want %d", len(recorder.args), len(want))
+	}
+	for i, arg := range recorder.args {
+		got, _ := arg.Value.(time.Time)
+		if got.Location() != time.UTC || !got.Equal(want[i]) {
+			t.Errorf("argument %d is %v, want %v", i+1, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CSRFMode selects how CSRF tokens are issued and checked
+type CSRFMode int
+
+const (
+	// CSRFDoubleSubmit compares a signed token in a cookie with the token sent
+	// in a header or form field. It does not need server-side sessions.
+	CSRFDoubleSubmit CSRFMode = iota
+	// CSRFSynchronizer stores the token in the server-side session
+	CSRFSynchronizer
+)
+
+// csrfContextKey stores the request's CSRF token in its context
+const csrfContextKey contextKey = "csrf_token"
+
+// CSRFConfig configures CSRF protection for cookie-authenticated requests
+type CSRFConfig struct {
+	Mode           CSRFMode
+	CookieName     string   // Default: "csrf_token" (double-submit mode)
+	HeaderName     string   // Default: "X-CSRF-Token"
+	FormField      string   // Default: "csrf_token"
+	TrustedOrigins []string // Hosts allowed besides the request host, e.g. "app.example.com"
+	ExemptPaths    []string // Paths that are never checked
+}
+
+// CSRFMiddleware rejects cross-site state-changing requests. Requests that
+// carry no cookies or authenticate with an Authorization header are exempt,
+// since the browser does not attach those credentials automatically. The
+// request's token is available to handlers via CSRFTokenFromContext;
+// requests with an Authorization header get none, and no token cookie.
+func (s *Service) CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		if r.Header.Get("Authorization") == "" {
+			var err error
+			token, err = s.csrfToken(w, r)
+			if err != nil {
+				http.Error(w, "Error issuing CSRF token", http.StatusInternalServerError)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), csrfContextKey, token))
+		}
+
+		if isSafeMethod(r.Method) || s.isCSRFExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !s.isSameOrigin(r) {
+			http.Error(w, "Cross-origin request rejected", http.StatusForbidden)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "" || len(r.Cookies()) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sent := r.Header.Get(s.csrf.HeaderName)
+		if sent == "" {
+			sent = r.PostFormValue(s.csrf.FormField)
+		}
+		if token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			http.Error(w, "CSRF token missing or invalid", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CSRFTokenFromContext returns the CSRF token set by CSRFMiddleware.
+// JSON clients should echo it in the X-CSRF-Token header.
+func CSRFTokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(csrfContextKey).(string)
+	return token
+}
+
+// CSRFTemplateField returns a hidden form input carrying the CSRF token,
+// for use in server-rendered templates
+func (s *Service) CSRFTemplateField(ctx context.Context) template.HTML {
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(s.csrf.FormField) +
+		`" value="` + template.HTMLEscapeString(CSRFTokenFromContext(ctx)) + `">`)
+}
+
+// csrfToken returns the CSRF token for the request, issuing a new
+// double-submit cookie if the request does not carry a valid one. In
+// synchronizer mode a request without a live session, such as one made
+// before logging in or with an expired session cookie, gets a double-submit
+// token instead, so it can still pass the check.
+func (s *Service) csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if s.csrf.Mode == CSRFSynchronizer {
+		if session := s.liveSession(r); session != nil {
+			return session.CSRFToken, nil
+		}
+	}
+
+	binding := s.csrfBinding(r)
+	if cookie, err := r.Cookie(s.csrf.CookieName); err == nil && s.validCSRFCookie(cookie.Value, binding) {
+		return cookie.Value, nil
+	}
+
+	nonce, err := s.randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	token := nonce + "." + signCSRFNonce(s.signingKey(), binding, nonce)
+
+	secure := true
+	if s.sessions != nil {
+		secure = !s.sessions.Insecure
+	}
+	// Readable by scripts on purpose, so clients can copy it into the header
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.csrf.CookieName,
+		Value:    token,
+		Path:     "/",
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token, nil
+}
+
+// liveSession returns the unexpired session named by the request's session
+// cookie, or nil if there is none
+func (s *Service) liveSession(r *http.Request) *Session {
+	cookie, err := r.Cookie(s.sessions.CookieName)
+	if err != nil {
+		return nil
+	}
+	session, err := s.sessions.Store.Get(r.Context(), hashSessionID(cookie.Value))
+	if err != nil {
+		return nil
+	}
+	now := s.Now()
+	if now.After(session.ExpiresAt) || now.After(session.LastSeenAt.Add(s.sessions.IdleTimeout)) {
+		return nil
+	}
+	return session
+}
+
+// csrfBinding returns the value double-submit tokens are bound to: the
+// hashed session cookie, when sessions are enabled and the request has one
+func (s *Service) csrfBinding(r *http.Request) string {
+	if s.sessions == nil {
+		return ""
+	}
+	cookie, err := r.Cookie(s.sessions.CookieName)
+	if err != nil {
+		return ""
+	}
+	return hashSessionID(cookie.Value)
+}
+
+// validCSRFCookie checks the signature of a double-submit token and that it
+// was issued for binding. A token copied from another visit, for example
+// one planted by a sibling subdomain, does not match the victim's session.
+// Tokens of requests without a session are bound to nothing, so a sibling
+// subdomain can still plant one before login; logging in changes the
+// session cookie, after which such a token no longer validates.
+func (s *Service) validCSRFCookie(token, binding string) bool {
+	nonce, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	// Cookies set before a secret rotation were signed with the old secret
+	for _, key := range s.verificationKeys() {
+		if hmac.Equal([]byte(signature), []byte(signCSRFNonce(key, binding, nonce))) {
+			return true
+		}
+	}
+	return false
+}
+
+// signCSRFNonce signs a double-submit nonce, bound to a session, with the
+// JWT secret
+func signCSRFNonce(secret []byte, binding, nonce string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("csrf:" + binding + ":" + nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// isCSRFExempt checks the configured exempt paths
+func (s *Service) isCSRFExempt(r *http.Request) bool {
+	for _, path := range s.csrf.ExemptPaths {
+		if r.URL.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// isSameOrigin checks the Origin header, falling back to Referer, against
+// the request host and the trusted origins. Requests with neither header
+// are allowed; the token check still applies to them.
+func (s *Service) isSameOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, origin := range s.csrf.TrustedOrigins {
+		if strings.EqualFold(u.Host, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// withCSRFDefaults fills in unset CSRF options
+func withCSRFDefaults(config CSRFConfig) *CSRFConfig {
+	if config.CookieName == "" {
+		config.CookieName = "csrf_token"
+	}
+	if config.HeaderName == "" {
+		config.HeaderName = "X-CSRF-Token"
+	}
+	if config.FormField == "" {
+		config.FormField = "csrf_token"
+	}
+	return &config
+}
+
+// isSafeMethod reports whether an HTTP method must not change state
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// querier runs statements. Both *sql.DB and instrumentedDB satisfy it, so
+// the SQL stores can use the service's instrumented pool when the service
+// creates them, and a plain pool otherwise.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// instrumentedDB wraps the service's connection pool, recording the duration
+// of each call and tracing each statement. Methods it does not override pass
+// straight through.
+type instrumentedDB struct {
+	*sql.DB
+	metrics *metrics.Metrics
+	tracer  trace.Tracer
+	replica bool
+}
+
+func (db *instrumentedDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.ExecContext(context.Background(), query, args...)
+}
+
+func (db *instrumentedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, done := db.observe(ctx, "exec", query)
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	done(err)
+	return result, err
+}
+
+func (db *instrumentedDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.QueryContext(context.Background(), query, args...)
+}
+
+func (db *instrumentedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, done := db.observe(ctx, "query", query)
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	done(err)
+	return rows, err
+}
+
+func (db *instrumentedDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.QueryRowContext(context.Background(), query, args...)
+}
+
+func (db *instrumentedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, done := db.observe(ctx, "query_row", query)
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	done(row.Err())
+	return row
+}
+
+// BeginTx starts a transaction whose statements are instrumented like the
+// pool's own
+func (db *instrumentedDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*dbTx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &dbTx{Tx: tx, db: db}, nil
+}
+
+// dbTx is a transaction started by instrumentedDB
+type dbTx struct {
+	*sql.Tx
+	db *instrumentedDB
+}
+
+func (tx *dbTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, done := tx.db.observe(ctx, "exec", query)
+	result, err := tx.Tx.ExecContext(ctx, query, args...)
+	done(err)
+	return result, err
+}
+
+func (tx *dbTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, done := tx.db.observe(ctx, "query", query)
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	done(err)
+	return rows, err
+}
+
+func (tx *dbTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, done := tx.db.observe(ctx, "query_row", query)
+	row := tx.Tx.QueryRowContext(ctx, query, args...)
+	done(row.Err())
+	return row
+}
+
+// observe starts timing a database call and, when ctx is already part of a
+// trace, a span for the statement. Calls the caller is not tracing are left
+// out rather than started as traces of their own. The returned function
+// finishes both.
+func (db *instrumentedDB) observe(ctx context.Context, call, query string) (context.Context, func(error)) {
+	start := time.Now()
+
+	var span trace.Span
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		ctx, span = db.tracer.Start(ctx, "db."+call,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "postgresql"),
+				attribute.String("db.statement", sanitizeStatement(query)),
+				attribute.Bool("db.replica", db.replica),
+			),
+		)
+	}
+
+	return ctx, func(err error) {
+		// No matching row is a result, not a failed call
+		if err == sql.ErrNoRows {
+			err = nil
+		}
+		db.metrics.ObserveDB(call, start, err)
+		if span != nil {
+			endSpan(span, err)
+		}
+	}
+}
+
+var (
+	statementStrings  = regexp.MustCompile(`'(?:[^']|'')*'`)
+	statementNumbers  = regexp.MustCompile(`([^$\w.])\d+(?:\.\d+)?\b`)
+	statementSpaceRun = regexp.MustCompile(`\s+`)
+)
+
+// sanitizeStatement replaces literal values in a SQL statement with "?" and
+// collapses whitespace, so traces never carry data embedded in a query.
+// Bind parameters ($1, $2, ...) are kept.
+func sanitizeStatement(query string) string {
+	query = statementStrings.ReplaceAllString(query, "?")
+	query = statementNumbers.ReplaceAllString(query, "${1}?")
+	return strings.TrimSpace(statementSpaceRun.ReplaceAllString(query, " "))
+}
+
+// queryReplica runs a single-row, read-only query on the next read replica
+// in turn and scans the row into dest. It falls back to the primary when
+// there are no replicas, when the replica fails, and when the replica has no
+// matching row, which may only mean it has not caught up with a recent
+// write. Queries whose result must reflect the latest writes, and anything
+// inside a transaction, belong on s.db.
+func (s *Service) queryReplica(ctx context.Context, query string, args []interface{}, dest ...interface{}) error {
+	if len(s.replicas) > 0 {
+		replica := s.replicas[s.nextReplica.Add(1)%uint64(len(s.replicas))]
+		err := replica.QueryRowContext(ctx, query, args...).Scan(dest...)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if err != sql.ErrNoRows {
+			s.logger.WarnContext(ctx, "replica query failed, retrying on the primary", "error", err)
+		}
+	}
+	return s.db.QueryRowContext(ctx, query, args...).Scan(dest...)
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Session types reported by ListSessions
+const (
+	SessionTypeToken  = "token"  // A JWT login, identified by its token ID
+	SessionTypeCookie = "cookie" // A cookie session
+)
+
+// SessionInfo describes a place where a user is signed in
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// issueLoginToken generates a JWT for a user and records the login session.
+// A non-nil membership selects its organization in the token.
+func (s *Service) issueLoginToken(ctx context.Context, user *User, membership *Membership) (string, error) {
+	token, claims, err := s.generateJWT(user, membership)
+	if err != nil {
+		return "", err
+	}
+
+	client := ClientInfoFromContext(ctx)
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO login_sessions (jti, user_id, ip, user_agent, created_at, last_seen_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $5, $6)
+	`,
+		claims.Id,
+		user.ID,
+		client.IP,
+		client.UserAgent,
+		time.Unix(claims.IssuedAt, 0),
+		time.Unix(claims.ExpiresAt, 0),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// touchLoginSession updates a login session's last-seen time, at most once a minute
+func (s *Service) touchLoginSession(ctx context.Context, tokenID string) error {
+	now := s.Now()
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE login_sessions SET last_seen_at = $2 WHERE jti = $1 AND last_seen_at < $3",
+		tokenID, now, now.Add(-time.Minute),
+	)
+	return err
+}
+
+// ListSessions returns a user's active token logins and cookie sessions,
+// most recently used first. The session making the request, taken from the
+// claims in ctx, is marked as current.
+func (s *Service) ListSessions(ctx context.Context, userID int64) (_ []SessionInfo, err error) {
+	ctx, span := s.startSpan(ctx, "ListSessions", userIDAttr(userID))
+	defer func() { endSpan(span, err) }()
+
+	currentID := ""
+	if claims, err := GetUserFromContext(ctx); err == nil && claims.UserID == userID {
+		currentID = claims.Id
+	}
+
+	query := `
+		SELECT jti, ip, user_agent, created_at, last_seen_at, expires_at
+		FROM login_sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID, s.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []SessionInfo{}
+	for rows.Next() {
+		info := SessionInfo{Type: SessionTypeToken}
+		err := rows.Scan(
+			&info.ID,
+			&info.IP,
+			&info.UserAgent,
+			&info.CreatedAt,
+			&info.LastSeenAt,
+			&info.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		info.Current = info.ID == currentID
+		sessions = append(sessions, info)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if s.sessions != nil {
+		cookieSessions, err := s.sessions.Store.List(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		now := s.Now()
+		for _, session := range cookieSessions {
+			// Stores only know the absolute expiry; idle sessions no longer
+			// authenticate, so they are not listed either
+			if now.After(session.LastSeenAt.Add(s.sessions.IdleTimeout)) {
+				continue
+			}
+			sessions = append(sessions, SessionInfo{
+				ID:         session.ID,
+				Type:       SessionTypeCookie,
+				IP:         session.IP,
+				UserAgent:  session.UserAgent,
+				CreatedAt:  session.CreatedAt,
+				LastSeenAt: session.LastSeenAt,
+				ExpiresAt:  session.ExpiresAt,
+				Current:    session.ID == currentID,
+			})
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+// RevokeSession signs a user out of one session
+func (s *Service) RevokeSession(ctx context.Context, userID int64, sessionID string) (err error) {
+	ctx, span := s.startSpan(ctx, "RevokeSession", userIDAttr(userID))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, EventSessionRevoke, userID, err) }()
+
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE login_sessions SET revoked_at = $3 WHERE jti = $1 AND user_id = $2 AND revoked_at IS NULL",
+		sessionID, userID, s.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return s.revokeTokenID(ctx, sessionID, userID)
+	}
+
+	if s.sessions != nil {
+		session, err := s.sessions.Store.Get(ctx, sessionID)
+		if err == nil && session.UserID == userID {
+			return s.sessions.Store.Delete(ctx, sessionID)
+		}
+		if err != nil && err != ErrSessionNotFound {
+			return err
+		}
+	}
+
+	return ErrSessionNotFound
+}
+
+// RevokeOtherSessions signs a user out everywhere except the session with
+// ID keepID. Pass an empty keepID to sign the user out of every session. A
+// keepID that is not one of the user's active sessions returns
+// ErrSessionNotFound without revoking anything, so a caller that meant to
+// stay signed in is never signed out with the rest.
+func (s *Service) RevokeOtherSessions(ctx context.Context, userID int64, keepID string) (err error) {
+	ctx, span := s.startSpan(ctx, "RevokeOtherSessions", userIDAttr(userID))
+	defer func() { endSpan(span, err) }()
+
+	sessions, err := s.ListSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if keepID != "" && !slices.ContainsFunc(sessions, func(session SessionInfo) bool { return session.ID == keepID }) {
+		return ErrSessionNotFound
+	}
+
+	for _, session := range sessions {
+		if session.ID == keepID {
+			continue
+		}
+		if err := s.RevokeSession(ctx, userID, session.ID); err != nil && err != ErrSessionNotFound {
+			return err
+		}
+	}
+	return nil
+}
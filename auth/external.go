@@ -0,0 +1,446 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// externalStateValidity is how long a user has to complete an external login
+const externalStateValidity = 10 * time.Minute
+
+// externalStateCookie holds the secret binding a pending external login to
+// the browser that started it
+const externalStateCookie = "external_auth_state"
+
+// ExternalIdentity links an external provider account to a local user
+type ExternalIdentity struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BeginExternalLogin starts an authorization code flow with PKCE and returns
+// the provider URL to redirect the user to. If linkUserID is non-zero, the
+// external account is linked to that user instead of signing in.
+//
+// It sets a cookie on w that CompleteExternalLogin requires, so the flow
+// must be started by the browser that will follow the redirect. Without
+// it, anyone could send a victim an authorization URL and have the victim's
+// provider account signed in to, or linked with, an account of their own.
+func (s *Service) BeginExternalLogin(ctx context.Context, w http.ResponseWriter, providerName string, linkUserID int64) (_ string, err error) {
+	ctx, span := s.startSpan(ctx, "BeginExternalLogin", attribute.String("auth.provider", providerName))
+	defer func() { endSpan(span, err) }()
+
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+
+	state, err := s.randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := s.randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	binding, err := s.randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := s.Now().Add(externalStateValidity)
+	linkUser := sql.NullInt64{Int64: linkUserID, Valid: linkUserID != 0}
+	_, err = s.db.ExecContext(
+		ctx,
+		"INSERT INTO external_auth_states (state, provider, code_verifier, browser_hash, link_user_id, expires_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		state, provider.Name, verifier, hashBrowserBinding(binding), linkUser, expiresAt,
+	)
+	if err != nil {
+		return "", err
+	}
+	s.setExternalStateCookie(w, binding, expiresAt)
+
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.ClientID},
+		"redirect_uri":          {provider.RedirectURL},
+		"scope":                 {strings.Join(provider.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(provider.AuthURL, "?") {
+		separator = "&"
+	}
+	return provider.AuthURL + separator + params.Encode(), nil
+}
+
+// CompleteExternalLogin handles the provider callback request r. It checks
+// the state belongs to the browser that started the flow, exchanges the
+// code, resolves the local user (linking or creating one as needed) and
+// returns the user together with a JWT. The state cookie is cleared on w.
+func (s *Service) CompleteExternalLogin(w http.ResponseWriter, r *http.Request, providerName, state, code string) (user *User, token string, err error) {
+	ctx := RequestContext(r)
+	ctx, span := s.startSpan(ctx, "CompleteExternalLogin", attribute.String("auth.provider", providerName))
+	defer func() { endSpan(span, err) }()
+	defer func() {
+		var userID int64
+		if user != nil {
+			userID = user.ID
+		}
+		s.audit(ctx, EventExternalLogin, userID, err)
+	}()
+
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return nil, "", ErrUnknownProvider
+	}
+
+	cookie, err := r.Cookie(externalStateCookie)
+	if err != nil {
+		return nil, "", ErrInvalidOAuthState
+	}
+	s.setExternalStateCookie(w, "", time.Unix(0, 0))
+
+	// States are single use, so consume it before doing anything else
+	var verifier string
+	var linkUser sql.NullInt64
+	err = s.db.QueryRowContext(
+		ctx,
+		"DELETE FROM external_auth_states WHERE state = $1 AND provider = $2 AND browser_hash = $3 AND expires_at > $4 RETURNING code_verifier, link_user_id",
+		state, provider.Name, hashBrowserBinding(cookie.Value), s.Now(),
+	).Scan(&verifier, &linkUser)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "", ErrInvalidOAuthState
+		}
+		return nil, "", err
+	}
+
+	accessToken, err := s.exchangeCode(ctx, provider, code, verifier)
+	if err != nil {
+		return nil, "", err
+	}
+
+	profile, err := provider.fetchProfile(ctx, s.httpClient(), accessToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var userID int64
+	if linkUser.Valid {
+		userID = linkUser.Int64
+		if err := s.linkExternalIdentity(ctx, userID, provider.Name, profile); err != nil {
+			return nil, "", err
+		}
+	} else {
+		userID, err = s.findExternalUser(ctx, provider.Name, profile.Subject)
+		if err == ErrUserNotFound {
+			userID, err = s.createExternalUser(ctx, provider.Name, profile)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	user, err = s.GetUserByIDContext(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if !user.IsActive {
+		return nil, "", ErrInvalidCredentials
+	}
+
+	if err := s.recordLogin(ctx, user, ""); err != nil {
+		return nil, "", err
+	}
+
+	token, err = s.issueLoginToken(ctx, user, nil)
+	if err != nil {
+		return user, "", err
+	}
+
+	return user, token, nil
+}
+
+// ListExternalIdentities returns the external accounts linked to a user
+func (s *Service) ListExternalIdentities(userID int64) ([]ExternalIdentity, error) {
+	return s.ListExternalIdentitiesContext(context.Background(), userID)
+}
+
+// ListExternalIdentitiesContext is like ListExternalIdentities, and traces
+// the lookup as part of ctx
+func (s *Service) ListExternalIdentitiesContext(ctx context.Context, userID int64) (_ []ExternalIdentity, err error) {
+	ctx, span := s.startSpan(ctx, "ListExternalIdentities", userIDAttr(userID))
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT id, user_id, provider, subject, COALESCE(email, ''), created_at FROM external_identities WHERE user_id = $1 ORDER BY id",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []ExternalIdentity{}
+	for rows.Next() {
+		var identity ExternalIdentity
+		err := rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.Provider,
+			&identity.Subject,
+			&identity.Email,
+			&identity.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+// exchangeCode trades an authorization code for an access token
+func (s *Service) exchangeCode(ctx context.Context, provider ExternalProvider, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {provider.RedirectURL},
+		"client_id":     {provider.ClientID},
+		"client_secret": {provider.ClientSecret},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("token exchange with %s: %w", provider.Name, err)
+	}
+	if body.Error != "" {
+		return "", fmt.Errorf("token exchange with %s: %s %s", provider.Name, body.Error, body.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || body.AccessToken == "" {
+		return "", fmt.Errorf("token exchange with %s: unexpected status %s", provider.Name, resp.Status)
+	}
+
+	return body.AccessToken, nil
+}
+
+// findExternalUser returns the user linked to a provider account
+func (s *Service) findExternalUser(ctx context.Context, provider, subject string) (int64, error) {
+	var userID int64
+	err := s.db.QueryRowContext(
+		ctx,
+		"SELECT user_id FROM external_identities WHERE provider = $1 AND subject = $2",
+		provider, subject,
+	).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrUserNotFound
+		}
+		return 0, err
+	}
+	return userID, nil
+}
+
+// linkExternalIdentity links a provider account to an existing user
+func (s *Service) linkExternalIdentity(ctx context.Context, userID int64, provider string, profile *ExternalProfile) error {
+	linkedUserID, err := s.findExternalUser(ctx, provider, profile.Subject)
+	if err == nil {
+		if linkedUserID != userID {
+			return ErrIdentityLinked
+		}
+		return nil
+	}
+	if err != ErrUserNotFound {
+		return err
+	}
+
+	return insertExternalIdentity(ctx, s.db, userID, provider, profile)
+}
+
+// insertExternalIdentity records that a provider account belongs to a user
+func insertExternalIdentity(ctx context.Context, q querier, userID int64, provider string, profile *ExternalProfile) error {
+	_, err := q.ExecContext(
+		ctx,
+		"INSERT INTO external_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)",
+		userID, provider, profile.Subject, profile.Email,
+	)
+	return err
+}
+
+// createExternalUser registers a new user for a provider account (just-in-time
+// provisioning). The account and its link are created in one transaction,
+// so a failed link leaves no account behind to block the email address.
+func (s *Service) createExternalUser(ctx context.Context, provider string, profile *ExternalProfile) (userID int64, err error) {
+	if s.config.DisablePublicRegistration {
+		return 0, ErrRegistrationDisabled
+	}
+	if profile.Email == "" {
+		return 0, fmt.Errorf("%s did not return a verified email address", provider)
+	}
+
+	// Never attach to an existing account by email; the owner must link it explicitly
+	var emailTaken bool
+	err = s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)", profile.Email).Scan(&emailTaken)
+	if err != nil {
+		return 0, err
+	}
+	if emailTaken {
+		return 0, ErrExternalEmailInUse
+	}
+
+	username, err := s.availableUsername(ctx, profile)
+	if err != nil {
+		return 0, err
+	}
+
+	// The account has no usable password until the user sets one via a reset
+	password, err := s.randomToken(32)
+	if err != nil {
+		return 0, err
+	}
+
+	user := User{
+		Username:  username,
+		Email:     profile.Email,
+		FirstName: profile.FirstName,
+		LastName:  profile.LastName,
+		IsActive:  true,
+	}
+	hashedPassword, err := s.prepareUser(ctx, user, password, false)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.withTx(ctx, func(tx *dbTx) error {
+		userID, err = s.insertUser(ctx, tx, user, hashedPassword)
+		if err != nil {
+			return err
+		}
+		return insertExternalIdentity(ctx, tx, userID, provider, profile)
+	})
+	if uniqueViolation(err) {
+		// A concurrent login with the same account created it first
+		if linkedUserID, findErr := s.findExternalUser(ctx, provider, profile.Subject); findErr == nil {
+			return linkedUserID, nil
+		}
+	}
+	if err != nil {
+		s.audit(ctx, EventRegister, 0, err)
+		return 0, err
+	}
+	s.audit(ctx, EventRegister, userID, nil)
+
+	return userID, nil
+}
+
+// availableUsername derives an unused username from an external profile
+func (s *Service) availableUsername(ctx context.Context, profile *ExternalProfile) (string, error) {
+	base := profile.Username
+	if base == "" {
+		base, _, _ = strings.Cut(profile.Email, "@")
+	}
+	base = SanitizeUsername(base)
+	if len(base) > 40 {
+		base = base[:40]
+	}
+	if len(base) < 3 {
+		base = "user"
+	}
+
+	candidate := base
+	for i := 0; i < 5; i++ {
+		var taken bool
+		err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", candidate).Scan(&taken)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+
+		suffix, err := s.generateRandomOTP(4)
+		if err != nil {
+			return "", err
+		}
+		candidate = base + "_" + suffix
+	}
+
+	return "", fmt.Errorf("could not find an available username for %q", base)
+}
+
+// setExternalStateCookie writes the browser binding cookie; an empty value
+// clears it. It is sent on the provider's redirect back, a top-level
+// cross-site navigation, so it must be SameSite=Lax rather than Strict.
+func (s *Service) setExternalStateCookie(w http.ResponseWriter, value string, expires time.Time) {
+	secure := true
+	if s.sessions != nil {
+		secure = !s.sessions.Insecure
+	}
+	cookie := &http.Cookie{
+		Name:     externalStateCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// hashBrowserBinding returns the form a browser binding is stored in
+func hashBrowserBinding(binding string) string {
+	sum := sha256.Sum256([]byte(binding))
+	return hex.EncodeToString(sum[:])
+}
+
+// httpClient returns the client used to talk to external providers
+func (s *Service) httpClient() *http.Client {
+	if s.config.HTTPClient != nil {
+		return s.config.HTTPClient
+	}
+	return http.DefaultClient
+}
@@ -1,30 +1,76 @@
 package auth
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"time"
+
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// Register creates a new user
 func (s *Service) Register(user User, password string) (int64, error) {
-	// Validate user data
-	if err := s.validate(user); err != nil {
+	return s.RegisterContext(context.Background(), user, password)
+}
+
+// RegisterContext is like Register, and records an audit event
+func (s *Service) RegisterContext(ctx context.Context, user User, password string) (int64, error) {
+	return s.register(ctx, user, password, true)
+}
+
+// register creates a user. The password policy is skipped for generated
+// passwords, which the user never types.
+func (s *Service) register(ctx context.Context, user User, password string, checkPolicy bool) (userID int64, err error) {
+	ctx, span := s.startSpan(ctx, "Register")
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, EventRegister, userID, err) }()
+	
+	hashedPassword, err := s.prepareUser(ctx, user, password, checkPolicy)
+	if err != nil {
 		return 0, err
 	}
 
-	// Hash password
-	hashedPassword, err := s.HashPassword(password)
+	err = s.withTx(ctx, func(tx *dbTx) error {
+		userID, err = s.insertUser(ctx, tx, user, hashedPassword)
+		return err
+	})
+	
 	if err != nil {
 		return 0, err
 	}
+	
+	return userID, nil
+}
 
-	// Insert user into database
+// prepareUser validates a new user and returns the password hash to store
+func (s *Service) prepareUser(ctx context.Context, user User, password string, checkPolicy bool) (string, error) {
+	// Validate user data
+	if err := s.validate(user); err != nil {
+		return "", err
+	}
+	if checkPolicy {
+		if err := s.config.PasswordPolicy.Check(password); err != nil {
+			return "", err
+		}
+	}
+
+	// Hash password
+	return s.HashPasswordContext(ctx, password)
+}
+
+// insertUser inserts a user prepared by prepareUser as part of tx, and
+// queues the registration webhook with it
+func (s *Service) insertUser(ctx context.Context, tx *dbTx, user User, hashedPassword string) (int64, error) {
 	query := `
 		INSERT INTO users (username, email, password, first_name, last_name, is_active, is_superuser, date_joined)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		RETURNING id
 	`
 	var userID int64
-	err = s.config.DBConnection.QueryRow(
+	err := tx.QueryRowContext(
+		ctx,
 		query,
 		user.Username,
 		user.Email,
@@ -33,17 +79,48 @@ func (s *Service) Register(user User, password string) (int64, error) {
 		user.LastName,
 		user.IsActive,
 		user.IsSuperuser,
+		s.Now(),
 	).Scan(&userID)
-	
 	if err != nil {
 		return 0, err
 	}
 	
+	err = s.enqueueWebhookEvent(ctx, tx, WebhookUserRegistered, map[string]interface{}{
+		"user_id":  userID,
+		"username": user.Username,
+		"email":    user.Email,
+	})
+	if err != nil {
+		return 0, err
+	}
 	return userID, nil
 }
 
 // Authenticate verifies a user's credentials
 func (s *Service) Authenticate(username, password string) (*User, error) {
+	return s.AuthenticateContext(context.Background(), username, password)
+}
+
+// AuthenticateContext is like Authenticate, and records an audit event
+func (s *Service) AuthenticateContext(ctx context.Context, username, password string) (_ *User, err error) {
+	ctx, span := s.startSpan(ctx, "Authenticate")
+	defer func() { endSpan(span, err) }()
+	
+	user, err := s.checkCredentials(ctx, username, password)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.completeLogin(ctx, user); err != nil {
+		return nil, err
+	}
+	
+	return user, nil
+}
+
+// checkCredentials returns the active user with username (or email) and
+// password. Failures are audited; success is not, as the login is only
+// recorded by completeLogin.
+func (s *Service) checkCredentials(ctx context.Context, username, password string) (*User, error) {
 	query := `
 		SELECT id, username, email, password, first_name, last_name, is_active, is_superuser, date_joined, last_login, password_changed
 		FROM users
@@ -51,7 +128,7 @@ func (s *Service) Authenticate(username, password string) (*User, error) {
 	`
 	
 	var user User
-	err := s.config.DBConnection.QueryRow(query, username).Scan(
+	err := s.db.QueryRowContext(ctx, query, username).Scan(
 		&user.ID,
 		&user.Username,
 		&user.Email,
@@ -67,36 +144,52 @@ func (s *Service) Authenticate(username, password string) (*User, error) {
 	
 	if err != nil {
 		if err == sql.ErrNoRows {
+			s.auditFailure(ctx, EventLogin, 0, "unknown or inactive user")
 			return nil, ErrInvalidCredentials
 		}
+		s.audit(ctx, EventLogin, 0, err)
 		return nil, err
 	}
 	
 	// Verify password
-	if !s.VerifyPassword(user.Password, password) {
+	if !s.VerifyPasswordContext(ctx, user.Password, password) {
+		s.auditFailure(ctx, EventLogin, user.ID, "incorrect password")
 		return nil, ErrInvalidCredentials
 	}
 	
-	// Update last login time
-	_, err = s.config.DBConnection.Exec(
-		"UPDATE users SET last_login = NOW() WHERE id = $1",
-		user.ID,
-	)
-	if err != nil {
-		return nil, err
-	}
-	
 	return &user, nil
 }
 
+// completeLogin records a login by a user whose credentials checkCredentials
+// accepted. The last login time is only updated if the password did not
+// change and the account was not deactivated while it was being checked.
+func (s *Service) completeLogin(ctx context.Context, user *User) error {
+	err := s.recordLogin(ctx, user, user.Password)
+	if err == ErrInvalidCredentials {
+		s.auditFailure(ctx, EventLogin, user.ID, "account changed during login")
+		return err
+	}
+	s.audit(ctx, EventLogin, user.ID, err)
+	return err
+}
+
 // Login combines authentication and JWT generation
 func (s *Service) Login(username, password string) (*User, string, error) {
-	user, err := s.Authenticate(username, password)
+	return s.LoginContext(context.Background(), username, password)
+}
+
+// LoginContext is like Login, and records the login session with the
+// client information carried by ctx (see RequestContext)
+func (s *Service) LoginContext(ctx context.Context, username, password string) (_ *User, _ string, err error) {
+	ctx, span := s.startSpan(ctx, "Login")
+	defer func() { endSpan(span, err) }()
+	
+	user, err := s.AuthenticateContext(ctx, username, password)
 	if err != nil {
 		return nil, "", err
 	}
 	
-	token, err := s.GenerateJWT(user)
+	token, err := s.issueLoginToken(ctx, user, nil)
 	if err != nil {
 		return user, "", err
 	}
@@ -104,8 +197,80 @@ func (s *Service) Login(username, password string) (*User, string, error) {
 	return user, token, nil
 }
 
-// GenerateOTP creates a one-time password for a user
+// recordLogin updates the user's last login time and queues the login
+// webhook. The update only applies while the account is active and, when
+// passwordHash is not empty, still has that password; otherwise it returns
+// ErrInvalidCredentials, so a login racing a deactivation or password
+// change cannot succeed on what it read before.
+func (s *Service) recordLogin(ctx context.Context, user *User, passwordHash string) error {
+	return s.withTx(ctx, func(tx *dbTx) error {
+		err := tx.QueryRowContext(
+			ctx,
+			`UPDATE users SET last_login = $3
+			WHERE id = $1 AND is_active = true AND ($2 = '' OR password = $2)
+			RETURNING last_login`,
+			user.ID, passwordHash, s.Now(),
+		).Scan(&user.LastLogin)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrInvalidCredentials
+			}
+			return err
+		}
+		
+		return s.enqueueWebhookEvent(ctx, tx, WebhookUserLoggedIn, map[string]interface{}{
+			"user_id":  user.ID,
+			"username": user.Username,
+		})
+	})
+}
+
+// setPassword stores a new password hash and queues the password change webhook
+func (s *Service) setPassword(ctx context.Context, userID int64, hashedPassword string) error {
+	return s.withTx(ctx, func(tx *dbTx) error {
+		_, err := tx.ExecContext(
+			ctx,
+			"UPDATE users SET password = $1, password_changed = $3 WHERE id = $2",
+			hashedPassword, userID, s.Now(),
+		)
+		if err != nil {
+			return err
+		}
+		
+		return s.enqueueWebhookEvent(ctx, tx, WebhookUserPasswordChanged, map[string]interface{}{
+			"user_id": userID,
+		})
+	})
+}
+
+// GenerateOTP creates a one-time login password for a user. It is
+// GenerateOTPFor with OTPLogin.
 func (s *Service) GenerateOTP(userID int64, length int, validityMinutes int) (string, error) {
+	return s.GenerateOTPForContext(context.Background(), userID, OTPLogin, length, validityMinutes)
+}
+
+// GenerateOTPContext is like GenerateOTP, and records an audit event
+func (s *Service) GenerateOTPContext(ctx context.Context, userID int64, length int, validityMinutes int) (string, error) {
+	return s.GenerateOTPForContext(ctx, userID, OTPLogin, length, validityMinutes)
+}
+
+// GenerateOTPFor creates a one-time password for a user and purpose. It
+// replaces any code the user has for the same purpose, unless that code was
+// issued less than Config.OTPResendCooldown ago, in which case it returns
+// ErrOTPCooldown.
+func (s *Service) GenerateOTPFor(userID int64, purpose OTPPurpose, length int, validityMinutes int) (string, error) {
+	return s.GenerateOTPForContext(context.Background(), userID, purpose, length, validityMinutes)
+}
+
+// GenerateOTPForContext is like GenerateOTPFor, and records an audit event
+func (s *Service) GenerateOTPForContext(ctx context.Context, userID int64, purpose OTPPurpose, length int, validityMinutes int) (otp string, err error) {
+	ctx, span := s.startSpan(ctx, "GenerateOTP", userIDAttr(userID), attribute.String("auth.otp_purpose", string(purpose)))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, EventOTPGenerate, userID, err) }()
+	
+	if !purpose.Valid() {
+		return "", ErrInvalidOTPPurpose
+	}
 	if length <= 0 {
 		length = 6 // Default OTP length
 	}
@@ -113,35 +278,58 @@ func (s *Service) GenerateOTP(userID int64, length int, validityMinutes int) (st
 		validityMinutes = 15 // Default validity: 15 minutes
 	}
 	
-	// Check if user exists
-	exists, err := s.userExists(userID)
-	if err != nil {
-		return "", err
-	}
-	if !exists {
-		return "", ErrUserNotFound
-	}
-	
 	// Generate random OTP
-	otp, err := s.generateRandomOTP(length)
+	otp, err = s.generateRandomOTP(length)
 	if err != nil {
 		return "", err
 	}
 	
-	// Store OTP in database
-	expiresAt := time.Now().Add(time.Duration(validityMinutes) * time.Minute)
-	
-	// Delete any existing OTPs for this user
-	_, err = s.config.DBConnection.Exec("DELETE FROM otp WHERE user_id = $1", userID)
-	if err != nil {
-		return "", err
-	}
+	// Store only a hash of the OTP
+	now := s.Now()
+	expiresAt := now.Add(time.Duration(validityMinutes) * time.Minute)
+	codeHash := hashOTP(s.signingKey(), userID, purpose, otp)
 	
-	// Insert new OTP
-	_, err = s.config.DBConnection.Exec(
-		"INSERT INTO otp (user_id, otp, expires_at) VALUES ($1, $2, $3)",
-		userID, otp, expiresAt,
-	)
+	// Replace the user's OTP in one transaction. Locking the user row
+	// serializes concurrent calls, so only one code per purpose is ever
+	// outstanding and the cooldown cannot be raced.
+	err = s.withTx(ctx, func(tx *dbTx) error {
+		var id int64
+		err := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		
+		// Refuse to replace an unused code sent within the cooldown
+		var createdAt time.Time
+		err = tx.QueryRowContext(
+			ctx,
+			"SELECT created_at FROM users_otp WHERE user_id = $1 AND purpose = $2 AND verified = false",
+			userID, string(purpose),
+		).Scan(&createdAt)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err == nil {
+			if wait := createdAt.Add(s.config.OTPResendCooldown).Sub(now); wait > 0 {
+				return fmt.Errorf("%w: try again in %s", ErrOTPCooldown, wait.Round(time.Second))
+			}
+		}
+		
+		// Insert the new OTP, replacing any earlier one for this purpose
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO users_otp (user_id, purpose, code_hash, attempts, created_at, expires_at, verified)
+			VALUES ($1, $2, $3, 0, $4, $5, false)
+			ON CONFLICT (user_id, purpose) DO UPDATE SET
+				code_hash = EXCLUDED.code_hash, attempts = 0, created_at = EXCLUDED.created_at,
+				expires_at = EXCLUDED.expires_at, verified = false`,
+			userID, string(purpose), codeHash, now, expiresAt,
+		)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
@@ -149,36 +337,83 @@ func (s *Service) GenerateOTP(userID int64, length int, validityMinutes int) (st
 	return otp, nil
 }
 
-// VerifyOTP checks if an OTP is valid for a user
+// VerifyOTP checks if a one-time login password is valid for a user. It is
+// VerifyOTPFor with OTPLogin.
 func (s *Service) VerifyOTP(userID int64, otp string) (bool, error) {
+	return s.VerifyOTPForContext(context.Background(), userID, OTPLogin, otp)
+}
+
+// VerifyOTPContext is like VerifyOTP, and records an audit event
+func (s *Service) VerifyOTPContext(ctx context.Context, userID int64, otp string) (bool, error) {
+	return s.VerifyOTPForContext(ctx, userID, OTPLogin, otp)
+}
+
+// VerifyOTPFor checks if an OTP is valid for a user and purpose. Each code
+// allows Config.OTPMaxAttempts guesses, after which it stops verifying and
+// a new one must be generated.
+func (s *Service) VerifyOTPFor(userID int64, purpose OTPPurpose, otp string) (bool, error) {
+	return s.VerifyOTPForContext(context.Background(), userID, purpose, otp)
+}
+
+// VerifyOTPForContext is like VerifyOTPFor, and records an audit event
+func (s *Service) VerifyOTPForContext(ctx context.Context, userID int64, purpose OTPPurpose, otp string) (valid bool, err error) {
+	ctx, span := s.startSpan(ctx, "VerifyOTP", userIDAttr(userID), attribute.String("auth.otp_purpose", string(purpose)))
+	defer func() { endSpan(span, err) }()
+	reason := "invalid or expired code"
+	defer func() {
+		if err == nil && !valid {
+			s.auditFailure(ctx, EventOTPVerify, userID, reason)
+			return
+		}
+		s.audit(ctx, EventOTPVerify, userID, err)
+	}()
+	
+	if !purpose.Valid() {
+		return false, ErrInvalidOTPPurpose
+	}
+	
+	// Count the attempt and use the code in one statement on the primary. A
+	// concurrent call for the same code waits for this one's row lock, so
+	// every guess is counted and each code is accepted at most once.
 	query := `
-		SELECT id FROM otp
-		WHERE user_id = $1 AND otp = $2 AND expires_at > NOW() AND verified = false
+		UPDATE users_otp SET attempts = attempts + 1, verified = (code_hash = ANY($3))
+		WHERE user_id = $1 AND purpose = $2 AND expires_at > $4 AND verified = false AND attempts < $5
+		RETURNING verified, attempts
 	`
 	
-	var otpID int64
-	err := s.config.DBConnection.QueryRow(query, userID, otp).Scan(&otpID)
+	var attempts int
+	err = s.db.QueryRowContext(
+		ctx,
+		query,
+		userID, string(purpose), pq.Array(s.otpHashes(userID, purpose, otp)), s.Now(), s.config.OTPMaxAttempts,
+	).Scan(&valid, &attempts)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return false, nil
 		}
 		return false, err
 	}
-	
-	// Mark OTP as verified
-	_, err = s.config.DBConnection.Exec("UPDATE otp SET verified = true WHERE id = $1", otpID)
-	if err != nil {
-		return false, err
+	if !valid && attempts >= s.config.OTPMaxAttempts {
+		reason = "invalid code; attempt limit reached"
 	}
 	
-	return true, nil
+	return valid, nil
 }
 
 // ChangePassword updates a user's password
 func (s *Service) ChangePassword(userID int64, currentPassword, newPassword string) error {
+	return s.ChangePasswordContext(context.Background(), userID, currentPassword, newPassword)
+}
+
+// ChangePasswordContext is like ChangePassword, and records an audit event
+func (s *Service) ChangePasswordContext(ctx context.Context, userID int64, currentPassword, newPassword string) (err error) {
+	ctx, span := s.startSpan(ctx, "ChangePassword", userIDAttr(userID))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, EventPasswordChange, userID, err) }()
+	
 	// Get current user details
 	var storedPassword string
-	err := s.config.DBConnection.QueryRow("SELECT password FROM users WHERE id = $1", userID).Scan(&storedPassword)
+	err = s.db.QueryRowContext(ctx, "SELECT password FROM users WHERE id = $1", userID).Scan(&storedPassword)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return ErrUserNotFound
@@ -187,54 +422,59 @@ func (s *Service) ChangePassword(userID int64, currentPassword, newPassword stri
 	}
 	
 	// Verify current password
-	if !s.VerifyPassword(storedPassword, currentPassword) {
+	if !s.VerifyPasswordContext(ctx, storedPassword, currentPassword) {
 		return ErrInvalidPassword
 	}
+	if err := s.config.PasswordPolicy.Check(newPassword); err != nil {
+		return err
+	}
 	
 	// Hash new password
-	hashedPassword, err := s.HashPassword(newPassword)
+	hashedPassword, err := s.HashPasswordContext(ctx, newPassword)
 	if err != nil {
 		return err
 	}
 	
 	// Update password
-	_, err = s.config.DBConnection.Exec(
-		"UPDATE users SET password = $1, password_changed = NOW() WHERE id = $2",
-		hashedPassword, userID,
-	)
-	
-	return err
+	return s.setPassword(ctx, userID, hashedPassword)
 }
 
 // ResetPassword resets a user's password (admin function or after verification)
 func (s *Service) ResetPassword(userID int64, newPassword string) error {
+	return s.ResetPasswordContext(context.Background(), userID, newPassword)
+}
+
+// ResetPasswordContext is like ResetPassword, and records an audit event
+func (s *Service) ResetPasswordContext(ctx context.Context, userID int64, newPassword string) (err error) {
+	ctx, span := s.startSpan(ctx, "ResetPassword", userIDAttr(userID))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, EventPasswordReset, userID, err) }()
+	
 	// Check if user exists
-	exists, err := s.userExists(userID)
+	exists, err := s.userExists(ctx, userID)
 	if err != nil {
 		return err
 	}
 	if !exists {
 		return ErrUserNotFound
 	}
+	if err := s.config.PasswordPolicy.Check(newPassword); err != nil {
+		return err
+	}
 	
 	// Hash new password
-	hashedPassword, err := s.HashPassword(newPassword)
+	hashedPassword, err := s.HashPasswordContext(ctx, newPassword)
 	if err != nil {
 		return err
 	}
 	
 	// Update password
-	_, err = s.config.DBConnection.Exec(
-		"UPDATE users SET password = $1, password_changed = NOW() WHERE id = $2",
-		hashedPassword, userID,
-	)
-	
-	return err
+	return s.setPassword(ctx, userID, hashedPassword)
 }
 
 // userExists checks if a user exists by ID
-func (s *Service) userExists(userID int64) (bool, error) {
+func (s *Service) userExists(ctx context.Context, userID int64) (bool, error) {
 	var exists bool
-	err := s.config.DBConnection.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", userID).Scan(&exists)
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", userID).Scan(&exists)
 	return exists, err
 }
\ No newline at end of file
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// SchemaVersion is the version of the tables InitDB creates. It is
+// increased whenever InitDB changes, so CheckSchema can tell when a
+// deployment is running against a database that has not been migrated.
+const SchemaVersion = 4
+
+// CheckSchema reports an error if the database schema was not created by
+// InitDB for this version of the package
+func (s *Service) CheckSchema(ctx context.Context) (err error) {
+	ctx, span := s.startSpan(ctx, "CheckSchema")
+	defer func() { endSpan(span, err) }()
+
+	var version int
+	err = s.db.QueryRowContext(ctx, "SELECT version FROM auth_schema").Scan(&version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrSchemaOutdated
+		}
+		return err
+	}
+	if version != SchemaVersion {
+		return fmt.Errorf("%w: database is at version %d, want %d", ErrSchemaOutdated, version, SchemaVersion)
+	}
+	return nil
+}
+
+// CheckSecrets reports an error if the JWT secret is not loaded, or if the
+// last attempt to refresh it from Config.SecretProvider failed
+func (s *Service) CheckSecrets(ctx context.Context) (err error) {
+	_, span := s.startSpan(ctx, "CheckSecrets")
+	defer func() { endSpan(span, err) }()
+
+	if s.jwtSecret.Get() == "" {
+		return errors.New("JWT secret is not loaded")
+	}
+	if err := s.jwtSecret.Err(); err != nil {
+		return fmt.Errorf("refreshing %s: %w", s.jwtSecret.Name(), err)
+	}
+	return nil
+}
+
+// CheckNotifier reports whether the configured Notifier can deliver
+// invitations. Notifiers opt in by implementing Check(ctx) error; others,
+// and a missing notifier, are assumed healthy.
+func (s *Service) CheckNotifier(ctx context.Context) (err error) {
+	ctx, span := s.startSpan(ctx, "CheckNotifier")
+	defer func() { endSpan(span, err) }()
+
+	checker, ok := s.config.Notifier.(interface {
+		Check(ctx context.Context) error
+	})
+	if !ok {
+		return nil
+	}
+	return checker.Check(ctx)
+}
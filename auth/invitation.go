@@ -0,0 +1,374 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// invitationAudience marks invitation tokens, which are also signed with a
+// key of their own so they are never accepted as login tokens
+const invitationAudience = "invitation"
+
+// Invitation invites someone to create an account, optionally as a member
+// of an organization with a pre-assigned role
+type Invitation struct {
+	ID             int64      `json:"id"`
+	Email          string     `json:"email"`
+	OrganizationID *int64     `json:"organization_id"`
+	Role           string     `json:"role,omitempty"`
+	InvitedBy      *int64     `json:"invited_by"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// Notifier delivers invitations, typically by email. The token belongs in
+// the link the invitee follows to accept the invitation. A Notifier that
+// also has a Check(ctx) error method is probed by CheckNotifier.
+type Notifier interface {
+	SendInvitation(ctx context.Context, invitation *Invitation, token string) error
+}
+
+// PublicRegistrationEnabled reports whether anyone may create an account
+// without an invitation (see Config.DisablePublicRegistration)
+func (s *Service) PublicRegistrationEnabled() bool {
+	return !s.config.DisablePublicRegistration
+}
+
+// NotificationsEnabled reports whether a Notifier delivers invitations. When
+// it does not, the caller must pass on the token returned by CreateInvitation.
+func (s *Service) NotificationsEnabled() bool {
+	return s.config.Notifier != nil
+}
+
+// CreateInvitation invites email to create an account. With a non-zero
+// orgID the account joins that organization with role; otherwise role must
+// be empty. The invitation is sent through the configured Notifier; if that
+// fails the invitation stays pending and can be resent.
+func (s *Service) CreateInvitation(ctx context.Context, email string, orgID int64, role string) (_ *Invitation, _ string, err error) {
+	ctx, span := s.startSpan(ctx, "CreateInvitation", orgIDAttr(orgID))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, EventInvitationCreate, 0, err) }()
+
+	email = strings.TrimSpace(email)
+	if !ValidateEmail(email) {
+		return nil, "", ErrInvalidEmail
+	}
+	if (orgID == 0) != (role == "") || (role != "" && !ValidRole(role)) {
+		return nil, "", ErrInvalidRole
+	}
+
+	var emailTaken bool
+	err = s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email) = LOWER($1))", email).Scan(&emailTaken)
+	if err != nil {
+		return nil, "", err
+	}
+	if emailTaken {
+		return nil, "", ErrEmailInUse
+	}
+
+	if orgID != 0 {
+		if _, err := s.GetOrganization(ctx, orgID); err != nil {
+			return nil, "", err
+		}
+	}
+
+	nonce, err := s.randomToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+
+	invitation := Invitation{
+		Email:     email,
+		Role:      role,
+		ExpiresAt: s.Now().Add(s.config.InvitationTTL),
+	}
+	if orgID != 0 {
+		invitation.OrganizationID = &orgID
+	}
+	if claims, err := GetUserFromContext(ctx); err == nil {
+		invitation.InvitedBy = &claims.UserID
+	}
+
+	err = s.db.QueryRowContext(
+		ctx,
+		`INSERT INTO invitations (email, organization_id, role, nonce, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`,
+		email, invitation.OrganizationID, role, nonce, invitation.InvitedBy, invitation.ExpiresAt,
+	).Scan(&invitation.ID, &invitation.CreatedAt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := s.sendInvitation(ctx, &invitation, nonce)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &invitation, token, nil
+}
+
+// ListInvitations returns pending invitations, newest first. A non-zero
+// orgID lists only the invitations to that organization.
+func (s *Service) ListInvitations(ctx context.Context, orgID int64) (_ []Invitation, err error) {
+	ctx, span := s.startSpan(ctx, "ListInvitations", orgIDAttr(orgID))
+	defer func() { endSpan(span, err) }()
+
+	query := `
+		SELECT id, email, organization_id, role, invited_by, expires_at, accepted_at, revoked_at, created_at
+		FROM invitations
+		WHERE accepted_at IS NULL AND revoked_at IS NULL AND ($1 = 0 OR organization_id = $1)
+		ORDER BY created_at DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []Invitation{}
+	for rows.Next() {
+		var invitation Invitation
+		err := rows.Scan(
+			&invitation.ID,
+			&invitation.Email,
+			&invitation.OrganizationID,
+			&invitation.Role,
+			&invitation.InvitedBy,
+			&invitation.ExpiresAt,
+			&invitation.AcceptedAt,
+			&invitation.RevokedAt,
+			&invitation.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+
+	return invitations, rows.Err()
+}
+
+// RevokeInvitation revokes a pending invitation. A non-zero orgID only
+// matches invitations to that organization.
+func (s *Service) RevokeInvitation(ctx context.Context, orgID, invitationID int64) (err error) {
+	ctx, span := s.startSpan(ctx, "RevokeInvitation", orgIDAttr(orgID))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, EventInvitationRevoke, 0, err) }()
+
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE invitations SET revoked_at = $3
+		WHERE id = $1 AND ($2 = 0 OR organization_id = $2) AND accepted_at IS NULL AND revoked_at IS NULL`,
+		invitationID, orgID, s.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// ResendInvitation sends a pending invitation again with a new token and
+// expiry; earlier tokens for it stop working. A non-zero orgID only matches
+// invitations to that organization.
+func (s *Service) ResendInvitation(ctx context.Context, orgID, invitationID int64) (_ string, err error) {
+	ctx, span := s.startSpan(ctx, "ResendInvitation", orgIDAttr(orgID))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, EventInvitationResend, 0, err) }()
+
+	nonce, err := s.randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	var invitation Invitation
+	err = s.db.QueryRowContext(
+		ctx,
+		`UPDATE invitations SET nonce = $1, expires_at = $2
+		WHERE id = $3 AND ($4 = 0 OR organization_id = $4) AND accepted_at IS NULL AND revoked_at IS NULL
+		RETURNING id, email, organization_id, role, invited_by, expires_at, created_at`,
+		nonce, s.Now().Add(s.config.InvitationTTL), invitationID, orgID,
+	).Scan(
+		&invitation.ID,
+		&invitation.Email,
+		&invitation.OrganizationID,
+		&invitation.Role,
+		&invitation.InvitedBy,
+		&invitation.ExpiresAt,
+		&invitation.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrInvitationNotFound
+		}
+		return "", err
+	}
+
+	return s.sendInvitation(ctx, &invitation, nonce)
+}
+
+// AcceptInvitation creates the invitee's account as Register does, using the
+// invited email address, and adds it to the invitation's organization. The
+// invitation is claimed and the account created in one transaction, so a
+// failure leaves neither an account without its membership nor a claimed
+// invitation without an account.
+func (s *Service) AcceptInvitation(ctx context.Context, token string, user User, password string) (userID int64, err error) {
+	ctx, span := s.startSpan(ctx, "AcceptInvitation")
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, EventInvitationAccept, userID, err) }()
+
+	invitation, nonce, err := s.verifyInvitation(ctx, token)
+	if err != nil {
+		return 0, err
+	}
+
+	user.Email = invitation.Email
+	user.IsActive = true
+	user.IsSuperuser = false
+	hashedPassword, err := s.prepareUser(ctx, user, password, true)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.withTx(ctx, func(tx *dbTx) error {
+		// Claim the invitation first, unless it was revoked or resent
+		// meanwhile. The row stays locked until commit, so a concurrent
+		// acceptance waits and then finds it claimed.
+		result, err := tx.ExecContext(
+			ctx,
+			`UPDATE invitations SET accepted_at = $3
+			WHERE id = $1 AND nonce = $2 AND accepted_at IS NULL AND revoked_at IS NULL`,
+			invitation.ID, nonce, s.Now(),
+		)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrInvalidInvitation
+		}
+
+		userID, err = s.insertUser(ctx, tx, user, hashedPassword)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE invitations SET accepted_user_id = $1 WHERE id = $2", userID, invitation.ID)
+		if err != nil {
+			return err
+		}
+
+		if invitation.OrganizationID == nil {
+			return nil
+		}
+		_, err = tx.ExecContext(
+			ctx,
+			"INSERT INTO organization_members (organization_id, user_id, role) VALUES ($1, $2, $3)",
+			*invitation.OrganizationID, userID, invitation.Role,
+		)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.audit(ctx, EventRegister, userID, nil)
+
+	return userID, nil
+}
+
+// sendInvitation signs a token for the invitation and hands it to the
+// notifier, if any
+func (s *Service) sendInvitation(ctx context.Context, invitation *Invitation, nonce string) (string, error) {
+	claims := jwt.StandardClaims{
+		Id:        nonce,
+		Subject:   strconv.FormatInt(invitation.ID, 10),
+		Audience:  invitationAudience,
+		ExpiresAt: invitation.ExpiresAt.Unix(),
+		IssuedAt:  s.Now().Unix(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(invitationKey(s.signingKey()))
+	if err != nil {
+		return "", err
+	}
+
+	if s.config.Notifier != nil {
+		if err := s.config.Notifier.SendInvitation(ctx, invitation, token); err != nil {
+			return "", fmt.Errorf("sending invitation: %w", err)
+		}
+	}
+
+	return token, nil
+}
+
+// verifyInvitation checks an invitation token and returns the pending
+// invitation it was issued for, with the token's nonce
+func (s *Service) verifyInvitation(ctx context.Context, token string) (*Invitation, string, error) {
+	var claims jwt.StandardClaims
+	_, err := s.parseToken(token, &claims, invitationKey)
+	if err != nil || !claims.VerifyAudience(invitationAudience, true) {
+		return nil, "", ErrInvalidInvitation
+	}
+	invitationID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return nil, "", ErrInvalidInvitation
+	}
+
+	var invitation Invitation
+	var nonce string
+	err = s.db.QueryRowContext(
+		ctx,
+		`SELECT id, email, organization_id, role, invited_by, expires_at, created_at, nonce
+		FROM invitations
+		WHERE id = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > $2`,
+		invitationID, s.Now(),
+	).Scan(
+		&invitation.ID,
+		&invitation.Email,
+		&invitation.OrganizationID,
+		&invitation.Role,
+		&invitation.InvitedBy,
+		&invitation.ExpiresAt,
+		&invitation.CreatedAt,
+		&nonce,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "", ErrInvalidInvitation
+		}
+		return nil, "", err
+	}
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(claims.Id)) != 1 {
+		return nil, "", ErrInvalidInvitation
+	}
+
+	return &invitation, nonce, nil
+}
+
+// invitationKey derives the key invitation tokens are signed with from the
+// JWT secret
+func invitationKey(secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(invitationAudience))
+	return mac.Sum(nil)
+}
@@ -1,8 +1,9 @@
 package auth
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"strings"
 	"time"
 	"github.com/dgrijalva/jwt-go"
 )
@@ -12,69 +13,164 @@ type TokenClaims struct {
 	UserID      int64  `json:"user_id"`
 	Username    string `json:"username"`
 	IsSuperuser bool   `json:"is_superuser"`
+	Scope       string `json:"scope,omitempty"`
+	OrgID       int64  `json:"org_id,omitempty"`   // Organization selected at login or by SwitchOrganization
+	OrgRole     string `json:"org_role,omitempty"` // The user's role in OrgID when the token was issued
+	APIKeyID    int64  `json:"-"`                  // Set when the claims come from an API key rather than a token
 	jwt.StandardClaims
 }
 
+// HasScope reports whether the claims grant a scope. Claims without any
+// scopes (regular logins and unscoped API keys) are unrestricted.
+func (c *TokenClaims) HasScope(scope string) bool {
+	if c.Scope == "" {
+		return true
+	}
+	for _, granted := range strings.Fields(c.Scope) {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
 // GenerateJWT creates a new JWT token for the user
 func (s *Service) GenerateJWT(user *User) (string, error) {
+	signedToken, _, err := s.generateJWT(user, nil)
+	return signedToken, err
+}
+
+// generateJWT creates a new JWT token for the user and returns its claims.
+// A non-nil membership selects its organization in the token.
+func (s *Service) generateJWT(user *User, membership *Membership) (string, *TokenClaims, error) {
+	// The token ID identifies this login; it survives RefreshJWT so that
+	// revoking it also revokes every token refreshed from it
+	tokenID, err := s.randomToken(16)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := s.Now()
 	claims := TokenClaims{
 		UserID:      user.ID,
 		Username:    user.Username,
 		IsSuperuser: user.IsSuperuser,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(s.config.TokenDuration).Unix(),
-			IssuedAt:  time.Now().Unix(),
+			Id:        tokenID,
+			ExpiresAt: now.Add(s.config.TokenDuration).Unix(),
+			IssuedAt:  now.Unix(),
 		},
 	}
+	if membership != nil {
+		claims.OrgID = membership.OrganizationID
+		claims.OrgRole = membership.Role
+	}
 	
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString([]byte(s.config.JWTSecret))
+	signedToken, err := token.SignedString(s.signingKey())
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 	
-	return signedToken, nil
+	return signedToken, &claims, nil
 }
 
 // VerifyJWT validates a JWT token and returns the claims
 func (s *Service) VerifyJWT(tokenString string) (*TokenClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		// Validate signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(s.config.JWTSecret), nil
-	})
+	return s.VerifyJWTContext(context.Background(), tokenString)
+}
+
+// VerifyJWTContext is like VerifyJWT, and traces the verification as part of ctx
+func (s *Service) VerifyJWTContext(ctx context.Context, tokenString string) (_ *TokenClaims, err error) {
+	ctx, span := s.startSpan(ctx, "VerifyJWT")
+	defer func() { endSpan(span, err) }()
+	defer func() { s.metrics.TokenVerification("jwt", verificationResult(err)) }()
+	
+	token, err := s.parseToken(tokenString, &TokenClaims{}, nil)
 	
 	if err != nil {
 		return nil, err
 	}
 	
-	if claims, ok := token.Claims.(*TokenClaims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*TokenClaims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	
+	// Check the revocation list
+	if claims.Id != "" {
+		revoked, err := s.isTokenRevoked(ctx, claims.Id)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
 	}
 	
-	return nil, errors.New("invalid token")
+	return claims, nil
+}
+
+// verificationResult maps a token verification error to a metrics label
+func verificationResult(err error) string {
+	var validationErr *jwt.ValidationError
+	switch {
+	case err == nil:
+		return "valid"
+	case err == ErrTokenRevoked:
+		return "revoked"
+	case err == ErrSessionExpired:
+		return "expired"
+	case err == ErrSessionNotFound:
+		return "invalid"
+	case errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorExpired != 0:
+		return "expired"
+	case err == ErrInvalidToken || errors.As(err, &validationErr):
+		return "invalid"
+	default:
+		return "error"
+	}
 }
 
 // RefreshJWT creates a new token with extended expiration time
 func (s *Service) RefreshJWT(tokenString string) (string, error) {
+	return s.RefreshJWTContext(context.Background(), tokenString)
+}
+
+// RefreshJWTContext is like RefreshJWT, and traces the refresh as part of ctx
+func (s *Service) RefreshJWTContext(ctx context.Context, tokenString string) (_ string, err error) {
+	ctx, span := s.startSpan(ctx, "RefreshJWT")
+	defer func() { endSpan(span, err) }()
+	
 	// First verify the existing token
-	claims, err := s.VerifyJWT(tokenString)
+	claims, err := s.VerifyJWTContext(ctx, tokenString)
 	if err != nil {
 		return "", err
 	}
 	
 	// Create new token with same claims but new expiration
-	claims.StandardClaims.ExpiresAt = time.Now().Add(s.config.TokenDuration).Unix()
-	claims.StandardClaims.IssuedAt = time.Now().Unix()
+	now := s.Now()
+	claims.StandardClaims.ExpiresAt = now.Add(s.config.TokenDuration).Unix()
+	claims.StandardClaims.IssuedAt = now.Unix()
 	
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString([]byte(s.config.JWTSecret))
+	signedToken, err := token.SignedString(s.signingKey())
 	if err != nil {
 		return "", err
 	}
 	
+	// Keep the login session's expiry in step with the refreshed token
+	if claims.Id != "" {
+		_, err = s.db.ExecContext(
+			ctx,
+			"UPDATE login_sessions SET expires_at = $1, last_seen_at = $3 WHERE jti = $2",
+			time.Unix(claims.ExpiresAt, 0), claims.Id, now,
+		)
+		if err != nil {
+			return "", err
+		}
+	}
+	
 	return signedToken, nil
 }
 
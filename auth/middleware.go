@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 )
@@ -9,24 +10,29 @@ import (
 // AuthMiddleware is a middleware function to protect routes
 func (s *Service) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get token from Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header is required", http.StatusUnauthorized)
-			return
+		// Fall back to the session cookie when sessions are enabled
+		if r.Header.Get("Authorization") == "" && s.sessions != nil {
+			claims, err := s.authenticateSession(w, r)
+			if err == nil {
+				ctx := context.WithValue(r.Context(), UserContextKey, claims)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
 		}
 		
-		// Expected format: "Bearer <token>"
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, "Authorization header format must be Bearer <token>", http.StatusUnauthorized)
+		claims, err := s.authenticateRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
 		
-		// Verify token
-		claims, err := s.VerifyJWT(parts[1])
-		if err != nil {
-			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		// Scoped API keys only reach routes their scopes allow
+		scope := ScopeWrite
+		if isSafeMethod(r.Method) {
+			scope = ScopeRead
+		}
+		if !claims.HasScope(scope) {
+			http.Error(w, "Credentials lack the "+scope+" scope", http.StatusForbidden)
 			return
 		}
 		
@@ -36,6 +42,43 @@ func (s *Service) AuthMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// authenticateRequest verifies the credentials in the Authorization header.
+// Expected format: "Bearer <token>" for JWTs or "ApiKey <key>" for API keys.
+func (s *Service) authenticateRequest(r *http.Request) (*TokenClaims, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, errors.New("Authorization header is required")
+	}
+	
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 {
+		return nil, errors.New("Authorization header format must be Bearer <token> or ApiKey <key>")
+	}
+	
+	switch parts[0] {
+	case "Bearer":
+		claims, err := s.VerifyJWTContext(r.Context(), parts[1])
+		if err != nil {
+			return nil, errors.New("Invalid or expired token")
+		}
+		if claims.Id != "" {
+			if err := s.touchLoginSession(r.Context(), claims.Id); err != nil {
+				s.logger.ErrorContext(r.Context(), "updating login session", "error", err)
+				return nil, errors.New("Error verifying token")
+			}
+		}
+		return claims, nil
+	case "ApiKey":
+		claims, err := s.VerifyAPIKeyContext(r.Context(), parts[1])
+		if err != nil {
+			return nil, errors.New("Invalid or expired API key")
+		}
+		return claims, nil
+	default:
+		return nil, errors.New("Authorization header format must be Bearer <token> or ApiKey <key>")
+	}
+}
+
 // AdminMiddleware is a middleware function to protect admin routes
 func (s *Service) AdminMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -47,6 +90,10 @@ func (s *Service) AdminMiddleware(next http.Handler) http.Handler {
 				http.Error(w, "Admin access required", http.StatusForbidden)
 				return
 			}
+			if !claims.HasScope(ScopeAdmin) {
+				http.Error(w, "Credentials lack the admin scope", http.StatusForbidden)
+				return
+			}
 			
 			next.ServeHTTP(w, r)
 		})).ServeHTTP(w, r)
@@ -64,6 +111,10 @@ func (s *Service) SuperuserMiddleware(next http.Handler) http.Handler {
 				http.Error(w, "Superuser access required", http.StatusForbidden)
 				return
 			}
+			if !claims.HasScope(ScopeAdmin) {
+				http.Error(w, "Credentials lack the admin scope", http.StatusForbidden)
+				return
+			}
 			
 			next.ServeHTTP(w, r)
 		})).ServeHTTP(w, r)
@@ -93,4 +144,13 @@ func (s *Service) RequireAuth(checkFunc func(*TokenClaims) bool, message string)
 			})).ServeHTTP(w, r)
 		})
 	}
+}
+
+// RequireScope is a middleware generator for routes that need a scope of
+// their own, beyond the read and write scopes AuthMiddleware checks.
+// Credentials without scopes are unrestricted.
+func (s *Service) RequireScope(scope string) func(http.Handler) http.Handler {
+	return s.RequireAuth(func(claims *TokenClaims) bool {
+		return claims.HasScope(scope)
+	}, "Credentials lack the "+scope+" scope")
 }
\ No newline at end of file
@@ -1,8 +1,17 @@
 package auth
 
 import (
+	"context"
 	"database/sql"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
 	"time"
+
+	"github.com/rb4807/Golang-Utlis/metrics"
+	"github.com/rb4807/Golang-Utlis/secrets"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type User struct {
@@ -28,15 +37,50 @@ type OTPData struct {
 
 // Config holds the configuration for the authentication package
 type Config struct {
-	JWTSecret     string
-	TokenDuration time.Duration
-	DBConnection  *sql.DB
+	JWTSecret                 string
+	TokenDuration             time.Duration
+	DBConnection              *sql.DB
+	ExternalProviders         []ExternalProvider   // Optional: external OAuth2/OIDC identity providers
+	HTTPClient                *http.Client         // Optional: client used to call external providers
+	Sessions                  *SessionConfig       // Optional: enables cookie-based sessions
+	CSRF                      *CSRFConfig          // Optional: defaults to double-submit tokens
+	AuditSink                 AuditSink            // Optional: defaults to the auth_events table
+	Webhooks                  *WebhookConfig       // Optional: enables outbound webhooks
+	Metrics                   *metrics.Metrics     // Optional: records Prometheus metrics
+	TracerProvider            trace.TracerProvider // Optional: defaults to the global OpenTelemetry provider
+	Logger                    *slog.Logger         // Optional: defaults to slog.Default()
+	Notifier                  Notifier             // Optional: delivers invitations
+	InvitationTTL             time.Duration        // Optional: defaults to 7 days
+	OTPMaxAttempts            int                  // Optional: guesses allowed per one-time password. Default: 5
+	OTPResendCooldown         time.Duration        // Optional: minimum time between codes for the same user and purpose. Default: 1 minute; negative disables
+	DisablePublicRegistration bool                 // Optional: only invited users can create accounts
+	PasswordPolicy            *PasswordPolicy      // Optional: rules for new passwords
+	SecretProvider            secrets.Provider     // Optional: source of the JWT secret, instead of JWTSecret
+	JWTSecretName             string               // Optional: name of the JWT secret in SecretProvider. Default: "jwt_secret"
+	SecretRefreshInterval     time.Duration        // Optional: how often RunSecretRefresh re-reads secrets. Default: 1 minute
+	ReadReplicas              []*sql.DB            // Optional: read replicas that take read-only lookups in turn
+	Clock                     Clock                // Optional: source of the current time for every expiry. Default: SystemClock
+	Random                    io.Reader            // Optional: source of random tokens, IDs and codes. Default: crypto/rand.Reader
 }
 
 // Service provides authentication functionality
 type Service struct {
 	config    Config
 	validator interface{} 
+	providers map[string]ExternalProvider
+	sessions  *SessionConfig
+	csrf      *CSRFConfig
+	auditSink AuditSink
+	webhooks  *WebhookConfig
+	jwtSecret *secrets.Value
+	clock     Clock
+	random    io.Reader
+	db        *instrumentedDB
+	replicas  []*instrumentedDB
+	nextReplica atomic.Uint64
+	metrics   *metrics.Metrics
+	tracer    trace.Tracer
+	logger    *slog.Logger
 }
 
 // Initialize database tables (similar to Django migrations)
@@ -61,29 +105,329 @@ func InitDB(db *sql.DB) error {
 		return err
 	}
 
-	// Create OTP table
+	// Create OTP table, holding one hashed code per user and purpose.
+	// Version 1 stored plaintext codes; they expire within minutes, so that
+	// table is dropped rather than migrated. Times are TIMESTAMPTZ so the
+	// cooldown read back from created_at is the instant that was written,
+	// whatever the session time zone.
 	_, err = db.Exec(`
+		DO $$
+		BEGIN
+			IF EXISTS (
+				SELECT 1 FROM information_schema.columns
+				WHERE table_schema = current_schema() AND table_name = 'users_otp' AND column_name = 'otp'
+			) THEN
+				DROP TABLE users_otp;
+			ELSIF EXISTS (
+				SELECT 1 FROM information_schema.columns
+				WHERE table_schema = current_schema() AND table_name = 'users_otp'
+					AND column_name = 'created_at' AND data_type = 'timestamp without time zone'
+			) THEN
+				ALTER TABLE users_otp
+					ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE 'UTC',
+					ALTER COLUMN expires_at TYPE TIMESTAMPTZ USING expires_at AT TIME ZONE 'UTC';
+			END IF;
+		END $$;
 		CREATE TABLE IF NOT EXISTS users_otp (
 			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			purpose VARCHAR(20) NOT NULL,
+			code_hash VARCHAR(64) NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL,
+			verified BOOLEAN DEFAULT FALSE,
+			UNIQUE (user_id, purpose)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create OAuth clients table (callers of introspection and revocation)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS oauth_clients (
+			id SERIAL PRIMARY KEY,
+			client_id VARCHAR(64) UNIQUE NOT NULL,
+			client_secret VARCHAR(255) NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			is_active BOOLEAN DEFAULT TRUE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create revoked tokens table, keyed by JWT ID
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS revoked_tokens (
+			jti VARCHAR(64) PRIMARY KEY,
 			user_id INTEGER REFERENCES users(id),
-			otp VARCHAR(10) NOT NULL,
+			revoked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create external identities table linking provider accounts to users
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS external_identities (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			provider VARCHAR(50) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			email VARCHAR(100),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (provider, subject)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create table for pending external logins (state, PKCE verifier and
+	// the hash of the cookie binding them to a browser). Pending logins
+	// from before the binding are dropped; they expire within minutes.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS external_auth_states (
+			state VARCHAR(64) PRIMARY KEY,
+			provider VARCHAR(50) NOT NULL,
+			code_verifier VARCHAR(128) NOT NULL,
+			browser_hash VARCHAR(64) NOT NULL,
+			link_user_id INTEGER REFERENCES users(id),
+			expires_at TIMESTAMP NOT NULL
+		);
+		ALTER TABLE external_auth_states ADD COLUMN IF NOT EXISTS browser_hash VARCHAR(64);
+		DELETE FROM external_auth_states WHERE browser_hash IS NULL;
+		ALTER TABLE external_auth_states ALTER COLUMN browser_hash SET NOT NULL;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create API keys table; only a hash of each key is stored
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			name VARCHAR(100) NOT NULL,
+			prefix VARCHAR(16) UNIQUE NOT NULL,
+			key_hash VARCHAR(64) NOT NULL,
+			scopes TEXT NOT NULL DEFAULT '',
+			expires_at TIMESTAMP NULL,
+			last_used_at TIMESTAMP NULL,
+			revoked_at TIMESTAMP NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create sessions table for cookie-based sessions, keyed by a hash of the cookie value
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			id VARCHAR(64) PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			is_superuser BOOLEAN DEFAULT FALSE,
+			ip VARCHAR(45) NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			last_seen_at TIMESTAMP NOT NULL,
 			expires_at TIMESTAMP NOT NULL,
-			verified BOOLEAN DEFAULT FALSE
+			csrf_token VARCHAR(64) NOT NULL DEFAULT ''
 		)
 	`)
+	if err != nil {
+		return err
+	}
+
+	// Create login sessions table, one row per JWT login (token ID)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS login_sessions (
+			jti VARCHAR(64) PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			ip VARCHAR(45) NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			last_seen_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the append-only audit log of authentication events
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS auth_events (
+			id BIGSERIAL PRIMARY KEY,
+			event_type VARCHAR(50) NOT NULL,
+			actor_id INTEGER NULL,
+			target_user_id INTEGER NULL,
+			ip VARCHAR(45) NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			outcome VARCHAR(10) NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			occurred_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS auth_events_target_user_idx ON auth_events (target_user_id, occurred_at);
+		CREATE INDEX IF NOT EXISTS auth_events_actor_idx ON auth_events (actor_id, occurred_at);
+		CREATE INDEX IF NOT EXISTS auth_events_occurred_at_idx ON auth_events (occurred_at);
+
+		CREATE OR REPLACE FUNCTION auth_events_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'auth_events is append-only';
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS auth_events_append_only ON auth_events;
+		CREATE TRIGGER auth_events_append_only
+			BEFORE UPDATE OR DELETE ON auth_events
+			FOR EACH ROW EXECUTE PROCEDURE auth_events_append_only();
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create webhook tables: subscriptions, the transactional outbox and the delivery queue
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+			id SERIAL PRIMARY KEY,
+			url TEXT NOT NULL,
+			secret VARCHAR(100) NOT NULL,
+			events TEXT NOT NULL DEFAULT '',
+			is_active BOOLEAN DEFAULT TRUE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS webhook_outbox (
+			id BIGSERIAL PRIMARY KEY,
+			event_id VARCHAR(64) UNIQUE NOT NULL,
+			event_type VARCHAR(50) NOT NULL,
+			payload JSONB NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			processed_at TIMESTAMP NULL
+		);
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id BIGSERIAL PRIMARY KEY,
+			subscription_id INTEGER NOT NULL REFERENCES webhook_subscriptions(id),
+			event_id VARCHAR(64) NOT NULL,
+			event_type VARCHAR(50) NOT NULL,
+			payload JSONB NOT NULL,
+			status VARCHAR(10) NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMP NOT NULL,
+			last_status INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			delivered_at TIMESTAMP NULL
+		);
+		CREATE INDEX IF NOT EXISTS webhook_outbox_unprocessed_idx ON webhook_outbox (id) WHERE processed_at IS NULL;
+		CREATE INDEX IF NOT EXISTS webhook_outbox_processed_idx ON webhook_outbox (processed_at) WHERE processed_at IS NOT NULL;
+		CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create organization tables: tenants and their members' roles
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS organizations (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(100) NOT NULL,
+			slug VARCHAR(50) UNIQUE NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS organization_members (
+			organization_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			role VARCHAR(20) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (organization_id, user_id)
+		);
+		CREATE INDEX IF NOT EXISTS organization_members_user_idx ON organization_members (user_id);
+
+		-- API keys, cookie sessions and audit events can belong to an
+		-- organization. Audit events are append-only, so they keep the ID of
+		-- a deleted organization rather than cascading.
+		ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS organization_id INTEGER NULL REFERENCES organizations(id) ON DELETE CASCADE;
+		ALTER TABLE sessions ADD COLUMN IF NOT EXISTS organization_id INTEGER NULL REFERENCES organizations(id) ON DELETE CASCADE;
+		ALTER TABLE auth_events ADD COLUMN IF NOT EXISTS organization_id INTEGER NULL;
+		CREATE INDEX IF NOT EXISTS auth_events_organization_idx ON auth_events (organization_id, occurred_at);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create invitations table; tokens are signed, and carry the row's nonce
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS invitations (
+			id SERIAL PRIMARY KEY,
+			email VARCHAR(100) NOT NULL,
+			organization_id INTEGER NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			role VARCHAR(20) NOT NULL DEFAULT '',
+			nonce VARCHAR(64) NOT NULL,
+			invited_by INTEGER NULL REFERENCES users(id),
+			expires_at TIMESTAMP NOT NULL,
+			accepted_at TIMESTAMP NULL,
+			accepted_user_id INTEGER NULL REFERENCES users(id),
+			revoked_at TIMESTAMP NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS invitations_organization_idx ON invitations (organization_id);
+	`)
+	if err != nil {
+		return err
+	}
+	
+	// Record the schema version, compared with SchemaVersion by CheckSchema
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS auth_schema (
+			id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+			version INTEGER NOT NULL,
+			migrated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		`INSERT INTO auth_schema (version) VALUES ($1)
+		ON CONFLICT (id) DO UPDATE SET version = EXCLUDED.version, migrated_at = CURRENT_TIMESTAMP`,
+		SchemaVersion,
+	)
 	return err
 }
 
 // GetUserByID retrieves a user by ID
 func (s *Service) GetUserByID(userID int64) (*User, error) {
+	return s.GetUserByIDContext(context.Background(), userID)
+}
+
+// GetUserByIDContext is like GetUserByID, and traces the lookup as part of
+// ctx. When the credentials in ctx select an organization, only its members
+// are found, as with GetOrganizationUser.
+func (s *Service) GetUserByIDContext(ctx context.Context, userID int64) (_ *User, err error) {
+	ctx, span := s.startSpan(ctx, "GetUserByID", userIDAttr(userID))
+	defer func() { endSpan(span, err) }()
+	
+	if orgID := callerOrgID(ctx); orgID != 0 {
+		return s.GetOrganizationUser(ctx, orgID, userID)
+	}
+	
 	query := `
 		SELECT id, username, email, password, first_name, last_name, is_active, is_superuser, date_joined, last_login, password_changed
 		FROM users
 		WHERE id = $1
 	`
 	
+	// Profile lookups tolerate replication lag, so they may use a replica
 	var user User
-	err := s.config.DBConnection.QueryRow(query, userID).Scan(
+	err = s.queryReplica(ctx, query, []interface{}{userID},
 		&user.ID,
 		&user.Username,
 		&user.Email,
@@ -107,24 +451,155 @@ func (s *Service) GetUserByID(userID int64) (*User, error) {
 	return &user, nil
 }
 
+// GetUserByUsername retrieves a user by username
+func (s *Service) GetUserByUsername(ctx context.Context, username string) (_ *User, err error) {
+	ctx, span := s.startSpan(ctx, "GetUserByUsername")
+	defer func() { endSpan(span, err) }()
+	
+	query := `
+		SELECT id, username, email, password, first_name, last_name, is_active, is_superuser, date_joined, last_login, password_changed
+		FROM users
+		WHERE username = $1
+	`
+	
+	var user User
+	err = s.db.QueryRowContext(ctx, query, username).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.Password,
+		&user.FirstName,
+		&user.LastName,
+		&user.IsActive,
+		&user.IsSuperuser,
+		&user.DateJoined,
+		&user.LastLogin,
+		&user.PasswordChanged,
+	)
+	
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	
+	return &user, nil
+}
+
+// ListUsers returns users ordered by ID, skipping offset users and returning
+// at most limit (default 100, maximum 1000). When the credentials in ctx
+// select an organization, only its members are listed.
+func (s *Service) ListUsers(ctx context.Context, offset, limit int) (_ []User, err error) {
+	ctx, span := s.startSpan(ctx, "ListUsers")
+	defer func() { endSpan(span, err) }()
+	
+	if limit <= 0 {
+		limit = 100
+	}
+	if limit > 1000 {
+		limit = 1000
+	}
+	
+	query := `
+		SELECT id, username, email, password, first_name, last_name, is_active, is_superuser, date_joined, last_login, password_changed
+		FROM users
+		ORDER BY id
+		OFFSET $1 LIMIT $2
+	`
+	args := []interface{}{offset, limit}
+	if orgID := callerOrgID(ctx); orgID != 0 {
+		query = `
+			SELECT u.id, u.username, u.email, u.password, u.first_name, u.last_name, u.is_active, u.is_superuser, u.date_joined, u.last_login, u.password_changed
+			FROM users u
+			JOIN organization_members m ON m.user_id = u.id
+			WHERE m.organization_id = $3
+			ORDER BY u.id
+			OFFSET $1 LIMIT $2
+		`
+		args = append(args, orgID)
+	}
+	
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	users := []User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.Email,
+			&user.Password,
+			&user.FirstName,
+			&user.LastName,
+			&user.IsActive,
+			&user.IsSuperuser,
+			&user.DateJoined,
+			&user.LastLogin,
+			&user.PasswordChanged,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	
+	return users, rows.Err()
+}
+
 // UpdateUser updates user information
 func (s *Service) UpdateUser(user *User) error {
+	return s.UpdateUserContext(context.Background(), user)
+}
+
+// UpdateUserContext is like UpdateUser, and records an audit event
+func (s *Service) UpdateUserContext(ctx context.Context, user *User) (err error) {
+	ctx, span := s.startSpan(ctx, "UpdateUser", userIDAttr(user.ID))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, EventUserUpdate, user.ID, err) }()
+	
 	query := `
 		UPDATE users
 		SET username = $1, email = $2, first_name = $3, last_name = $4, is_active = $5, is_superuser = $6
 		WHERE id = $7
 	`
 	
-	_, err := s.config.DBConnection.Exec(
-		query,
-		user.Username,
-		user.Email,
-		user.FirstName,
-		user.LastName,
-		user.IsActive,
-		user.IsSuperuser,
-		user.ID,
-	)
-	
-	return err
+	return s.withTx(ctx, func(tx *dbTx) error {
+		// Lock the row to detect deactivation reliably
+		var wasActive bool
+		err := tx.QueryRowContext(ctx, "SELECT is_active FROM users WHERE id = $1 FOR UPDATE", user.ID).Scan(&wasActive)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		
+		_, err = tx.ExecContext(
+			ctx,
+			query,
+			user.Username,
+			user.Email,
+			user.FirstName,
+			user.LastName,
+			user.IsActive,
+			user.IsSuperuser,
+			user.ID,
+		)
+		if err != nil {
+			return err
+		}
+		
+		if wasActive && !user.IsActive {
+			return s.enqueueWebhookEvent(ctx, tx, WebhookUserDeactivated, map[string]interface{}{
+				"user_id":  user.ID,
+				"username": user.Username,
+			})
+		}
+		return nil
+	})
 }
\ No newline at end of file
@@ -0,0 +1,278 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Client is an OAuth client allowed to introspect and revoke tokens
+type Client struct {
+	ID        int64     `json:"id"`
+	ClientID  string    `json:"client_id"`
+	Name      string    `json:"name"`
+	IsActive  bool      `json:"is_active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// IntrospectionResponse is the token introspection response (RFC 7662 section 2.2)
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+	OrgID     int64  `json:"org_id,omitempty"`
+}
+
+// CreateClient registers a new OAuth client and returns its secret.
+// The secret is only stored hashed, so it cannot be retrieved again later.
+func (s *Service) CreateClient(name string) (*Client, string, error) {
+	return s.CreateClientContext(context.Background(), name)
+}
+
+// CreateClientContext is like CreateClient, and traces the registration as
+// part of ctx
+func (s *Service) CreateClientContext(ctx context.Context, name string) (_ *Client, _ string, err error) {
+	ctx, span := s.startSpan(ctx, "CreateClient")
+	defer func() { endSpan(span, err) }()
+
+	clientID, err := s.randomToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := s.randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	hashedSecret, err := s.HashPasswordContext(ctx, secret)
+	if err != nil {
+		return nil, "", err
+	}
+
+	client := Client{ClientID: clientID, Name: name, IsActive: true}
+	err = s.db.QueryRowContext(
+		ctx,
+		"INSERT INTO oauth_clients (client_id, client_secret, name) VALUES ($1, $2, $3) RETURNING id, created_at",
+		clientID, hashedSecret, name,
+	).Scan(&client.ID, &client.CreatedAt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &client, secret, nil
+}
+
+// AuthenticateClient verifies OAuth client credentials
+func (s *Service) AuthenticateClient(clientID, secret string) (*Client, error) {
+	return s.AuthenticateClientContext(context.Background(), clientID, secret)
+}
+
+// AuthenticateClientContext is like AuthenticateClient, and traces the check
+// as part of ctx
+func (s *Service) AuthenticateClientContext(ctx context.Context, clientID, secret string) (_ *Client, err error) {
+	ctx, span := s.startSpan(ctx, "AuthenticateClient")
+	defer func() { endSpan(span, err) }()
+
+	query := `
+		SELECT id, client_id, client_secret, name, is_active, created_at
+		FROM oauth_clients
+		WHERE client_id = $1 AND is_active = true
+	`
+
+	var client Client
+	var hashedSecret string
+	err = s.db.QueryRowContext(ctx, query, clientID).Scan(
+		&client.ID,
+		&client.ClientID,
+		&hashedSecret,
+		&client.Name,
+		&client.IsActive,
+		&client.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidClient
+		}
+		return nil, err
+	}
+
+	if !s.VerifyPasswordContext(ctx, hashedSecret, secret) {
+		return nil, ErrInvalidClient
+	}
+
+	return &client, nil
+}
+
+// IntrospectToken reports whether a token, a JWT or an API key, is
+// currently active. Tokens that are malformed, expired, revoked or belong to
+// an inactive user are reported as inactive rather than as an error. The
+// service issues no refresh tokens, so there are none to introspect. Token
+// types are recognisable by their format, so the hint is ignored, as RFC
+// 7662 section 2.1 allows.
+func (s *Service) IntrospectToken(tokenString, tokenTypeHint string) (*IntrospectionResponse, error) {
+	return s.IntrospectTokenContext(context.Background(), tokenString, tokenTypeHint)
+}
+
+// IntrospectTokenContext is like IntrospectToken, and traces the check as
+// part of ctx
+func (s *Service) IntrospectTokenContext(ctx context.Context, tokenString, tokenTypeHint string) (_ *IntrospectionResponse, err error) {
+	ctx, span := s.startSpan(ctx, "IntrospectToken")
+	defer func() { endSpan(span, err) }()
+
+	inactive := &IntrospectionResponse{Active: false}
+
+	tokenType := "Bearer"
+	verify := s.VerifyJWTContext
+	if strings.HasPrefix(tokenString, APIKeyPrefix) {
+		tokenType = "ApiKey"
+		verify = s.VerifyAPIKeyContext
+	}
+
+	claims, err := verify(ctx, tokenString)
+	if err != nil {
+		if isTokenError(err) {
+			return inactive, nil
+		}
+		return nil, err
+	}
+
+	var isActive bool
+	err = s.db.QueryRowContext(ctx, "SELECT is_active FROM users WHERE id = $1", claims.UserID).Scan(&isActive)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return inactive, nil
+		}
+		return nil, err
+	}
+	if !isActive {
+		return inactive, nil
+	}
+
+	return &IntrospectionResponse{
+		Active:    true,
+		Scope:     claims.Scope,
+		Username:  claims.Username,
+		TokenType: tokenType,
+		Exp:       claims.ExpiresAt,
+		Iat:       claims.IssuedAt,
+		Sub:       strconv.FormatInt(claims.UserID, 10),
+		Jti:       claims.Id,
+		OrgID:     claims.OrgID,
+	}, nil
+}
+
+// RevokeToken revokes a JWT, by adding it to the revocation list, or an API
+// key. Invalid or already expired tokens are ignored, as required by RFC 7009.
+// A "refresh_token" hint returns ErrUnsupportedTokenType, which the
+// endpoint reports as unsupported_token_type (RFC 7009 section 2.2.1).
+func (s *Service) RevokeToken(ctx context.Context, tokenString, tokenTypeHint string) (err error) {
+	ctx, span := s.startSpan(ctx, "RevokeToken")
+	defer func() { endSpan(span, err) }()
+
+	if err := checkTokenTypeHint(tokenTypeHint); err != nil {
+		return err
+	}
+	if strings.HasPrefix(tokenString, APIKeyPrefix) {
+		return s.revokeAPIKeyByKey(ctx, tokenString)
+	}
+
+	claims, err := s.VerifyJWTContext(ctx, tokenString)
+	if err != nil {
+		if isTokenError(err) {
+			return nil
+		}
+		return err
+	}
+
+	// Tokens issued before token IDs were introduced cannot be revoked
+	if claims.Id == "" {
+		return nil
+	}
+
+	err = s.revokeTokenID(ctx, claims.Id, claims.UserID)
+	s.audit(ctx, EventTokenRevoke, claims.UserID, err)
+	return err
+}
+
+// revokeAPIKeyByKey revokes the API key itself, whichever user it belongs
+// to. Unknown and already revoked keys are ignored.
+func (s *Service) revokeAPIKeyByKey(ctx context.Context, key string) error {
+	prefix, ok := parseAPIKey(key)
+	if !ok {
+		return nil
+	}
+
+	var keyID, userID int64
+	var keyHash string
+	err := s.db.QueryRowContext(
+		ctx,
+		"SELECT id, user_id, key_hash FROM api_keys WHERE prefix = $1 AND revoked_at IS NULL",
+		prefix,
+	).Scan(&keyID, &userID, &keyHash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(keyHash), []byte(hashAPIKey(key))) != 1 {
+		return nil
+	}
+
+	_, err = s.db.ExecContext(
+		ctx,
+		"UPDATE api_keys SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL",
+		keyID, s.Now(),
+	)
+	s.audit(ctx, EventAPIKeyRevoke, userID, err)
+	return err
+}
+
+// checkTokenTypeHint rejects hints for token types the service does not
+// issue when revoking (RFC 7009 section 2.2.1). Unknown hints are ignored,
+// as RFC 7009 section 2.1 requires.
+func checkTokenTypeHint(hint string) error {
+	if hint == "refresh_token" {
+		return ErrUnsupportedTokenType
+	}
+	return nil
+}
+
+// revokeTokenID records a token ID as revoked. The entry is kept for a full
+// token lifetime, since refreshed tokens share the ID of the original login.
+func (s *Service) revokeTokenID(ctx context.Context, tokenID string, userID int64) error {
+	expiresAt := s.Now().Add(s.config.TokenDuration)
+	_, err := s.db.ExecContext(
+		ctx,
+		"INSERT INTO revoked_tokens (jti, user_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT (jti) DO NOTHING",
+		tokenID, userID, expiresAt,
+	)
+	return err
+}
+
+// isTokenRevoked checks if a token ID is on the revocation list
+func (s *Service) isTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	var revoked bool
+	err := s.db.QueryRowContext(
+		ctx,
+		"SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = $1 AND expires_at > $2)",
+		tokenID, s.Now(),
+	).Scan(&revoked)
+	return revoked, err
+}
+
+// isTokenError reports whether err means the token itself is unusable
+func isTokenError(err error) bool {
+	var validationErr *jwt.ValidationError
+	return errors.As(err, &validationErr) || errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrTokenRevoked)
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTokenTypeHints(t *testing.T) {
+	s := newTxService(t, &txDriver{})
+	ctx := context.Background()
+
+	// Introspection ignores hints, refresh_token included (RFC 7662 section 2.1)
+	for _, hint := range []string{"", "access_token", "refresh_token", "unknown"} {
+		response, err := s.IntrospectTokenContext(ctx, "token", hint)
+		if err != nil || response.Active {
+			t.Errorf("introspect with hint %q: %+v, err %v; want inactive", hint, response, err)
+		}
+	}
+
+	// Revocation reports refresh tokens as unsupported (RFC 7009 section 2.2.1)
+	if err := s.RevokeToken(ctx, "token", "refresh_token"); !errors.Is(err, ErrUnsupportedTokenType) {
+		t.Errorf("revoke: err %v, want ErrUnsupportedTokenType", err)
+	}
+
+	// Other hints are ignored, and a malformed token is silently not revoked
+	for _, hint := range []string{"", "access_token", "unknown"} {
+		if err := s.RevokeToken(ctx, "token", hint); err != nil {
+			t.Errorf("revoke with hint %q: %v", hint, err)
+		}
+		if err := s.RevokeToken(ctx, APIKeyPrefix+"malformed", hint); err != nil {
+			t.Errorf("revoke malformed API key with hint %q: %v", hint, err)
+		}
+	}
+}
@@ -0,0 +1,561 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Organization roles, from most to least privileged
+const (
+	RoleOwner  = "owner"  // Manages the organization and all of its members
+	RoleAdmin  = "admin"  // Manages members and admins
+	RoleMember = "member" // Uses the organization's resources
+)
+
+// roleRanks orders the organization roles
+var roleRanks = map[string]int{
+	RoleMember: 1,
+	RoleAdmin:  2,
+	RoleOwner:  3,
+}
+
+// ValidRole reports whether role is an organization role
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// RoleAtLeast reports whether role grants the permissions of required
+func RoleAtLeast(role, required string) bool {
+	return ValidRole(role) && roleRanks[role] >= roleRanks[required]
+}
+
+// slugPattern matches organization slugs such as "acme-corp"
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,48}[a-z0-9]$`)
+
+// Organization is a tenant. Users belong to organizations through
+// memberships, and a token selects at most one of them (TokenClaims.OrgID).
+type Organization struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Membership is a user's role in an organization
+type Membership struct {
+	OrganizationID int64     `json:"organization_id"`
+	UserID         int64     `json:"user_id"`
+	Role           string    `json:"role"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// UserOrganization is an organization a user belongs to, with their role
+type UserOrganization struct {
+	Organization
+	Role string `json:"role"`
+}
+
+// Member is a member of an organization, as listed by ListMembers
+type Member struct {
+	Membership
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// CreateOrganization creates an organization owned by ownerID
+func (s *Service) CreateOrganization(ctx context.Context, name, slug string, ownerID int64) (_ *Organization, err error) {
+	ctx, span := s.startSpan(ctx, "CreateOrganization", userIDAttr(ownerID))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, EventOrgCreate, ownerID, err) }()
+
+	name = strings.TrimSpace(name)
+	if name == "" || !slugPattern.MatchString(slug) {
+		return nil, ErrInvalidOrganization
+	}
+
+	exists, err := s.userExists(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+
+	org := Organization{Name: name, Slug: slug}
+	err = s.withTx(ctx, func(tx *dbTx) error {
+		err := tx.QueryRowContext(
+			ctx,
+			"INSERT INTO organizations (name, slug) VALUES ($1, $2) RETURNING id, created_at",
+			name, slug,
+		).Scan(&org.ID, &org.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			"INSERT INTO organization_members (organization_id, user_id, role) VALUES ($1, $2, $3)",
+			org.ID, ownerID, RoleOwner,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+// GetOrganization retrieves an organization by ID
+func (s *Service) GetOrganization(ctx context.Context, orgID int64) (_ *Organization, err error) {
+	ctx, span := s.startSpan(ctx, "GetOrganization", orgIDAttr(orgID))
+	defer func() { endSpan(span, err) }()
+
+	var org Organization
+	err = s.db.QueryRowContext(
+		ctx,
+		"SELECT id, name, slug, created_at FROM organizations WHERE id = $1",
+		orgID,
+	).Scan(&org.ID, &org.Name, &org.Slug, &org.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+// ListUserOrganizations returns the organizations a user belongs to
+func (s *Service) ListUserOrganizations(ctx context.Context, userID int64) (_ []UserOrganization, err error) {
+	ctx, span := s.startSpan(ctx, "ListUserOrganizations", userIDAttr(userID))
+	defer func() { endSpan(span, err) }()
+
+	query := `
+		SELECT o.id, o.name, o.slug, o.created_at, m.role
+		FROM organization_members m
+		JOIN organizations o ON o.id = m.organization_id
+		WHERE m.user_id = $1
+		ORDER BY o.name
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []UserOrganization{}
+	for rows.Next() {
+		var org UserOrganization
+		err := rows.Scan(&org.ID, &org.Name, &org.Slug, &org.CreatedAt, &org.Role)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+
+	return orgs, rows.Err()
+}
+
+// GetMembership returns a user's membership of an organization, or
+// ErrNotOrganizationMember
+func (s *Service) GetMembership(ctx context.Context, orgID, userID int64) (_ *Membership, err error) {
+	ctx, span := s.startSpan(ctx, "GetMembership", orgIDAttr(orgID), userIDAttr(userID))
+	defer func() { endSpan(span, err) }()
+
+	membership := Membership{OrganizationID: orgID, UserID: userID}
+	err = s.db.QueryRowContext(
+		ctx,
+		"SELECT role, created_at FROM organization_members WHERE organization_id = $1 AND user_id = $2",
+		orgID, userID,
+	).Scan(&membership.Role, &membership.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotOrganizationMember
+		}
+		return nil, err
+	}
+
+	return &membership, nil
+}
+
+// ListMembers returns the members of an organization
+func (s *Service) ListMembers(ctx context.Context, orgID int64) (_ []Member, err error) {
+	ctx, span := s.startSpan(ctx, "ListMembers", orgIDAttr(orgID))
+	defer func() { endSpan(span, err) }()
+
+	query := `
+		SELECT m.organization_id, m.user_id, m.role, m.created_at, u.username, u.email
+		FROM organization_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.organization_id = $1
+		ORDER BY u.username
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []Member{}
+	for rows.Next() {
+		var member Member
+		err := rows.Scan(
+			&member.OrganizationID,
+			&member.UserID,
+			&member.Role,
+			&member.CreatedAt,
+			&member.Username,
+			&member.Email,
+		)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+
+	return members, rows.Err()
+}
+
+// GetOrganizationUser is like GetUserByID, but only finds members of orgID
+func (s *Service) GetOrganizationUser(ctx context.Context, orgID, userID int64) (_ *User, err error) {
+	ctx, span := s.startSpan(ctx, "GetOrganizationUser", orgIDAttr(orgID), userIDAttr(userID))
+	defer func() { endSpan(span, err) }()
+
+	query := `
+		SELECT u.id, u.username, u.email, u.password, u.first_name, u.last_name, u.is_active, u.is_superuser, u.date_joined, u.last_login, u.password_changed
+		FROM users u
+		JOIN organization_members m ON m.user_id = u.id
+		WHERE m.organization_id = $1 AND u.id = $2
+	`
+
+	var user User
+	err = s.db.QueryRowContext(ctx, query, orgID, userID).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.Password,
+		&user.FirstName,
+		&user.LastName,
+		&user.IsActive,
+		&user.IsSuperuser,
+		&user.DateJoined,
+		&user.LastLogin,
+		&user.PasswordChanged,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// AddMember adds a user to an organization with a role
+func (s *Service) AddMember(ctx context.Context, orgID, userID int64, role string) (_ *Membership, err error) {
+	ctx, span := s.startSpan(ctx, "AddMember", orgIDAttr(orgID), userIDAttr(userID))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, EventOrgMemberAdd, userID, err) }()
+
+	if !ValidRole(role) {
+		return nil, ErrInvalidRole
+	}
+
+	exists, err := s.userExists(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+
+	membership := Membership{OrganizationID: orgID, UserID: userID, Role: role}
+	err = s.withTx(ctx, func(tx *dbTx) error {
+		if err := lockOrganization(ctx, tx, orgID); err != nil {
+			return err
+		}
+
+		err := tx.QueryRowContext(
+			ctx,
+			`INSERT INTO organization_members (organization_id, user_id, role) VALUES ($1, $2, $3)
+			ON CONFLICT (organization_id, user_id) DO NOTHING
+			RETURNING created_at`,
+			orgID, userID, role,
+		).Scan(&membership.CreatedAt)
+		if err == sql.ErrNoRows {
+			return ErrAlreadyMember
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &membership, nil
+}
+
+// UpdateMemberRole changes a member's role. The last owner of an
+// organization cannot be demoted.
+func (s *Service) UpdateMemberRole(ctx context.Context, orgID, userID int64, role string) (err error) {
+	ctx, span := s.startSpan(ctx, "UpdateMemberRole", orgIDAttr(orgID), userIDAttr(userID))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, EventOrgMemberUpdate, userID, err) }()
+
+	if !ValidRole(role) {
+		return ErrInvalidRole
+	}
+
+	return s.withTx(ctx, func(tx *dbTx) error {
+		current, err := lockMember(ctx, tx, orgID, userID)
+		if err != nil {
+			return err
+		}
+		if current == RoleOwner && role != RoleOwner {
+			if err := ensureOtherOwner(ctx, tx, orgID); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			"UPDATE organization_members SET role = $1 WHERE organization_id = $2 AND user_id = $3",
+			role, orgID, userID,
+		)
+		return err
+	})
+}
+
+// RemoveMember removes a user from an organization. The last owner of an
+// organization cannot be removed. Tokens selecting the organization stop
+// working for the user at once, as OrganizationMiddleware checks membership
+// on every request.
+func (s *Service) RemoveMember(ctx context.Context, orgID, userID int64) (err error) {
+	ctx, span := s.startSpan(ctx, "RemoveMember", orgIDAttr(orgID), userIDAttr(userID))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, EventOrgMemberRemove, userID, err) }()
+
+	return s.withTx(ctx, func(tx *dbTx) error {
+		current, err := lockMember(ctx, tx, orgID, userID)
+		if err != nil {
+			return err
+		}
+		if current == RoleOwner {
+			if err := ensureOtherOwner(ctx, tx, orgID); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			"DELETE FROM organization_members WHERE organization_id = $1 AND user_id = $2",
+			orgID, userID,
+		)
+		return err
+	})
+}
+
+// lockOrganization locks an organization's row, serialising changes to its
+// members
+func lockOrganization(ctx context.Context, tx *dbTx, orgID int64) error {
+	var id int64
+	err := tx.QueryRowContext(ctx, "SELECT id FROM organizations WHERE id = $1 FOR UPDATE", orgID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrOrganizationNotFound
+	}
+	return err
+}
+
+// lockMember locks an organization and returns a member's role
+func lockMember(ctx context.Context, tx *dbTx, orgID, userID int64) (string, error) {
+	if err := lockOrganization(ctx, tx, orgID); err != nil {
+		return "", err
+	}
+
+	var role string
+	err := tx.QueryRowContext(
+		ctx,
+		"SELECT role FROM organization_members WHERE organization_id = $1 AND user_id = $2",
+		orgID, userID,
+	).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrNotOrganizationMember
+	}
+	return role, err
+}
+
+// ensureOtherOwner returns ErrLastOwner unless the organization has more
+// than one owner
+func ensureOtherOwner(ctx context.Context, tx *dbTx, orgID int64) error {
+	var owners int
+	err := tx.QueryRowContext(
+		ctx,
+		"SELECT COUNT(*) FROM organization_members WHERE organization_id = $1 AND role = $2",
+		orgID, RoleOwner,
+	).Scan(&owners)
+	if err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// LoginToOrganization is like LoginContext, and selects an organization the
+// user belongs to in the token. A user who is not a member gets
+// ErrNotOrganizationMember, and the attempt is recorded as a failed login.
+func (s *Service) LoginToOrganization(ctx context.Context, username, password string, orgID int64) (_ *User, _ string, err error) {
+	ctx, span := s.startSpan(ctx, "LoginToOrganization", orgIDAttr(orgID))
+	defer func() { endSpan(span, err) }()
+
+	user, membership, err := s.AuthenticateToOrganization(ctx, username, password, orgID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := s.issueLoginToken(ctx, user, membership)
+	if err != nil {
+		return user, "", err
+	}
+
+	return user, token, nil
+}
+
+// AuthenticateToOrganization is like AuthenticateContext, and also returns
+// the user's membership of orgID, for starting a login, such as a cookie
+// session, with the organization selected. A user who is not a member gets
+// ErrNotOrganizationMember, and the attempt is recorded as a failed login.
+func (s *Service) AuthenticateToOrganization(ctx context.Context, username, password string, orgID int64) (_ *User, _ *Membership, err error) {
+	ctx, span := s.startSpan(ctx, "AuthenticateToOrganization", orgIDAttr(orgID))
+	defer func() { endSpan(span, err) }()
+
+	// The login's audit events belong to the organization
+	ctx = withAuditOrganization(ctx, orgID)
+
+	user, err := s.checkCredentials(ctx, username, password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Check membership before the login is recorded, so a non-member's
+	// attempt updates no last login time and sends no login webhook
+	membership, err := s.GetMembership(ctx, orgID, user.ID)
+	if err != nil {
+		if errors.Is(err, ErrNotOrganizationMember) {
+			s.auditFailure(ctx, EventLogin, user.ID, "not a member of the organization")
+		} else {
+			s.audit(ctx, EventLogin, user.ID, err)
+		}
+		return nil, nil, err
+	}
+	if err := s.completeLogin(ctx, user); err != nil {
+		return nil, nil, err
+	}
+
+	return user, membership, nil
+}
+
+// callerOrgID returns the organization selected by the credentials in ctx,
+// or 0 if none is. Queries that can reach other tenants' data, such as
+// GetUserByID, ListUsers and ListAuditEvents, are limited to it.
+func callerOrgID(ctx context.Context) int64 {
+	claims, err := GetUserFromContext(ctx)
+	if err != nil {
+		return 0
+	}
+	return claims.OrgID
+}
+
+// SwitchOrganization issues a token for the same login as claims with
+// another organization selected, or none if orgID is 0. The new token keeps
+// the token ID and expiry of claims, so revoking the login session revokes
+// both. Only tokens issued at login can be switched; API keys and cookie
+// sessions get ErrInvalidToken.
+func (s *Service) SwitchOrganization(ctx context.Context, claims *TokenClaims, orgID int64) (_ string, err error) {
+	ctx, span := s.startSpan(ctx, "SwitchOrganization", orgIDAttr(orgID), userIDAttr(claims.UserID))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, EventOrgSwitch, claims.UserID, err) }()
+
+	var active bool
+	err = s.db.QueryRowContext(
+		ctx,
+		"SELECT EXISTS(SELECT 1 FROM login_sessions WHERE jti = $1 AND user_id = $2 AND revoked_at IS NULL)",
+		claims.Id, claims.UserID,
+	).Scan(&active)
+	if err != nil {
+		return "", err
+	}
+	if !active {
+		return "", ErrInvalidToken
+	}
+
+	switched := *claims
+	switched.OrgID = 0
+	switched.OrgRole = ""
+	if orgID != 0 {
+		membership, err := s.GetMembership(ctx, orgID, claims.UserID)
+		if err != nil {
+			return "", err
+		}
+		switched.OrgID = membership.OrganizationID
+		switched.OrgRole = membership.Role
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, switched)
+	return token.SignedString(s.signingKey())
+}
+
+// OrganizationMiddleware protects tenant-scoped routes: the token must
+// select an organization the user is still a member of
+func (s *Service) OrganizationMiddleware(next http.Handler) http.Handler {
+	return s.RequireOrganizationRole(RoleMember)(next)
+}
+
+// RequireOrganizationRole is like OrganizationMiddleware, and also requires
+// the user's role in the organization to be at least role. Membership is
+// checked on every request, and the claims in the request context carry the
+// user's current role.
+func (s *Service) RequireOrganizationRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return s.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := r.Context().Value(UserContextKey).(*TokenClaims)
+			if claims.OrgID == 0 {
+				http.Error(w, "Organization required", http.StatusForbidden)
+				return
+			}
+
+			membership, err := s.GetMembership(r.Context(), claims.OrgID, claims.UserID)
+			if err != nil {
+				if errors.Is(err, ErrNotOrganizationMember) {
+					http.Error(w, "Organization access required", http.StatusForbidden)
+					return
+				}
+				s.logger.ErrorContext(r.Context(), "checking organization membership", "org_id", claims.OrgID, "error", err)
+				http.Error(w, "Error verifying organization membership", http.StatusInternalServerError)
+				return
+			}
+			if !RoleAtLeast(membership.Role, role) {
+				http.Error(w, "Organization "+role+" access required", http.StatusForbidden)
+				return
+			}
+
+			scoped := *claims
+			scoped.OrgRole = membership.Role
+			ctx := context.WithValue(r.Context(), UserContextKey, &scoped)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}))
+	}
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// bcryptMaxLength is the longest password bcrypt accepts, in bytes
+const bcryptMaxLength = 72
+
+// PasswordPolicy sets the rules new passwords must follow. It applies when
+// users register, change or reset their password, not to existing ones.
+type PasswordPolicy struct {
+	MinLength     int  // Minimum length in characters
+	RequireUpper  bool // At least one uppercase letter
+	RequireLower  bool // At least one lowercase letter
+	RequireDigit  bool // At least one digit
+	RequireSymbol bool // At least one character that is not a letter or digit
+}
+
+// Check returns an error wrapping ErrWeakPassword if password breaks the
+// policy. A nil policy accepts any password bcrypt can hash.
+func (p *PasswordPolicy) Check(password string) error {
+	if len(password) > bcryptMaxLength {
+		return fmt.Errorf("%w: must be at most %d bytes", ErrWeakPassword, bcryptMaxLength)
+	}
+	if p == nil {
+		return nil
+	}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("%w: must be at least %d characters", ErrWeakPassword, p.MinLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			symbol = true
+		}
+	}
+
+	switch {
+	case p.RequireUpper && !upper:
+		return fmt.Errorf("%w: must contain an uppercase letter", ErrWeakPassword)
+	case p.RequireLower && !lower:
+		return fmt.Errorf("%w: must contain a lowercase letter", ErrWeakPassword)
+	case p.RequireDigit && !digit:
+		return fmt.Errorf("%w: must contain a digit", ErrWeakPassword)
+	case p.RequireSymbol && !symbol:
+		return fmt.Errorf("%w: must contain a symbol", ErrWeakPassword)
+	}
+	return nil
+}
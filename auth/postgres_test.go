@@ -0,0 +1,640 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newPostgresService runs the service against the PostgreSQL database in
+// AUTH_TEST_DATABASE_URL, in a schema of its own that is dropped when the
+// test ends. Tests using it are skipped when the variable is unset, except
+// in CI, where skipping them would hide that they never ran.
+func newPostgresService(t *testing.T, config Config) *Service {
+	t.Helper()
+	dsn := os.Getenv("AUTH_TEST_DATABASE_URL")
+	if dsn == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatal("AUTH_TEST_DATABASE_URL must be set in CI")
+		}
+		t.Skip("AUTH_TEST_DATABASE_URL not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("authtest_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		dsn = u.String()
+	} else {
+		dsn += " search_path=" + schema
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := InitDB(db); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+
+	config.JWTSecret = "test"
+	config.DBConnection = db
+	s, err := NewService(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPostgresSchema(t *testing.T) {
+	s := newPostgresService(t, Config{})
+
+	// InitDB runs on every start, so it must apply cleanly to its own tables
+	if err := InitDB(s.db.DB); err != nil {
+		t.Fatalf("InitDB on an initialized database: %v", err)
+	}
+	if err := s.CheckSchema(context.Background()); err != nil {
+		t.Errorf("CheckSchema: %v", err)
+	}
+}
+
+func TestPostgresOTPMigration(t *testing.T) {
+	s := newPostgresService(t, Config{})
+	db := s.db.DB
+
+	// The version 2 table as first released, with times lacking a zone
+	_, err := db.Exec(`
+		DROP TABLE users_otp;
+		CREATE TABLE users_otp (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			purpose VARCHAR(20) NOT NULL,
+			code_hash VARCHAR(64) NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			verified BOOLEAN DEFAULT FALSE,
+			UNIQUE (user_id, purpose)
+		)
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := InitDB(db); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+
+	var dataType string
+	err = db.QueryRow(`
+		SELECT data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'users_otp' AND column_name = 'expires_at'
+	`).Scan(&dataType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dataType != "timestamp with time zone" {
+		t.Errorf("expires_at is %s after migration", dataType)
+	}
+}
+
+func TestPostgresOTP(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.FixedZone("UTC+5", 5*60*60))
+	s := newPostgresService(t, Config{
+		Clock:          ClockFunc(func() time.Time { return now }),
+		OTPMaxAttempts: 3,
+	})
+
+	userID, err := s.Register(User{Username: "otpuser", Email: "otp@example.com", IsActive: true}, "correct-Horse-battery-9")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	code, err := s.GenerateOTP(userID, 6, 10)
+	if err != nil {
+		t.Fatalf("GenerateOTP: %v", err)
+	}
+	if _, err := s.GenerateOTP(userID, 6, 10); !errors.Is(err, ErrOTPCooldown) {
+		t.Errorf("resend within cooldown: err %v, want ErrOTPCooldown", err)
+	}
+	if valid, err := s.VerifyOTPFor(userID, OTPReset, code); err != nil || valid {
+		t.Errorf("login code verified for reset: valid %v, err %v", valid, err)
+	}
+
+	var stored string
+	if err := s.db.QueryRow("SELECT code_hash FROM users_otp WHERE user_id = $1", userID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored, code) {
+		t.Errorf("stored %q contains the code", stored)
+	}
+
+	// Exactly one of many concurrent checks of the right code succeeds
+	const callers = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			valid, err := s.VerifyOTP(userID, code)
+			if err != nil {
+				t.Error(err)
+			}
+			if valid {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Errorf("code accepted %d times by %d concurrent calls, want exactly once", accepted, callers)
+	}
+
+	// A new code stops verifying after OTPMaxAttempts wrong guesses
+	code, err = s.GenerateOTP(userID, 6, 10)
+	if err != nil {
+		t.Fatalf("GenerateOTP after use: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		s.VerifyOTP(userID, "wrong")
+	}
+	if valid, err := s.VerifyOTP(userID, code); err != nil || valid {
+		t.Errorf("right code after the attempt limit: valid %v, err %v", valid, err)
+	}
+
+	// The cooldown and expiry hold in a non-UTC zone
+	now = now.Add(time.Minute)
+	code, err = s.GenerateOTP(userID, 6, 10)
+	if err != nil {
+		t.Fatalf("GenerateOTP after cooldown: %v", err)
+	}
+	now = now.Add(10 * time.Minute)
+	if valid, err := s.VerifyOTP(userID, code); err != nil || valid {
+		t.Errorf("expired code: valid %v, err %v", valid, err)
+	}
+}
+
+func TestPostgresAcceptInvitationAtomic(t *testing.T) {
+	s := newPostgresService(t, Config{})
+	ctx := context.Background()
+
+	ownerID, err := s.Register(User{Username: "owner", Email: "owner@example.com", IsActive: true}, "correct-Horse-battery-9")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	org, err := s.CreateOrganization(ctx, "Acme", "acme", ownerID)
+	if err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+	invitation, token, err := s.CreateInvitation(ctx, "invitee@example.com", org.ID, RoleMember)
+	if err != nil {
+		t.Fatalf("CreateInvitation: %v", err)
+	}
+
+	// Creating the account fails after the invitation is claimed; the claim
+	// must be rolled back with it
+	if _, err := s.AcceptInvitation(ctx, token, User{Username: "owner"}, "correct-Horse-battery-9"); err == nil {
+		t.Fatal("accepted with a username already taken")
+	}
+	var accepted bool
+	if err := s.db.QueryRow("SELECT accepted_at IS NOT NULL FROM invitations WHERE id = $1", invitation.ID).Scan(&accepted); err != nil {
+		t.Fatal(err)
+	}
+	if accepted {
+		t.Fatal("invitation claimed although the account was not created")
+	}
+
+	userID, err := s.AcceptInvitation(ctx, token, User{Username: "invitee"}, "correct-Horse-battery-9")
+	if err != nil {
+		t.Fatalf("AcceptInvitation: %v", err)
+	}
+	membership, err := s.GetMembership(ctx, org.ID, userID)
+	if err != nil || membership.Role != RoleMember {
+		t.Errorf("membership %+v, err %v; want member", membership, err)
+	}
+
+	if _, err := s.AcceptInvitation(ctx, token, User{Username: "second"}, "correct-Horse-battery-9"); !errors.Is(err, ErrInvalidInvitation) {
+		t.Errorf("second acceptance: err %v, want ErrInvalidInvitation", err)
+	}
+	var users int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&users); err != nil {
+		t.Fatal(err)
+	}
+	if users != 2 {
+		t.Errorf("%d users, want the owner and the invitee", users)
+	}
+}
+
+func TestPostgresAPIKeyExpiry(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := newPostgresService(t, Config{Clock: ClockFunc(func() time.Time { return now })})
+	ctx := context.Background()
+
+	userID, err := s.Register(User{Username: "keyuser", Email: "key@example.com", IsActive: true}, "correct-Horse-battery-9")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	// Two hours from now, written with a +05:00 offset
+	expiresAt := now.Add(2 * time.Hour).In(time.FixedZone("UTC+5", 5*60*60))
+	_, key, err := s.CreateAPIKey(ctx, userID, "ci", []string{ScopeRead}, &expiresAt)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if _, err := s.VerifyAPIKey(key); err != nil {
+		t.Fatalf("key before expiry: %v", err)
+	}
+
+	now = now.Add(2*time.Hour + time.Second)
+	if _, err := s.VerifyAPIKey(key); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("key after expiry: err %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestPostgresRevokeAPIKey(t *testing.T) {
+	s := newPostgresService(t, Config{})
+	ctx := context.Background()
+
+	userID, err := s.Register(User{Username: "revoker", Email: "revoker@example.com", IsActive: true}, "correct-Horse-battery-9")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	_, key, err := s.CreateAPIKey(ctx, userID, "ci", nil, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if response, err := s.IntrospectTokenContext(ctx, key, ""); err != nil || !response.Active {
+		t.Fatalf("introspect before revocation: %+v, err %v", response, err)
+	}
+
+	// A key with the right prefix but the wrong secret is ignored
+	wrong := key[:len(key)-1] + "x"
+	if wrong == key {
+		wrong = key[:len(key)-1] + "y"
+	}
+	if err := s.RevokeToken(ctx, wrong, ""); err != nil {
+		t.Fatalf("RevokeToken with a wrong secret: %v", err)
+	}
+	if _, err := s.VerifyAPIKey(key); err != nil {
+		t.Fatalf("key revoked by a wrong secret: %v", err)
+	}
+
+	if err := s.RevokeToken(ctx, key, "access_token"); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	if response, err := s.IntrospectTokenContext(ctx, key, ""); err != nil || response.Active {
+		t.Errorf("introspect after revocation: %+v, err %v; want inactive", response, err)
+	}
+}
+
+func TestPostgresCreateExternalUserAtomic(t *testing.T) {
+	s := newPostgresService(t, Config{})
+	ctx := context.Background()
+
+	// The link insert fails; the account must not be left behind
+	profile := &ExternalProfile{Subject: strings.Repeat("x", 300), Email: "jit@example.com", Username: "jit"}
+	if _, err := s.createExternalUser(ctx, "github", profile); err == nil {
+		t.Fatal("created a user for a subject too long to link")
+	}
+	var users int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE email = $1", profile.Email).Scan(&users); err != nil {
+		t.Fatal(err)
+	}
+	if users != 0 {
+		t.Fatalf("%d accounts left behind by the failed link", users)
+	}
+
+	profile.Subject = "12345"
+	userID, err := s.createExternalUser(ctx, "github", profile)
+	if err != nil {
+		t.Fatalf("createExternalUser: %v", err)
+	}
+
+	// A second login that lost the race to create the account gets the
+	// winner's account instead of an error
+	racer := &ExternalProfile{Subject: "12345", Email: "racer@example.com", Username: "racer"}
+	if racedID, err := s.createExternalUser(ctx, "github", racer); err != nil || racedID != userID {
+		t.Errorf("losing the race: user %d, err %v; want user %d", racedID, err, userID)
+	}
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE email = $1", racer.Email).Scan(&users); err != nil {
+		t.Fatal(err)
+	}
+	if users != 0 {
+		t.Errorf("the losing login left %d accounts behind", users)
+	}
+}
+
+// auditRecorder keeps the audit events a service records
+type auditRecorder struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (r *auditRecorder) Record(_ context.Context, event AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+func TestPostgresLoginToOrganizationNonMember(t *testing.T) {
+	audit := &auditRecorder{}
+	s := newPostgresService(t, Config{AuditSink: audit, Webhooks: &WebhookConfig{}})
+	ctx := context.Background()
+
+	ownerID, err := s.Register(User{Username: "owner", Email: "owner@example.com", IsActive: true}, "correct-Horse-battery-9")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	org, err := s.CreateOrganization(ctx, "Acme", "acme", ownerID)
+	if err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+	outsiderID, err := s.Register(User{Username: "outsider", Email: "outsider@example.com", IsActive: true}, "correct-Horse-battery-9")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	// Without a subscriber no login event would be queued either way
+	if _, err := s.CreateWebhookSubscription(ctx, "https://example.com/hook", []string{WebhookUserLoggedIn}); err != nil {
+		t.Fatalf("CreateWebhookSubscription: %v", err)
+	}
+	audit.events = nil
+
+	if _, _, err := s.LoginToOrganization(ctx, "outsider", "correct-Horse-battery-9", org.ID); !errors.Is(err, ErrNotOrganizationMember) {
+		t.Fatalf("LoginToOrganization: err %v, want ErrNotOrganizationMember", err)
+	}
+
+	var lastLogin sql.NullTime
+	if err := s.db.QueryRow("SELECT last_login FROM users WHERE id = $1", outsiderID).Scan(&lastLogin); err != nil {
+		t.Fatal(err)
+	}
+	if lastLogin.Valid {
+		t.Error("last_login updated for a non-member")
+	}
+	var logins int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM webhook_outbox WHERE event_type = $1", WebhookUserLoggedIn).Scan(&logins); err != nil {
+		t.Fatal(err)
+	}
+	if logins != 0 {
+		t.Errorf("%d login webhooks queued for a non-member", logins)
+	}
+	if len(audit.events) != 1 || audit.events[0].Type != EventLogin || audit.events[0].Outcome != OutcomeFailure {
+		t.Errorf("audit events %+v, want one failed login", audit.events)
+	}
+
+	if _, _, err := s.LoginToOrganization(ctx, "owner", "correct-Horse-battery-9", org.ID); err != nil {
+		t.Errorf("member login: %v", err)
+	}
+}
+
+// registerMember registers an active user and adds them to orgID
+func registerMember(t *testing.T, s *Service, username string, orgID int64, role string) int64 {
+	t.Helper()
+	userID, err := s.Register(User{Username: username, Email: username + "@example.com", IsActive: true}, "correct-Horse-battery-9")
+	if err != nil {
+		t.Fatalf("Register %s: %v", username, err)
+	}
+	if orgID != 0 {
+		if _, err := s.AddMember(context.Background(), orgID, userID, role); err != nil {
+			t.Fatalf("AddMember %s: %v", username, err)
+		}
+	}
+	return userID
+}
+
+func TestPostgresOrganizationScoping(t *testing.T) {
+	s := newPostgresService(t, Config{})
+	ctx := context.Background()
+
+	ownerID := registerMember(t, s, "owner", 0, "")
+	acme, err := s.CreateOrganization(ctx, "Acme", "acme", ownerID)
+	if err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+	memberID := registerMember(t, s, "member", acme.ID, RoleMember)
+	otherID := registerMember(t, s, "other", 0, "")
+	if _, err := s.CreateOrganization(ctx, "Globex", "globex", otherID); err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+
+	if _, _, err := s.LoginToOrganization(ctx, "owner", "correct-Horse-battery-9", acme.ID); err != nil {
+		t.Fatalf("LoginToOrganization: %v", err)
+	}
+	if _, _, err := s.LoginContext(ctx, "other", "correct-Horse-battery-9"); err != nil {
+		t.Fatalf("LoginContext: %v", err)
+	}
+
+	acmeCtx := context.WithValue(ctx, UserContextKey, &TokenClaims{UserID: ownerID, OrgID: acme.ID, OrgRole: RoleOwner})
+
+	users, err := s.ListUsers(acmeCtx, 0, 0)
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if len(users) != 2 || users[0].ID != ownerID || users[1].ID != memberID {
+		t.Errorf("ListUsers = %+v, want the owner and the member", users)
+	}
+	if _, err := s.GetUserByIDContext(acmeCtx, otherID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetUserByIDContext for another tenant: err %v, want ErrUserNotFound", err)
+	}
+	if _, err := s.GetUserByIDContext(ctx, otherID); err != nil {
+		t.Errorf("GetUserByIDContext without an organization: %v", err)
+	}
+
+	events, err := s.ListAuditEvents(acmeCtx, AuditQuery{})
+	if err != nil {
+		t.Fatalf("ListAuditEvents: %v", err)
+	}
+	if len(events) == 0 {
+		t.Fatal("ListAuditEvents: no events for the organization")
+	}
+	for _, event := range events {
+		if event.OrganizationID == nil || *event.OrganizationID != acme.ID {
+			t.Errorf("event %+v listed for organization %d", event, acme.ID)
+		}
+	}
+	all, err := s.ListAuditEvents(ctx, AuditQuery{})
+	if err != nil {
+		t.Fatalf("ListAuditEvents: %v", err)
+	}
+	if len(all) <= len(events) {
+		t.Errorf("ListAuditEvents without an organization: %d events, want more than %d", len(all), len(events))
+	}
+}
+
+func TestPostgresOrganizationAPIKey(t *testing.T) {
+	s := newPostgresService(t, Config{})
+	ctx := context.Background()
+
+	ownerID := registerMember(t, s, "owner", 0, "")
+	acme, err := s.CreateOrganization(ctx, "Acme", "acme", ownerID)
+	if err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+	memberID := registerMember(t, s, "member", acme.ID, RoleMember)
+	otherID := registerMember(t, s, "other", 0, "")
+
+	memberCtx := context.WithValue(ctx, UserContextKey, &TokenClaims{UserID: memberID, OrgID: acme.ID, OrgRole: RoleMember})
+	apiKey, key, err := s.CreateAPIKey(memberCtx, memberID, "ci", nil, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if apiKey.OrganizationID != acme.ID {
+		t.Errorf("OrganizationID = %d, want %d", apiKey.OrganizationID, acme.ID)
+	}
+	keys, err := s.ListAPIKeys(ctx, memberID)
+	if err != nil || len(keys) != 1 || keys[0].OrganizationID != acme.ID {
+		t.Errorf("ListAPIKeys = %+v, %v, want one key in organization %d", keys, err, acme.ID)
+	}
+
+	claims, err := s.VerifyAPIKeyContext(ctx, key)
+	if err != nil {
+		t.Fatalf("VerifyAPIKeyContext: %v", err)
+	}
+	if claims.OrgID != acme.ID || claims.OrgRole != RoleMember {
+		t.Errorf("claims select organization %d as %q, want %d as %q", claims.OrgID, claims.OrgRole, acme.ID, RoleMember)
+	}
+
+	otherCtx := context.WithValue(ctx, UserContextKey, &TokenClaims{UserID: otherID, OrgID: acme.ID})
+	if _, _, err := s.CreateAPIKey(otherCtx, otherID, "ci", nil, nil); !errors.Is(err, ErrNotOrganizationMember) {
+		t.Errorf("CreateAPIKey for a non-member: err %v, want ErrNotOrganizationMember", err)
+	}
+
+	if err := s.RemoveMember(ctx, acme.ID, memberID); err != nil {
+		t.Fatalf("RemoveMember: %v", err)
+	}
+	if _, err := s.VerifyAPIKeyContext(ctx, key); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("VerifyAPIKeyContext after removal: err %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestPostgresOrganizationSession(t *testing.T) {
+	s := newPostgresService(t, Config{Sessions: &SessionConfig{Insecure: true}})
+	ctx := context.Background()
+
+	ownerID := registerMember(t, s, "owner", 0, "")
+	acme, err := s.CreateOrganization(ctx, "Acme", "acme", ownerID)
+	if err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+	memberID := registerMember(t, s, "member", acme.ID, RoleMember)
+
+	user, membership, err := s.AuthenticateToOrganization(ctx, "member", "correct-Horse-battery-9", acme.ID)
+	if err != nil {
+		t.Fatalf("AuthenticateToOrganization: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	if err := s.CreateOrganizationSession(rec, httptest.NewRequest("POST", "/session", nil), user, membership); err != nil {
+		t.Fatalf("CreateOrganizationSession: %v", err)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("cookies = %v, want the session cookie", cookies)
+	}
+	authenticate := func() (*TokenClaims, error) {
+		r := httptest.NewRequest("GET", "/profile", nil)
+		r.AddCookie(cookies[0])
+		return s.authenticateSession(httptest.NewRecorder(), r)
+	}
+
+	claims, err := authenticate()
+	if err != nil {
+		t.Fatalf("authenticateSession: %v", err)
+	}
+	if claims.OrgID != acme.ID || claims.OrgRole != RoleMember {
+		t.Errorf("claims select organization %d as %q, want %d as %q", claims.OrgID, claims.OrgRole, acme.ID, RoleMember)
+	}
+
+	if err := s.RemoveMember(ctx, acme.ID, memberID); err != nil {
+		t.Fatalf("RemoveMember: %v", err)
+	}
+	if _, err := authenticate(); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("authenticateSession after removal: err %v, want ErrSessionExpired", err)
+	}
+}
+
+func TestPostgresWebhookOutbox(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := newPostgresService(t, Config{
+		Clock:    ClockFunc(func() time.Time { return now }),
+		Webhooks: &WebhookConfig{OutboxRetention: time.Hour},
+	})
+	ctx := context.Background()
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(receiver.Close)
+
+	outbox := func() int {
+		t.Helper()
+		var n int
+		if err := s.db.QueryRow("SELECT COUNT(*) FROM webhook_outbox").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if _, err := s.Register(User{Username: "first", Email: "first@example.com", IsActive: true}, "correct-Horse-battery-9"); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if n := outbox(); n != 0 {
+		t.Errorf("%d outbox events queued without subscribers", n)
+	}
+
+	if _, err := s.CreateWebhookSubscription(ctx, receiver.URL, []string{WebhookUserRegistered}); err != nil {
+		t.Fatalf("CreateWebhookSubscription: %v", err)
+	}
+	if _, _, err := s.LoginContext(ctx, "first", "correct-Horse-battery-9"); err != nil {
+		t.Fatalf("LoginContext: %v", err)
+	}
+	if n := outbox(); n != 0 {
+		t.Errorf("%d outbox events queued for an event no subscription lists", n)
+	}
+	if _, err := s.Register(User{Username: "second", Email: "second@example.com", IsActive: true}, "correct-Horse-battery-9"); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if n := outbox(); n != 1 {
+		t.Fatalf("%d outbox events queued, want 1", n)
+	}
+
+	if _, err := s.DispatchWebhooks(ctx); err != nil {
+		t.Fatalf("DispatchWebhooks: %v", err)
+	}
+	if n := outbox(); n != 1 {
+		t.Errorf("%d outbox events after fan-out, want 1 kept for the retention period", n)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := s.DispatchWebhooks(ctx); err != nil {
+		t.Fatalf("DispatchWebhooks: %v", err)
+	}
+	if n := outbox(); n != 0 {
+		t.Errorf("%d outbox events after the retention period, want 0", n)
+	}
+}
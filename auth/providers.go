@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ExternalProvider configures an external OAuth2/OIDC identity provider
+type ExternalProvider struct {
+	Name         string // Used in URLs, e.g. /auth/external/{name}/login
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	RedirectURL  string
+	Scopes       []string
+
+	// FetchProfile loads the user's profile using an access token. It is
+	// passed the provider so it can use the configured URLs. Defaults to
+	// reading standard OIDC claims from UserInfoURL.
+	FetchProfile func(ctx context.Context, client *http.Client, provider ExternalProvider, accessToken string) (*ExternalProfile, error)
+}
+
+// ExternalProfile is the user information returned by an external provider
+type ExternalProfile struct {
+	Subject   string
+	Email     string
+	Username  string
+	FirstName string
+	LastName  string
+}
+
+// GoogleProvider returns the configuration for signing in with Google
+func GoogleProvider(clientID, clientSecret, redirectURL string) ExternalProvider {
+	return ExternalProvider{
+		Name:         "google",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL:     "https://oauth2.googleapis.com/token",
+		UserInfoURL:  "https://openidconnect.googleapis.com/v1/userinfo",
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+}
+
+// GitHubProvider returns the configuration for signing in with GitHub.
+// GitHub is plain OAuth2 rather than OIDC, so it uses its own profile lookup,
+// reading the user from UserInfoURL and their emails from UserInfoURL +
+// "/emails". For GitHub Enterprise Server, replace the URLs with the
+// server's, e.g. UserInfoURL "https://github.example.com/api/v3/user".
+func GitHubProvider(clientID, clientSecret, redirectURL string) ExternalProvider {
+	return ExternalProvider{
+		Name:         "github",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		UserInfoURL:  "https://api.github.com/user",
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"read:user", "user:email"},
+		FetchProfile: fetchGitHubProfile,
+	}
+}
+
+// DiscoverProvider builds a provider configuration from an OIDC issuer's
+// discovery document (/.well-known/openid-configuration)
+func DiscoverProvider(ctx context.Context, client *http.Client, name, issuer, clientID, clientSecret, redirectURL string) (ExternalProvider, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var doc struct {
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	}
+	discoveryURL := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(ctx, client, discoveryURL, "", &doc); err != nil {
+		return ExternalProvider{}, err
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.UserInfoEndpoint == "" {
+		return ExternalProvider{}, fmt.Errorf("discovery document for %s is missing endpoints", issuer)
+	}
+
+	return ExternalProvider{
+		Name:         name,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      doc.AuthorizationEndpoint,
+		TokenURL:     doc.TokenEndpoint,
+		UserInfoURL:  doc.UserInfoEndpoint,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+	}, nil
+}
+
+// fetchProfile loads the user's profile from the provider
+func (p ExternalProvider) fetchProfile(ctx context.Context, client *http.Client, accessToken string) (*ExternalProfile, error) {
+	if p.FetchProfile != nil {
+		return p.FetchProfile(ctx, client, p, accessToken)
+	}
+
+	var claims map[string]interface{}
+	if err := getJSON(ctx, client, p.UserInfoURL, accessToken, &claims); err != nil {
+		return nil, err
+	}
+
+	profile := &ExternalProfile{
+		Subject:   claimString(claims, "sub"),
+		Email:     claimString(claims, "email"),
+		Username:  claimString(claims, "preferred_username"),
+		FirstName: claimString(claims, "given_name"),
+		LastName:  claimString(claims, "family_name"),
+	}
+	// Only trust the email if the provider says it has been verified; many
+	// providers omit the claim for addresses they never checked
+	if !claimTrue(claims, "email_verified") {
+		profile.Email = ""
+	}
+	if profile.Subject == "" {
+		return nil, errors.New("userinfo response has no subject")
+	}
+	return profile, nil
+}
+
+// fetchGitHubProfile loads a GitHub user and their primary verified email
+func fetchGitHubProfile(ctx context.Context, client *http.Client, provider ExternalProvider, accessToken string) (*ExternalProfile, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	if err := getJSON(ctx, client, provider.UserInfoURL, accessToken, &user); err != nil {
+		return nil, err
+	}
+
+	profile := &ExternalProfile{
+		Subject:  strconv.FormatInt(user.ID, 10),
+		Username: user.Login,
+	}
+	if first, last, ok := strings.Cut(user.Name, " "); ok {
+		profile.FirstName, profile.LastName = first, last
+	} else {
+		profile.FirstName = user.Name
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	emailsURL := strings.TrimSuffix(provider.UserInfoURL, "/") + "/emails"
+	if err := getJSON(ctx, client, emailsURL, accessToken, &emails); err != nil {
+		return nil, err
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			profile.Email = e.Email
+		}
+	}
+
+	return profile, nil
+}
+
+// getJSON performs a GET request and decodes the JSON response
+func getJSON(ctx context.Context, client *http.Client, url, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// claimString reads a claim as a string, accepting numeric values
+func claimString(claims map[string]interface{}, key string) string {
+	switch v := claims[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+// claimTrue reports whether a boolean claim is true. Some providers send
+// booleans as strings.
+func claimTrue(claims map[string]interface{}, key string) bool {
+	switch v := claims[key].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	default:
+		return false
+	}
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGitHubProfileUsesConfiguredURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v3/user":
+			w.Write([]byte(`{"id": 42, "login": "octocat", "name": "Mona Lisa"}`))
+		case "/api/v3/user/emails":
+			w.Write([]byte(`[
+				{"email": "old@example.com", "primary": false, "verified": true},
+				{"email": "unverified@example.com", "primary": true, "verified": false}
+			]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	provider := GitHubProvider("id", "secret", "https://app.example.com/callback")
+	provider.UserInfoURL = server.URL + "/api/v3/user"
+
+	profile, err := provider.fetchProfile(context.Background(), server.Client(), "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Subject != "42" || profile.Username != "octocat" || profile.FirstName != "Mona" || profile.LastName != "Lisa" {
+		t.Errorf("profile = %+v", profile)
+	}
+	if profile.Email != "" {
+		t.Errorf("email %q taken from an unverified or non-primary address", profile.Email)
+	}
+}
+
+func TestOIDCProfileRequiresVerifiedEmail(t *testing.T) {
+	tests := []struct {
+		name     string
+		verified interface{}
+		want     string
+	}{
+		{"verified", true, "user@example.com"},
+		{"verified as string", "true", "user@example.com"},
+		{"unverified", false, ""},
+		{"claim missing", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := map[string]interface{}{"sub": "abc", "email": "user@example.com"}
+			if tt.verified != nil {
+				claims["email_verified"] = tt.verified
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(claims)
+			}))
+			defer server.Close()
+
+			provider := ExternalProvider{Name: "oidc", UserInfoURL: server.URL}
+			profile, err := provider.fetchProfile(context.Background(), server.Client(), "token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if profile.Email != tt.want {
+				t.Errorf("email = %q, want %q", profile.Email, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// DefaultJWTSecretName is the name the JWT secret is read under from
+// Config.SecretProvider
+const DefaultJWTSecretName = "jwt_secret"
+
+// defaultSecretRefreshInterval is how often RunSecretRefresh re-reads
+// secrets unless Config.SecretRefreshInterval is set
+const defaultSecretRefreshInterval = time.Minute
+
+// RunSecretRefresh re-reads the JWT secret from Config.SecretProvider until
+// ctx is cancelled. After a rotation new tokens are signed with the new
+// secret, and tokens signed with the previous one are still accepted until
+// the next rotation, so users are not logged out.
+func (s *Service) RunSecretRefresh(ctx context.Context) error {
+	if s.config.SecretProvider == nil {
+		return ErrNoSecretProvider
+	}
+
+	ticker := time.NewTicker(s.config.SecretRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		// Keep the current secret if the provider is briefly unavailable
+		changed, err := s.jwtSecret.Refresh(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			s.logger.ErrorContext(ctx, "secret refresh failed", "secret", s.jwtSecret.Name(), "error", err)
+		case changed:
+			s.logger.InfoContext(ctx, "secret rotated", "secret", s.jwtSecret.Name())
+		}
+	}
+}
+
+// signingKey returns the secret new tokens are signed with
+func (s *Service) signingKey() []byte {
+	return []byte(s.jwtSecret.Get())
+}
+
+// verificationKeys returns the secrets tokens are checked against: the
+// current secret and, after a rotation, the previous one
+func (s *Service) verificationKeys() [][]byte {
+	keys := [][]byte{s.signingKey()}
+	if previous := s.jwtSecret.Previous(); previous != "" {
+		keys = append(keys, []byte(previous))
+	}
+	return keys
+}
+
+// parseToken parses an HMAC-signed token into claims, trying each
+// verification key in turn. derive, if not nil, maps a secret to the key
+// the token is signed with. The token's times are checked against the
+// service clock once its signature is known to be good.
+func (s *Service) parseToken(tokenString string, claims jwt.Claims, derive func(secret []byte) []byte) (token *jwt.Token, err error) {
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	for _, key := range s.verificationKeys() {
+		if derive != nil {
+			key = derive(key)
+		}
+		token, err = parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+			// Validate signing method
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return key, nil
+		})
+
+		if err == nil {
+			if err = s.validateClaims(claims); err != nil {
+				token.Valid = false
+			}
+			return token, err
+		}
+
+		// Only a bad signature is worth retrying with an older key
+		var validationErr *jwt.ValidationError
+		if !errors.As(err, &validationErr) || validationErr.Errors&jwt.ValidationErrorSignatureInvalid == 0 {
+			return token, err
+		}
+	}
+	return token, err
+}
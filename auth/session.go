@@ -0,0 +1,479 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// sessionTouchInterval limits how often a session's last-seen time is written
+const sessionTouchInterval = time.Minute
+
+// SessionConfig enables cookie-based server-side sessions
+type SessionConfig struct {
+	CookieName      string        // Default: "session_id"
+	IdleTimeout     time.Duration // Default: 30 minutes
+	AbsoluteTimeout time.Duration // Default: 12 hours
+	Domain          string
+	Path            string        // Default: "/"
+	SameSite        http.SameSite // Default: http.SameSiteLaxMode
+	Insecure        bool          // Omits the Secure flag; only for local development over HTTP
+	Store           SessionStore  // Default: SQL store on Config.DBConnection, using Config.Clock
+}
+
+// Session is a server-side login session. ID is a hash of the cookie value;
+// the cookie value itself is never stored.
+type Session struct {
+	ID             string
+	UserID         int64
+	OrganizationID int64 // Organization the session selects, or 0
+	IsSuperuser    bool  // Privilege level when the session ID was issued
+	IP             string
+	UserAgent      string
+	CreatedAt      time.Time
+	LastSeenAt     time.Time
+	ExpiresAt      time.Time // Absolute expiry
+	CSRFToken      string    // Synchronizer token, regenerated with the session ID
+}
+
+// SessionStore persists sessions
+type SessionStore interface {
+	Create(ctx context.Context, session *Session) error
+	Get(ctx context.Context, id string) (*Session, error) // Returns ErrSessionNotFound if missing
+	Touch(ctx context.Context, id string, lastSeen time.Time) error
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context, userID int64) ([]*Session, error) // Sessions of a user before their absolute expiry; the service applies the idle timeout
+}
+
+// SQLSessionStore stores sessions in the sessions table
+type SQLSessionStore struct {
+	DB    *sql.DB
+	Clock Clock // Optional: decides which sessions List treats as expired. Default: SystemClock
+
+	pool *instrumentedDB // The service's pool over DB, when the service created the store
+}
+
+// conn returns the pool statements run on
+func (st *SQLSessionStore) conn() querier {
+	if st.pool != nil {
+		return st.pool
+	}
+	return st.DB
+}
+
+// NewSQLSessionStore creates a session store backed by the sessions table
+func NewSQLSessionStore(db *sql.DB) *SQLSessionStore {
+	return &SQLSessionStore{DB: db}
+}
+
+// Create stores a new session
+func (st *SQLSessionStore) Create(ctx context.Context, session *Session) error {
+	_, err := st.conn().ExecContext(ctx, `
+		INSERT INTO sessions (id, user_id, organization_id, is_superuser, ip, user_agent, created_at, last_seen_at, expires_at, csrf_token)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6, $7, $8, $9, $10)
+	`,
+		session.ID,
+		session.UserID,
+		session.OrganizationID,
+		session.IsSuperuser,
+		session.IP,
+		session.UserAgent,
+		session.CreatedAt,
+		session.LastSeenAt,
+		session.ExpiresAt,
+		session.CSRFToken,
+	)
+	return err
+}
+
+// Get loads a session by ID
+func (st *SQLSessionStore) Get(ctx context.Context, id string) (*Session, error) {
+	query := `
+		SELECT id, user_id, COALESCE(organization_id, 0), is_superuser, ip, user_agent, created_at, last_seen_at, expires_at, csrf_token
+		FROM sessions
+		WHERE id = $1
+	`
+
+	var session Session
+	err := st.conn().QueryRowContext(ctx, query, id).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.OrganizationID,
+		&session.IsSuperuser,
+		&session.IP,
+		&session.UserAgent,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.ExpiresAt,
+		&session.CSRFToken,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Touch updates a session's last-seen time
+func (st *SQLSessionStore) Touch(ctx context.Context, id string, lastSeen time.Time) error {
+	_, err := st.conn().ExecContext(ctx, "UPDATE sessions SET last_seen_at = $1 WHERE id = $2", lastSeen, id)
+	return err
+}
+
+// Delete removes a session
+func (st *SQLSessionStore) Delete(ctx context.Context, id string) error {
+	_, err := st.conn().ExecContext(ctx, "DELETE FROM sessions WHERE id = $1", id)
+	return err
+}
+
+// List returns a user's unexpired sessions
+func (st *SQLSessionStore) List(ctx context.Context, userID int64) ([]*Session, error) {
+	query := `
+		SELECT id, user_id, COALESCE(organization_id, 0), is_superuser, ip, user_agent, created_at, last_seen_at, expires_at, csrf_token
+		FROM sessions
+		WHERE user_id = $1 AND expires_at > $2
+	`
+
+	clock := st.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	rows, err := st.conn().QueryContext(ctx, query, userID, clock.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.OrganizationID,
+			&session.IsSuperuser,
+			&session.IP,
+			&session.UserAgent,
+			&session.CreatedAt,
+			&session.LastSeenAt,
+			&session.ExpiresAt,
+			&session.CSRFToken,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+	return sessions, rows.Err()
+}
+
+// SessionsEnabled reports whether cookie sessions are configured
+func (s *Service) SessionsEnabled() bool {
+	return s.sessions != nil
+}
+
+// CreateSession starts a session for an authenticated user and sets the
+// session cookie. Any session the request already carries is discarded, so
+// the session ID always changes on login.
+func (s *Service) CreateSession(w http.ResponseWriter, r *http.Request, user *User) error {
+	return s.CreateOrganizationSession(w, r, user, nil)
+}
+
+// CreateOrganizationSession is like CreateSession, and the session selects
+// the organization of membership, as returned by AuthenticateToOrganization.
+// A nil membership selects no organization.
+func (s *Service) CreateOrganizationSession(w http.ResponseWriter, r *http.Request, user *User, membership *Membership) (err error) {
+	ctx, span := s.startSpan(r.Context(), "CreateSession")
+	defer func() { endSpan(span, err) }()
+	r = r.WithContext(ctx)
+
+	if s.sessions == nil {
+		return ErrSessionsDisabled
+	}
+
+	if old, err := r.Cookie(s.sessions.CookieName); err == nil {
+		if err := s.sessions.Store.Delete(r.Context(), hashSessionID(old.Value)); err != nil {
+			return err
+		}
+	}
+
+	now := s.Now()
+	session := &Session{
+		UserID:      user.ID,
+		IsSuperuser: user.IsSuperuser,
+		IP:          clientIP(r),
+		UserAgent:   r.UserAgent(),
+		CreatedAt:   now,
+		LastSeenAt:  now,
+		ExpiresAt:   now.Add(s.sessions.AbsoluteTimeout),
+	}
+	if membership != nil {
+		session.OrganizationID = membership.OrganizationID
+	}
+	return s.issueSession(w, r, session)
+}
+
+// SwitchSessionOrganization makes the current session select orgID, or no
+// organization if orgID is 0, and rotates the session ID. The user must be
+// a member of orgID, or ErrNotOrganizationMember is returned.
+func (s *Service) SwitchSessionOrganization(w http.ResponseWriter, r *http.Request, orgID int64) (err error) {
+	ctx, span := s.startSpan(r.Context(), "SwitchSessionOrganization", orgIDAttr(orgID))
+	defer func() { endSpan(span, err) }()
+	r = r.WithContext(ctx)
+
+	if s.sessions == nil {
+		return ErrSessionsDisabled
+	}
+
+	cookie, err := r.Cookie(s.sessions.CookieName)
+	if err != nil {
+		return ErrSessionNotFound
+	}
+	session, err := s.sessions.Store.Get(r.Context(), hashSessionID(cookie.Value))
+	if err != nil {
+		return err
+	}
+	defer func() { s.audit(r.Context(), EventOrgSwitch, session.UserID, err) }()
+
+	if orgID != 0 {
+		if _, err := s.GetMembership(r.Context(), orgID, session.UserID); err != nil {
+			return err
+		}
+	}
+	session.OrganizationID = orgID
+
+	return s.rotateSession(w, r, session)
+}
+
+// RotateSession replaces the current session ID with a new one, keeping the
+// session's timeouts. Call it after changing the user's privileges.
+func (s *Service) RotateSession(w http.ResponseWriter, r *http.Request) (err error) {
+	ctx, span := s.startSpan(r.Context(), "RotateSession")
+	defer func() { endSpan(span, err) }()
+	r = r.WithContext(ctx)
+
+	if s.sessions == nil {
+		return ErrSessionsDisabled
+	}
+
+	cookie, err := r.Cookie(s.sessions.CookieName)
+	if err != nil {
+		return ErrSessionNotFound
+	}
+	session, err := s.sessions.Store.Get(r.Context(), hashSessionID(cookie.Value))
+	if err != nil {
+		return err
+	}
+
+	user, err := s.GetUserByIDContext(r.Context(), session.UserID)
+	if err != nil {
+		return err
+	}
+	session.IsSuperuser = user.IsSuperuser
+
+	return s.rotateSession(w, r, session)
+}
+
+// DestroySession ends the current session and clears the cookie
+func (s *Service) DestroySession(w http.ResponseWriter, r *http.Request) (err error) {
+	ctx, span := s.startSpan(r.Context(), "DestroySession")
+	defer func() { endSpan(span, err) }()
+	r = r.WithContext(ctx)
+
+	if s.sessions == nil {
+		return ErrSessionsDisabled
+	}
+
+	if cookie, err := r.Cookie(s.sessions.CookieName); err == nil {
+		if err := s.sessions.Store.Delete(r.Context(), hashSessionID(cookie.Value)); err != nil {
+			return err
+		}
+	}
+
+	s.setSessionCookie(w, "", time.Unix(0, 0))
+	return nil
+}
+
+// SessionMiddleware protects routes with cookie sessions only
+func (s *Service) SessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := s.authenticateSession(w, r)
+		if err != nil {
+			http.Error(w, "Invalid or expired session", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), UserContextKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticateSession validates the session cookie and returns claims for
+// the session's user. The session ID is rotated automatically if the user's
+// privileges changed since it was issued.
+func (s *Service) authenticateSession(w http.ResponseWriter, r *http.Request) (_ *TokenClaims, err error) {
+	if s.sessions == nil {
+		return nil, ErrSessionsDisabled
+	}
+
+	cookie, err := r.Cookie(s.sessions.CookieName)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+	// Requests without a session cookie are not counted, as requests without
+	// a bearer token are not
+	defer func() { s.metrics.TokenVerification("session", verificationResult(err)) }()
+	sessionID := hashSessionID(cookie.Value)
+	session, err := s.sessions.Store.Get(r.Context(), sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.Now()
+	if now.After(session.ExpiresAt) || now.After(session.LastSeenAt.Add(s.sessions.IdleTimeout)) {
+		s.sessions.Store.Delete(r.Context(), sessionID)
+		return nil, ErrSessionExpired
+	}
+
+	// A session selecting an organization ends when the user leaves it
+	var user *User
+	var membership *Membership
+	if session.OrganizationID != 0 {
+		membership, err = s.GetMembership(r.Context(), session.OrganizationID, session.UserID)
+		if err == ErrNotOrganizationMember {
+			s.sessions.Store.Delete(r.Context(), sessionID)
+			return nil, ErrSessionExpired
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	user, err = s.GetUserByIDContext(r.Context(), session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		s.sessions.Store.Delete(r.Context(), sessionID)
+		return nil, ErrSessionExpired
+	}
+
+	if user.IsSuperuser != session.IsSuperuser {
+		session.IsSuperuser = user.IsSuperuser
+		session.LastSeenAt = now
+		if err := s.rotateSession(w, r, session); err != nil {
+			return nil, err
+		}
+	} else if now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		if err := s.sessions.Store.Touch(r.Context(), sessionID, now); err != nil {
+			return nil, err
+		}
+	}
+
+	// The claims' ID identifies the session, as the token ID does for JWTs
+	claims := &TokenClaims{
+		UserID:      user.ID,
+		Username:    user.Username,
+		IsSuperuser: user.IsSuperuser,
+		StandardClaims: jwt.StandardClaims{
+			Id:       session.ID,
+			IssuedAt: session.CreatedAt.Unix(),
+		},
+	}
+	if membership != nil {
+		claims.OrgID = membership.OrganizationID
+		claims.OrgRole = membership.Role
+	}
+	return claims, nil
+}
+
+// rotateSession stores the session under a fresh ID and updates the cookie
+func (s *Service) rotateSession(w http.ResponseWriter, r *http.Request, session *Session) error {
+	if err := s.sessions.Store.Delete(r.Context(), session.ID); err != nil {
+		return err
+	}
+	return s.issueSession(w, r, session)
+}
+
+// issueSession assigns a new random ID to the session, stores it and sets the cookie
+func (s *Service) issueSession(w http.ResponseWriter, r *http.Request, session *Session) error {
+	rawID, err := s.randomToken(32)
+	if err != nil {
+		return err
+	}
+	session.ID = hashSessionID(rawID)
+	session.CSRFToken, err = s.randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	if err := s.sessions.Store.Create(r.Context(), session); err != nil {
+		return err
+	}
+
+	s.setSessionCookie(w, rawID, session.ExpiresAt)
+	return nil
+}
+
+// setSessionCookie writes the session cookie; an empty value clears it
+func (s *Service) setSessionCookie(w http.ResponseWriter, value string, expires time.Time) {
+	cookie := &http.Cookie{
+		Name:     s.sessions.CookieName,
+		Value:    value,
+		Path:     s.sessions.Path,
+		Domain:   s.sessions.Domain,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   !s.sessions.Insecure,
+		SameSite: s.sessions.SameSite,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// withSessionDefaults fills in unset session options
+func withSessionDefaults(config SessionConfig, db *instrumentedDB, clock Clock) *SessionConfig {
+	if config.CookieName == "" {
+		config.CookieName = "session_id"
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = 30 * time.Minute
+	}
+	if config.AbsoluteTimeout <= 0 {
+		config.AbsoluteTimeout = 12 * time.Hour
+	}
+	if config.Path == "" {
+		config.Path = "/"
+	}
+	if config.SameSite == 0 {
+		config.SameSite = http.SameSiteLaxMode
+	}
+	if config.Store == nil {
+		config.Store = &SQLSessionStore{DB: db.DB, Clock: clock, pool: db}
+	}
+	return &config
+}
+
+// hashSessionID hashes a cookie value into the stored session ID
+func hashSessionID(rawID string) string {
+	sum := sha256.Sum256([]byte(rawID))
+	return hex.EncodeToString(sum[:])
+}
+
+// clientIP returns the IP address of the client that sent the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
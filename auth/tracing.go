@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans this package creates
+const tracerName = "github.com/rb4807/Golang-Utlis/auth"
+
+// newTracer returns the package tracer from provider, or from the global
+// provider if provider is nil
+func newTracer(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(tracerName)
+}
+
+// startSpan starts a span for a Service operation. Callers end it with
+// endSpan, usually deferred with the operation's named error result.
+func (s *Service) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "auth."+name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err, if any, on span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// userIDAttr labels a span with the user an operation acts on
+func userIDAttr(userID int64) attribute.KeyValue {
+	return attribute.Int64("auth.user_id", userID)
+}
+
+// orgIDAttr labels a span with the organization an operation acts on
+func orgIDAttr(orgID int64) attribute.KeyValue {
+	return attribute.Int64("auth.org_id", orgID)
+}
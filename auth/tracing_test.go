@@ -0,0 +1,62 @@
+package auth_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/authtest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	h := authtest.New(t, authtest.WithConfig(func(c *auth.Config) {
+		c.TracerProvider = provider
+		c.Sessions = &auth.SessionConfig{}
+		c.AuditSink = nil
+	}))
+	user := h.NewUser()
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "test")
+	// The store cannot answer most of these statements; only the spans matter
+	h.Service.CheckSchema(ctx)
+	h.Service.CheckSecrets(ctx)
+	h.Service.CheckNotifier(ctx)
+	h.Service.CreateClientContext(ctx, "client")
+	h.Service.ListExternalIdentitiesContext(ctx, user.ID)
+	h.Service.ListSessions(ctx, user.ID)
+	h.Service.VerifyOTPContext(ctx, user.ID, "123456")
+	h.Service.RefreshJWTContext(ctx, h.Token(user))
+	parent.End()
+
+	names := map[string]bool{}
+	var statements []string
+	for _, span := range recorder.Ended() {
+		names[span.Name()] = true
+		for _, attr := range span.Attributes() {
+			if attr.Key == "db.statement" {
+				statements = append(statements, attr.Value.AsString())
+			}
+		}
+	}
+	for _, name := range []string{"auth.CheckSchema", "auth.CheckSecrets", "auth.CheckNotifier", "auth.CreateClient", "auth.ListExternalIdentities", "auth.ListSessions", "auth.RefreshJWT"} {
+		if !names[name] {
+			t.Errorf("no %s span", name)
+		}
+	}
+
+	// The default session store and audit sink run on the instrumented pool
+	for _, table := range []string{"FROM sessions", "INSERT INTO auth_events"} {
+		found := false
+		for _, statement := range statements {
+			found = found || strings.Contains(statement, table)
+		}
+		if !found {
+			t.Errorf("no statement span for %q among %q", table, statements)
+		}
+	}
+}
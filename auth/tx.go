@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxTxAttempts is how many times withTx runs a transaction that PostgreSQL
+// aborts to resolve a conflict with a concurrent one
+const maxTxAttempts = 3
+
+// withTx runs fn in a transaction, committing if fn returns nil and rolling
+// back otherwise. It is the unit of work for operations that issue more
+// than one statement: either all of them take effect or none do. A
+// transaction aborted by a serialization failure or deadlock is run again,
+// so fn must only change state through tx. The whole transaction, retries
+// included, is recorded as one database call.
+func (s *Service) withTx(ctx context.Context, fn func(tx *dbTx) error) (err error) {
+	start := time.Now()
+	defer func() { s.metrics.ObserveDB("tx", start, err) }()
+
+	for attempt := 1; ; attempt++ {
+		err = s.runTx(ctx, fn)
+		if attempt == maxTxAttempts || !retryableTx(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// runTx runs fn in a single transaction
+func (s *Service) runTx(ctx context.Context, fn func(tx *dbTx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// uniqueViolation reports whether err is a unique_violation (23505)
+func uniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// retryableTx reports whether err aborted a transaction that may succeed
+// if run again: serialization_failure (40001) or deadlock_detected (40P01)
+func retryableTx(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && (pqErr.Code == "40001" || pqErr.Code == "40P01")
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// txDriver is a database whose transactions do nothing. Each commit returns
+// the next error in commitErrs, then nil.
+type txDriver struct {
+	begun      int
+	committed  int
+	commitErrs []error
+}
+
+func (d *txDriver) Connect(context.Context) (driver.Conn, error) { return txConn{d}, nil }
+func (d *txDriver) Driver() driver.Driver                        { return nil }
+
+type txConn struct{ d *txDriver }
+
+func (c txConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c txConn) Close() error                        { return nil }
+func (c txConn) Begin() (driver.Tx, error)           { c.d.begun++; return c, nil }
+func (c txConn) Rollback() error                     { return nil }
+
+func (c txConn) Commit() error {
+	c.d.committed++
+	if len(c.d.commitErrs) == 0 {
+		return nil
+	}
+	err := c.d.commitErrs[0]
+	c.d.commitErrs = c.d.commitErrs[1:]
+	return err
+}
+
+func newTxService(t *testing.T, d *txDriver) *Service {
+	t.Helper()
+	db := sql.OpenDB(d)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	s, err := NewService(Config{JWTSecret: "test", DBConnection: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRetryableTx(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&pq.Error{Code: "40001"}, true},
+		{&pq.Error{Code: "40P01"}, true},
+		{fmt.Errorf("claiming: %w", &pq.Error{Code: "40001"}), true},
+		{&pq.Error{Code: "23505"}, false},
+		{errors.New("40001"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := retryableTx(tt.err); got != tt.want {
+			t.Errorf("retryableTx(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestWithTxRetries(t *testing.T) {
+	conflict := &pq.Error{Code: "40001"}
+	deadlock := &pq.Error{Code: "40P01"}
+	uniqueViolation := &pq.Error{Code: "23505"}
+
+	tests := []struct {
+		name     string
+		fnErrs   []error // returned by successive runs of fn
+		commit   []error // returned by successive commits
+		wantRuns int
+		wantErr  error
+	}{
+		{"success", nil, nil, 1, nil},
+		{"serialization failure then success", []error{conflict}, nil, 2, nil},
+		{"deadlock then success", []error{deadlock, deadlock}, nil, 3, nil},
+		{"failure at commit", nil, []error{conflict}, 2, nil},
+		{"gives up after max attempts", []error{conflict, conflict, conflict, conflict}, nil, maxTxAttempts, conflict},
+		{"other errors are not retried", []error{ErrUserNotFound}, nil, 1, ErrUserNotFound},
+		{"unique violation is not retried", []error{uniqueViolation}, nil, 1, uniqueViolation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &txDriver{commitErrs: tt.commit}
+			s := newTxService(t, d)
+
+			runs := 0
+			err := s.withTx(context.Background(), func(tx *dbTx) error {
+				runs++
+				if runs <= len(tt.fnErrs) {
+					return tt.fnErrs[runs-1]
+				}
+				return nil
+			})
+
+			if runs != tt.wantRuns {
+				t.Errorf("fn ran %d times, want %d", runs, tt.wantRuns)
+			}
+			if d.begun != runs {
+				t.Errorf("%d transactions begun for %d runs", d.begun, runs)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithTxStopsWhenContextDone(t *testing.T) {
+	s := newTxService(t, &txDriver{})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	runs := 0
+	err := s.withTx(ctx, func(tx *dbTx) error {
+		runs++
+		cancel()
+		return &pq.Error{Code: "40001"}
+	})
+	if runs != 1 || !retryableTx(err) {
+		t.Errorf("after cancel: %d runs, err %v; want 1 run and the conflict", runs, err)
+	}
+}
@@ -3,13 +3,19 @@ package auth
 import (
 	"context"
 	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"math/big"
 	"strings"
 	"regexp"
+	"time"
 	"github.com/go-playground/validator/v10"
+	"github.com/rb4807/Golang-Utlis/secrets"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -19,30 +25,162 @@ const UserContextKey contextKey = "user"
 
 // Common errors
 var (
-	ErrInvalidCredentials = errors.New("invalid username or password")
-	ErrUserNotFound       = errors.New("user not found")
-	ErrInvalidPassword    = errors.New("current password is incorrect")
-	ErrUserNotInContext   = errors.New("user not found in context")
-	ErrConfigInvalid      = errors.New("configuration is invalid")
+	ErrInvalidCredentials    = errors.New("invalid username or password")
+	ErrUserNotFound          = errors.New("user not found")
+	ErrInvalidPassword       = errors.New("current password is incorrect")
+	ErrUserNotInContext      = errors.New("user not found in context")
+	ErrConfigInvalid         = errors.New("configuration is invalid")
+	ErrInvalidToken          = errors.New("invalid token")
+	ErrTokenRevoked          = errors.New("token has been revoked")
+	ErrInvalidClient         = errors.New("invalid client credentials")
+	ErrUnsupportedTokenType  = errors.New("refresh tokens are not issued, so they cannot be revoked")
+	ErrUnknownProvider       = errors.New("unknown external provider")
+	ErrInvalidOAuthState     = errors.New("invalid or expired login state")
+	ErrIdentityLinked        = errors.New("external account is already linked to another user")
+	ErrExternalEmailInUse    = errors.New("email is already registered; sign in and link the account instead")
+	ErrAPIKeyNotFound        = errors.New("API key not found")
+	ErrAPIKeyNameRequired    = errors.New("API key name is required")
+	ErrAPIKeyManagement      = errors.New("API keys cannot be created or revoked with an API key")
+	ErrInvalidScope          = errors.New("scopes must be read, write or admin")
+	ErrSessionsDisabled      = errors.New("sessions are not enabled")
+	ErrSessionNotFound       = errors.New("session not found")
+	ErrSessionExpired        = errors.New("session has expired")
+	ErrWebhooksDisabled      = errors.New("webhooks are not enabled")
+	ErrWebhookNotFound       = errors.New("webhook not found")
+	ErrInvalidWebhookURL     = errors.New("webhook URL must be an absolute http or https URL")
+	ErrInvalidWebhookEvent   = errors.New("unknown webhook event type")
+	ErrOrganizationNotFound  = errors.New("organization not found")
+	ErrInvalidOrganization   = errors.New("organization name is required and the slug must be 3-50 lowercase letters, digits or hyphens")
+	ErrNotOrganizationMember = errors.New("user is not a member of the organization")
+	ErrAlreadyMember         = errors.New("user is already a member of the organization")
+	ErrInvalidRole           = errors.New("role must be owner, admin or member")
+	ErrLastOwner             = errors.New("an organization must keep at least one owner")
+	ErrInvalidInvitation     = errors.New("invalid or expired invitation")
+	ErrInvitationNotFound    = errors.New("invitation not found")
+	ErrEmailInUse            = errors.New("email is already registered")
+	ErrInvalidEmail          = errors.New("email address is invalid")
+	ErrRegistrationDisabled  = errors.New("public registration is disabled")
+	ErrWeakPassword          = errors.New("password does not meet the password policy")
+	ErrNoSecretProvider      = errors.New("no secret provider is configured")
+	ErrSchemaOutdated        = errors.New("database schema is out of date; run migrations")
+	ErrInvalidOTPPurpose     = errors.New("OTP purpose must be login, email_verify, reset or step_up")
+	ErrOTPCooldown           = errors.New("a code was sent recently; wait before requesting another")
 )
 
 // NewService creates a new authentication service
 func NewService(config Config) (*Service, error) {
-	if config.JWTSecret == "" {
+	if config.JWTSecret == "" && config.SecretProvider == nil {
 		return nil, errors.New("JWT secret is required")
 	}
 	if config.DBConnection == nil {
 		return nil, errors.New("DB connection is required")
 	}
 	
+	providers := make(map[string]ExternalProvider)
+	for _, p := range config.ExternalProviders {
+		if p.Name == "" || p.ClientID == "" || p.AuthURL == "" || p.TokenURL == "" {
+			return nil, fmt.Errorf("%w: external provider %q is incomplete", ErrConfigInvalid, p.Name)
+		}
+		if _, exists := providers[p.Name]; exists {
+			return nil, fmt.Errorf("%w: duplicate external provider %q", ErrConfigInvalid, p.Name)
+		}
+		providers[p.Name] = p
+	}
+	
+	if config.Clock == nil {
+		config.Clock = SystemClock
+	}
+	if config.Random == nil {
+		config.Random = rand.Reader
+	}
+	
+	tracer := newTracer(config.TracerProvider)
+	db := &instrumentedDB{DB: config.DBConnection, metrics: config.Metrics, tracer: tracer}
+	
+	var sessions *SessionConfig
+	if config.Sessions != nil {
+		sessions = withSessionDefaults(*config.Sessions, db, config.Clock)
+	}
+	
+	csrf := withCSRFDefaults(CSRFConfig{})
+	if config.CSRF != nil {
+		csrf = withCSRFDefaults(*config.CSRF)
+	}
+	if csrf.Mode == CSRFSynchronizer && sessions == nil {
+		return nil, fmt.Errorf("%w: synchronizer CSRF tokens require sessions", ErrConfigInvalid)
+	}
+	
+	auditSink := config.AuditSink
+	if auditSink == nil {
+		auditSink = &SQLAuditSink{DB: config.DBConnection, pool: db}
+	}
+	
+	if config.InvitationTTL == 0 {
+		config.InvitationTTL = 7 * 24 * time.Hour
+	}
+	if config.OTPMaxAttempts <= 0 {
+		config.OTPMaxAttempts = defaultOTPMaxAttempts
+	}
+	if config.OTPResendCooldown == 0 {
+		config.OTPResendCooldown = defaultOTPResendCooldown
+	}
+	
+	var webhooks *WebhookConfig
+	if config.Webhooks != nil {
+		webhooks = withWebhookDefaults(*config.Webhooks)
+	}
+	
+	if config.JWTSecretName == "" {
+		config.JWTSecretName = DefaultJWTSecretName
+	}
+	if config.SecretRefreshInterval == 0 {
+		config.SecretRefreshInterval = defaultSecretRefreshInterval
+	}
+	secretProvider := config.SecretProvider
+	if secretProvider == nil {
+		secretProvider = secrets.Map{config.JWTSecretName: config.JWTSecret}
+	}
+	jwtSecret, err := secrets.Load(context.Background(), secretProvider, config.JWTSecretName)
+	if err != nil {
+		return nil, fmt.Errorf("loading JWT secret: %w", err)
+	}
+	
 	validate := validator.New()
 	
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	
+	replicas := make([]*instrumentedDB, len(config.ReadReplicas))
+	for i, replica := range config.ReadReplicas {
+		replicas[i] = &instrumentedDB{DB: replica, metrics: config.Metrics, tracer: tracer, replica: true}
+	}
+	
 	return &Service{
 		config:    config,
 		validator: validate,
+		providers: providers,
+		sessions:  sessions,
+		csrf:      csrf,
+		auditSink: auditSink,
+		webhooks:  webhooks,
+		jwtSecret: jwtSecret,
+		clock:     config.Clock,
+		random:    config.Random,
+		db:        db,
+		replicas:  replicas,
+		metrics:   config.Metrics,
+		tracer:    tracer,
+		logger:    logger,
 	}, nil
 }
 
+// Logger returns the logger the service writes to
+func (s *Service) Logger() *slog.Logger {
+	return s.logger
+}
+
 // validate a struct using the validator
 func (s *Service) validate(data interface{}) error {
 	return s.validator.(*validator.Validate).Struct(data)
@@ -50,6 +188,15 @@ func (s *Service) validate(data interface{}) error {
 
 // HashPassword hashes a password using bcrypt
 func (s *Service) HashPassword(password string) (string, error) {
+	return s.HashPasswordContext(context.Background(), password)
+}
+
+// HashPasswordContext is like HashPassword, and traces the hashing as part of ctx
+func (s *Service) HashPasswordContext(ctx context.Context, password string) (_ string, err error) {
+	_, span := s.startSpan(ctx, "HashPassword")
+	defer func() { endSpan(span, err) }()
+	defer s.metrics.ObservePassword("hash", time.Now())
+	
 	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return "", err
@@ -59,7 +206,17 @@ func (s *Service) HashPassword(password string) (string, error) {
 
 // VerifyPassword checks if a password matches the hash
 func (s *Service) VerifyPassword(hashedPassword, password string) bool {
+	return s.VerifyPasswordContext(context.Background(), hashedPassword, password)
+}
+
+// VerifyPasswordContext is like VerifyPassword, and traces the check as part of ctx
+func (s *Service) VerifyPasswordContext(ctx context.Context, hashedPassword, password string) bool {
+	_, span := s.startSpan(ctx, "VerifyPassword")
+	defer span.End()
+	defer s.metrics.ObservePassword("verify", time.Now())
+	
 	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	span.SetAttributes(attribute.Bool("auth.password_match", err == nil))
 	return err == nil
 }
 
@@ -67,7 +224,7 @@ func (s *Service) VerifyPassword(hashedPassword, password string) bool {
 func (s *Service) generateRandomOTP(length int) (string, error) {
 	otp := ""
 	for i := 0; i < length; i++ {
-		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		n, err := rand.Int(s.random, big.NewInt(10))
 		if err != nil {
 			return "", err
 		}
@@ -76,6 +233,24 @@ func (s *Service) generateRandomOTP(length int) (string, error) {
 	return otp, nil
 }
 
+// randomBytes reads n bytes from the service's random source
+func (s *Service) randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(s.random, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// randomToken returns a URL-safe random string built from n random bytes
+func (s *Service) randomToken(n int) (string, error) {
+	b, err := s.randomBytes(n)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
 // IsAuthenticated checks if a request is authenticated
 func (s *Service) IsAuthenticated(r *http.Request) bool {
 	_, err := GetUserFromContext(r.Context())
@@ -87,6 +262,32 @@ func AddUserToContext(ctx context.Context, claims *TokenClaims) context.Context
 	return context.WithValue(ctx, UserContextKey, claims)
 }
 
+// ClientInfo describes the client that made a request
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// clientInfoContextKey stores ClientInfo in a context
+const clientInfoContextKey contextKey = "client_info"
+
+// RequestContext returns the request's context with its ClientInfo attached,
+// for passing to Service methods that record where an action came from
+func RequestContext(r *http.Request) context.Context {
+	return WithClientInfo(r.Context(), ClientInfo{IP: clientIP(r), UserAgent: r.UserAgent()})
+}
+
+// WithClientInfo adds client information to a context
+func WithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoContextKey, info)
+}
+
+// ClientInfoFromContext extracts client information from a context
+func ClientInfoFromContext(ctx context.Context) ClientInfo {
+	info, _ := ctx.Value(clientInfoContextKey).(ClientInfo)
+	return info
+}
+
 // ValidateEmail checks if an email is well-formed
 func ValidateEmail(email string) bool {
 	// Use validator to check email
@@ -0,0 +1,576 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Webhook event types
+const (
+	WebhookUserRegistered      = "user.registered"
+	WebhookUserDeactivated     = "user.deactivated"
+	WebhookUserPasswordChanged = "user.password_changed"
+	WebhookUserLoggedIn        = "user.logged_in"
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// webhookEvents are the event types subscriptions can list
+var webhookEvents = map[string]bool{
+	WebhookUserRegistered:      true,
+	WebhookUserDeactivated:     true,
+	WebhookUserPasswordChanged: true,
+	WebhookUserLoggedIn:        true,
+}
+
+// WebhookConfig enables outbound webhooks
+type WebhookConfig struct {
+	MaxAttempts     int           // Default: 8; deliveries are dead-lettered after this many failures
+	BaseDelay       time.Duration // Default: 30 seconds; doubled after each failure
+	MaxDelay        time.Duration // Default: 1 hour
+	Timeout         time.Duration // Default: 10 seconds per delivery attempt
+	PollInterval    time.Duration // Default: 5 seconds
+	BatchSize       int           // Default: 50
+	OutboxRetention time.Duration // Default: 7 days; outbox events are deleted this long after fan-out
+}
+
+// WebhookSubscription is an endpoint that receives webhook events.
+// Events lists the event types to send; an empty list subscribes to all.
+type WebhookSubscription struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	IsActive  bool      `json:"is_active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one event queued for one subscription
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int64           `json:"subscription_id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastError      string          `json:"last_error"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// webhookEvent is the JSON body sent to subscribers
+type webhookEvent struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	CreatedAt time.Time              `json:"created_at"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// CreateWebhookSubscription registers a webhook endpoint. The returned
+// subscription includes the secret used to sign its payloads. Events must
+// be Webhook event types, or ErrInvalidWebhookEvent is returned.
+func (s *Service) CreateWebhookSubscription(ctx context.Context, endpoint string, events []string) (_ *WebhookSubscription, err error) {
+	ctx, span := s.startSpan(ctx, "CreateWebhookSubscription")
+	defer func() { endSpan(span, err) }()
+
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, ErrInvalidWebhookURL
+	}
+	for _, event := range events {
+		if !webhookEvents[event] {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidWebhookEvent, event)
+		}
+	}
+
+	secret, err := s.randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription := WebhookSubscription{URL: endpoint, Secret: secret, Events: events, IsActive: true}
+	err = s.db.QueryRowContext(
+		ctx,
+		"INSERT INTO webhook_subscriptions (url, secret, events) VALUES ($1, $2, $3) RETURNING id, created_at",
+		endpoint, secret, strings.Join(events, " "),
+	).Scan(&subscription.ID, &subscription.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &subscription, nil
+}
+
+// ListWebhookSubscriptions returns the active webhook subscriptions, without secrets
+func (s *Service) ListWebhookSubscriptions(ctx context.Context) (_ []WebhookSubscription, err error) {
+	ctx, span := s.startSpan(ctx, "ListWebhookSubscriptions")
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT id, url, events, is_active, created_at FROM webhook_subscriptions WHERE is_active = true ORDER BY id",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []WebhookSubscription{}
+	for rows.Next() {
+		var subscription WebhookSubscription
+		var events string
+		err := rows.Scan(
+			&subscription.ID,
+			&subscription.URL,
+			&events,
+			&subscription.IsActive,
+			&subscription.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		subscription.Events = strings.Fields(events)
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+// DeleteWebhookSubscription deactivates a webhook subscription; its pending
+// deliveries are no longer attempted
+func (s *Service) DeleteWebhookSubscription(ctx context.Context, subscriptionID int64) (err error) {
+	ctx, span := s.startSpan(ctx, "DeleteWebhookSubscription")
+	defer func() { endSpan(span, err) }()
+
+	result, err := s.db.ExecContext(
+		ctx,
+		"UPDATE webhook_subscriptions SET is_active = false WHERE id = $1 AND is_active = true",
+		subscriptionID,
+	)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result, ErrWebhookNotFound)
+}
+
+// ListDeadWebhookDeliveries returns deliveries that exhausted their retries
+func (s *Service) ListDeadWebhookDeliveries(ctx context.Context, limit int) (_ []WebhookDelivery, err error) {
+	ctx, span := s.startSpan(ctx, "ListDeadWebhookDeliveries")
+	defer func() { endSpan(span, err) }()
+
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	query := `
+		SELECT id, subscription_id, event_id, event_type, payload, status, attempts, last_error, created_at
+		FROM webhook_deliveries
+		WHERE status = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, DeliveryDead, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var payload []byte
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.SubscriptionID,
+			&delivery.EventID,
+			&delivery.EventType,
+			&payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.LastError,
+			&delivery.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Payload = payload
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// RetryWebhookDelivery puts a dead delivery back in the queue with a fresh
+// set of attempts
+func (s *Service) RetryWebhookDelivery(ctx context.Context, deliveryID int64) (err error) {
+	ctx, span := s.startSpan(ctx, "RetryWebhookDelivery")
+	defer func() { endSpan(span, err) }()
+
+	result, err := s.db.ExecContext(
+		ctx,
+		"UPDATE webhook_deliveries SET status = $1, attempts = 0, next_attempt_at = $4 WHERE id = $2 AND status = $3",
+		DeliveryPending, deliveryID, DeliveryDead, s.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result, ErrWebhookNotFound)
+}
+
+// RunWebhookDispatcher delivers queued webhook events until ctx is cancelled
+func (s *Service) RunWebhookDispatcher(ctx context.Context) error {
+	if s.webhooks == nil {
+		return ErrWebhooksDisabled
+	}
+
+	ticker := time.NewTicker(s.webhooks.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Errors are transient (e.g. the database is briefly unavailable),
+		// so keep polling; undelivered events stay queued
+		if _, err := s.DispatchWebhooks(ctx); err != nil && ctx.Err() == nil {
+			s.logger.ErrorContext(ctx, "webhook dispatch failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchWebhooks runs one dispatch pass: it queues new outbox events for
+// their subscribers, deletes outbox events older than the retention period
+// and attempts the deliveries that are due. It returns the number of
+// delivery attempts made.
+func (s *Service) DispatchWebhooks(ctx context.Context) (_ int, err error) {
+	ctx, span := s.startSpan(ctx, "DispatchWebhooks")
+	defer func() { endSpan(span, err) }()
+
+	if s.webhooks == nil {
+		return 0, ErrWebhooksDisabled
+	}
+
+	if err := s.fanOutWebhookEvents(ctx); err != nil {
+		return 0, err
+	}
+	_, err = s.db.ExecContext(
+		ctx,
+		"DELETE FROM webhook_outbox WHERE processed_at < $1",
+		s.Now().Add(-s.webhooks.OutboxRetention),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	deliveries, err := s.claimWebhookDeliveries(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, d := range deliveries {
+		if err := s.attemptWebhookDelivery(ctx, d); err != nil {
+			return 0, err
+		}
+	}
+	return len(deliveries), nil
+}
+
+// enqueueWebhookEvent writes an event to the outbox inside the transaction of
+// the change that caused it, so it is only sent if that change commits.
+// Events no active subscription wants are not written.
+func (s *Service) enqueueWebhookEvent(ctx context.Context, tx *dbTx, eventType string, data map[string]interface{}) error {
+	if s.webhooks == nil {
+		return nil
+	}
+
+	var subscribed bool
+	err := tx.QueryRowContext(
+		ctx,
+		"SELECT EXISTS(SELECT 1 FROM webhook_subscriptions WHERE is_active = true AND (events = '' OR $1 = ANY(string_to_array(events, ' '))))",
+		eventType,
+	).Scan(&subscribed)
+	if err != nil || !subscribed {
+		return err
+	}
+
+	eventID, err := s.randomToken(16)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(webhookEvent{
+		ID:        eventID,
+		Type:      eventType,
+		CreatedAt: s.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO webhook_outbox (event_id, event_type, payload) VALUES ($1, $2, $3)",
+		eventID, eventType, string(payload),
+	)
+	return err
+}
+
+// fanOutWebhookEvents turns unprocessed outbox events into one delivery per
+// matching subscription
+func (s *Service) fanOutWebhookEvents(ctx context.Context) error {
+	return s.withTx(ctx, func(tx *dbTx) error {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT id, event_id, event_type, payload
+			FROM webhook_outbox
+			WHERE processed_at IS NULL
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		`, s.webhooks.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		type outboxEvent struct {
+			id        int64
+			eventID   string
+			eventType string
+			payload   []byte
+		}
+		var events []outboxEvent
+		for rows.Next() {
+			var e outboxEvent
+			if err := rows.Scan(&e.id, &e.eventID, &e.eventType, &e.payload); err != nil {
+				rows.Close()
+				return err
+			}
+			events = append(events, e)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, e := range events {
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, next_attempt_at)
+				SELECT id, $1, $2, $3, $4
+				FROM webhook_subscriptions
+				WHERE is_active = true AND (events = '' OR $2 = ANY(string_to_array(events, ' ')))
+			`, e.eventID, e.eventType, string(e.payload), s.Now())
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx, "UPDATE webhook_outbox SET processed_at = $2 WHERE id = $1", e.id, s.Now())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// pendingDelivery is a delivery claimed by this dispatcher
+type pendingDelivery struct {
+	id        int64
+	eventID   string
+	eventType string
+	payload   []byte
+	attempts  int
+	url       string
+	secret    string
+}
+
+// claimWebhookDeliveries leases the due deliveries, so that other dispatchers
+// skip them while they are being sent
+func (s *Service) claimWebhookDeliveries(ctx context.Context) ([]pendingDelivery, error) {
+	var deliveries []pendingDelivery
+	err := s.withTx(ctx, func(tx *dbTx) error {
+		deliveries = nil
+		rows, err := tx.QueryContext(ctx, `
+			SELECT d.id, d.event_id, d.event_type, d.payload, d.attempts, sub.url, sub.secret
+			FROM webhook_deliveries d
+			JOIN webhook_subscriptions sub ON sub.id = d.subscription_id
+			WHERE d.status = $1 AND d.next_attempt_at <= $3 AND sub.is_active = true
+			ORDER BY d.next_attempt_at
+			LIMIT $2
+			FOR UPDATE OF d SKIP LOCKED
+		`, DeliveryPending, s.webhooks.BatchSize, s.Now())
+		if err != nil {
+			return err
+		}
+
+		for rows.Next() {
+			var d pendingDelivery
+			if err := rows.Scan(&d.id, &d.eventID, &d.eventType, &d.payload, &d.attempts, &d.url, &d.secret); err != nil {
+				rows.Close()
+				return err
+			}
+			deliveries = append(deliveries, d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		lease := s.Now().Add(2 * s.webhooks.Timeout)
+		for _, d := range deliveries {
+			_, err := tx.ExecContext(ctx, "UPDATE webhook_deliveries SET next_attempt_at = $1 WHERE id = $2", lease, d.id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return deliveries, err
+}
+
+// attemptWebhookDelivery sends one delivery and records the result,
+// scheduling a retry with exponential backoff or dead-lettering it
+func (s *Service) attemptWebhookDelivery(ctx context.Context, d pendingDelivery) error {
+	statusCode, sendErr := s.sendWebhook(ctx, d)
+	if sendErr == nil {
+		_, err := s.db.ExecContext(
+			ctx,
+			"UPDATE webhook_deliveries SET status = $1, attempts = attempts + 1, last_status = $2, last_error = '', delivered_at = $4 WHERE id = $3",
+			DeliveryDelivered, statusCode, d.id, s.Now(),
+		)
+		return err
+	}
+
+	attempts := d.attempts + 1
+	status := DeliveryPending
+	level := slog.LevelWarn
+	if attempts >= s.webhooks.MaxAttempts {
+		status = DeliveryDead
+		level = slog.LevelError
+	}
+	s.logger.Log(ctx, level, "webhook delivery failed",
+		"delivery_id", d.id,
+		"event_type", d.eventType,
+		"attempts", attempts,
+		"status", status,
+		"error", sendErr,
+	)
+
+	_, err := s.db.ExecContext(
+		ctx,
+		"UPDATE webhook_deliveries SET status = $1, attempts = $2, last_status = $3, last_error = $4, next_attempt_at = $5 WHERE id = $6",
+		status, attempts, statusCode, sendErr.Error(), s.Now().Add(s.webhookBackoff(attempts)), d.id,
+	)
+	return err
+}
+
+// sendWebhook POSTs a signed payload. The signature is an HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the subscription secret, so receivers can
+// reject replayed requests.
+func (s *Service) sendWebhook(ctx context.Context, d pendingDelivery) (_ int, err error) {
+	ctx, span := s.startSpan(ctx, "sendWebhook", attribute.String("webhook.event_type", d.eventType))
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, s.webhooks.Timeout)
+	defer cancel()
+
+	timestamp := strconv.FormatInt(s.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(d.secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(d.payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(d.payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", d.eventID)
+	req.Header.Set("X-Webhook-Event", d.eventType)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := s.httpClient().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookBackoff returns the delay before the next attempt
+func (s *Service) webhookBackoff(attempts int) time.Duration {
+	delay := s.webhooks.BaseDelay
+	for i := 1; i < attempts && delay < s.webhooks.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > s.webhooks.MaxDelay {
+		delay = s.webhooks.MaxDelay
+	}
+	return delay
+}
+
+// withWebhookDefaults fills in unset webhook options
+func withWebhookDefaults(config WebhookConfig) *WebhookConfig {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 8
+	}
+	if config.BaseDelay <= 0 {
+		config.BaseDelay = 30 * time.Second
+	}
+	if config.MaxDelay <= 0 {
+		config.MaxDelay = time.Hour
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = 5 * time.Second
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 50
+	}
+	if config.OutboxRetention <= 0 {
+		config.OutboxRetention = 7 * 24 * time.Hour
+	}
+	return &config
+}
+
+// expectAffected returns notFound if the statement changed no rows
+func expectAffected(result sql.Result, notFound error) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return notFound
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCreateWebhookSubscriptionRejectsUnknownEvents(t *testing.T) {
+	s := newTxService(t, &txDriver{})
+
+	for _, events := range [][]string{{"user.created"}, {WebhookUserLoggedIn, ""}, {"User.Registered"}} {
+		if _, err := s.CreateWebhookSubscription(context.Background(), "https://example.com/hook", events); !errors.Is(err, ErrInvalidWebhookEvent) {
+			t.Errorf("events %q: err %v, want ErrInvalidWebhookEvent", events, err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package authtest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+)
+
+// AuditLog is an auth.AuditSink that keeps events in memory, so tests can
+// assert on what was audited
+type AuditLog struct {
+	mu     sync.Mutex
+	events []auth.AuditEvent
+}
+
+// Record implements auth.AuditSink
+func (l *AuditLog) Record(ctx context.Context, event auth.AuditEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	event.ID = int64(len(l.events) + 1)
+	l.events = append(l.events, event)
+	return nil
+}
+
+// Events returns the events recorded so far, oldest first
+func (l *AuditLog) Events() []auth.AuditEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]auth.AuditEvent(nil), l.events...)
+}
+
+// OfType returns the recorded events of one type, such as auth.EventLogin
+func (l *AuditLog) OfType(eventType string) []auth.AuditEvent {
+	var events []auth.AuditEvent
+	for _, event := range l.Events() {
+		if event.Type == eventType {
+			events = append(events, event)
+		}
+	}
+	return events
+}
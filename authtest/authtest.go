@@ -0,0 +1,272 @@
+// Package authtest helps test handlers protected by the auth package
+// without a real database. A Harness wraps an auth.Service whose database
+// is a Store, and mints tokens that the service accepts:
+//
+//	func TestProfile(t *testing.T) {
+//		h := authtest.New(t)
+//		user := h.NewUser()
+//
+//		resp := h.Do(h.Request("GET", "/profile", nil, user))
+//		if resp.Code != http.StatusOK {
+//			t.Fatalf("GET /profile: %d %s", resp.Code, resp.Body)
+//		}
+//	}
+//
+// The Store is not an in-memory implementation of the service. It is a fake
+// database/sql driver that answers a fixed set of SQL statements, matched by
+// their text, and it supports only:
+//
+//   - Bearer tokens minted by the harness, on protected, admin and
+//     organization routes, including revoked tokens
+//   - looking up users by ID and username, within an organization when the
+//     token selects one, and listing organization members
+//   - listing and revoking a user's login sessions, of which there are none
+//   - GenerateOTP and VerifyOTP, and their purpose-aware For variants
+//   - starting and completing the state check of external logins
+//
+// Everything else fails with an error naming the statement, which most
+// handlers report as 500 Internal Server Error. That includes Register,
+// Login and Authenticate, API keys, cookie sessions, invitations,
+// organizations beyond memberships, webhooks and the audit log table; test
+// those against PostgreSQL. Transactions are not isolated or rolled back.
+//
+// Because statements are matched by their text, the store follows the SQL
+// in package auth: this package's tests exercise every statement it
+// answers, so a change to one of them fails here first, and a test that
+// moves beyond the list fails loudly rather than passing on missing data.
+package authtest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/router"
+)
+
+// Secret is the JWT secret a Harness configures unless an option changes it
+const Secret = "authtest-secret-do-not-use-in-production"
+
+// Harness is an auth.Service backed by a Store, with helpers for tests
+type Harness struct {
+	Service *auth.Service
+	Config  auth.Config // The configuration Service was created with
+	Store   *Store
+	Clock   *Clock
+	Audit   *AuditLog
+
+	t     testing.TB
+	users atomic.Int64
+}
+
+// Option adjusts the service configuration before New creates it
+type Option func(*auth.Config)
+
+// WithConfig lets a test change any part of the service configuration,
+// such as enabling sessions. DBConnection, AuditSink and Clock are already
+// set to the harness's store, audit log and clock.
+func WithConfig(configure func(*auth.Config)) Option {
+	return Option(configure)
+}
+
+// New creates a harness whose clock starts at the current time and drives
+// the service's clock. The service is closed when the test ends.
+func New(t testing.TB, opts ...Option) *Harness {
+	t.Helper()
+
+	store := newStore()
+	db := store.DB()
+	t.Cleanup(func() { db.Close() })
+
+	h := &Harness{
+		Store: store,
+		Clock: NewClock(time.Now().Truncate(time.Second)),
+		Audit: &AuditLog{},
+		t:     t,
+	}
+	h.Config = auth.Config{
+		JWTSecret:     Secret,
+		TokenDuration: time.Hour,
+		DBConnection:  db,
+		AuditSink:     h.Audit,
+		Clock:         h.Clock,
+		Logger:        slog.New(slog.DiscardHandler),
+	}
+	for _, opt := range opts {
+		opt(&h.Config)
+	}
+
+	service, err := auth.NewService(h.Config)
+	if err != nil {
+		t.Fatalf("authtest: creating service: %v", err)
+	}
+	h.Service = service
+	return h
+}
+
+// AddUser stores user, filling in an ID, a unique username and email, and
+// the join date when they are missing. Other fields are stored as given, so
+// the user is inactive unless IsActive is set; NewUser covers the usual
+// case.
+func (h *Harness) AddUser(user auth.User) *auth.User {
+	n := h.users.Add(1)
+	if user.Username == "" {
+		user.Username = fmt.Sprintf("user%d", n)
+	}
+	if user.Email == "" {
+		user.Email = user.Username + "@example.com"
+	}
+	if user.DateJoined.IsZero() {
+		user.DateJoined = h.Clock.Now()
+	}
+	return h.Store.AddUser(user)
+}
+
+// NewUser stores an active regular user
+func (h *Harness) NewUser() *auth.User {
+	return h.AddUser(auth.User{IsActive: true})
+}
+
+// NewSuperuser stores an active superuser
+func (h *Harness) NewSuperuser() *auth.User {
+	return h.AddUser(auth.User{IsActive: true, IsSuperuser: true})
+}
+
+// NewMember stores an active user with role in the organization
+func (h *Harness) NewMember(orgID int64, role string) *auth.User {
+	user := h.NewUser()
+	h.AddMembership(user, orgID, role)
+	return user
+}
+
+// AddMembership gives user role in the organization
+func (h *Harness) AddMembership(user *auth.User, orgID int64, role string) {
+	h.Store.AddMembership(orgID, user.ID, role, h.Clock.Now())
+}
+
+// Claims returns the claims the service would issue to user at login
+func (h *Harness) Claims(user *auth.User) auth.TokenClaims {
+	return auth.TokenClaims{
+		UserID:      user.ID,
+		Username:    user.Username,
+		IsSuperuser: user.IsSuperuser,
+		StandardClaims: jwt.StandardClaims{
+			Id: randomID(h.t),
+		},
+	}
+}
+
+// Token mints a login token for user
+func (h *Harness) Token(user *auth.User) string {
+	return h.TokenFor(h.Claims(user))
+}
+
+// OrgToken mints a token for user with orgID selected and role as the
+// user's role in it. Organization routes also check the membership, so
+// pair it with AddMembership or NewMember.
+func (h *Harness) OrgToken(user *auth.User, orgID int64, role string) string {
+	claims := h.Claims(user)
+	claims.OrgID = orgID
+	claims.OrgRole = role
+	return h.TokenFor(claims)
+}
+
+// TokenFor signs arbitrary claims with the service's secret. IssuedAt and
+// ExpiresAt default to the harness clock and the configured token
+// duration, so tests can mint expired or not-yet-valid tokens by setting
+// them.
+func (h *Harness) TokenFor(claims auth.TokenClaims) string {
+	h.t.Helper()
+	now := h.Clock.Now()
+	if claims.IssuedAt == 0 {
+		claims.IssuedAt = now.Unix()
+	}
+	if claims.ExpiresAt == 0 {
+		claims.ExpiresAt = now.Add(h.Config.TokenDuration).Unix()
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(h.Config.JWTSecret))
+	if err != nil {
+		h.t.Fatalf("authtest: signing token: %v", err)
+	}
+	return token
+}
+
+// Request builds a request for target. A non-nil body is sent as JSON; a
+// non-nil user authenticates it with a fresh token.
+func (h *Harness) Request(method, target string, body interface{}, user *auth.User) *http.Request {
+	h.t.Helper()
+	r := NewRequest(h.t, method, target, body)
+	if user != nil {
+		WithBearer(r, h.Token(user))
+	}
+	return r
+}
+
+// Handler returns the routes router.SetupRoutes serves for the service
+func (h *Harness) Handler(opts ...router.Option) http.Handler {
+	return router.SetupRoutes(h.Service, opts...)
+}
+
+// Do serves r with the service's routes and returns the recorded response
+func (h *Harness) Do(r *http.Request, opts ...router.Option) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.Handler(opts...).ServeHTTP(w, r)
+	return w
+}
+
+// Server starts an HTTP server for the service's routes, for clients that
+// need a real connection. It is closed when the test ends.
+func (h *Harness) Server(opts ...router.Option) *httptest.Server {
+	server := httptest.NewServer(h.Handler(opts...))
+	h.t.Cleanup(server.Close)
+	return server
+}
+
+// NewRequest builds a request for a handler test. A non-nil body is
+// encoded as JSON.
+func NewRequest(t testing.TB, method, target string, body interface{}) *http.Request {
+	t.Helper()
+	if body == nil {
+		return httptest.NewRequest(method, target, nil)
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("authtest: encoding request body: %v", err)
+	}
+	r := httptest.NewRequest(method, target, bytes.NewReader(data))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+// WithBearer authenticates r with token and returns it
+func WithBearer(r *http.Request, token string) *http.Request {
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+// WithClaims returns a copy of r carrying claims in its context, as
+// AuthMiddleware leaves them, for calling a handler directly
+func WithClaims(r *http.Request, claims *auth.TokenClaims) *http.Request {
+	return r.WithContext(auth.AddUserToContext(r.Context(), claims))
+}
+
+// randomID returns a token ID
+func randomID(t testing.TB) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("authtest: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,80 @@
+package authtest_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/authtest"
+)
+
+func TestExternalLoginBoundToBrowser(t *testing.T) {
+	exchanged := 0
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exchanged++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+	}))
+	defer provider.Close()
+
+	h := authtest.New(t, authtest.WithConfig(func(c *auth.Config) {
+		c.ExternalProviders = []auth.ExternalProvider{{
+			Name:     "mock",
+			ClientID: "client",
+			AuthURL:  provider.URL + "/authorize",
+			TokenURL: provider.URL + "/token",
+		}}
+		c.HTTPClient = provider.Client()
+	}))
+	user := h.NewUser()
+
+	begin := func() (state string, cookie *http.Cookie) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		authURL, err := h.Service.BeginExternalLogin(t.Context(), rec, "mock", user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := url.Parse(authURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 || !cookies[0].HttpOnly {
+			t.Fatalf("BeginExternalLogin set cookies %v, want one HttpOnly cookie", cookies)
+		}
+		return u.Query().Get("state"), cookies[0]
+	}
+	complete := func(state string, cookie *http.Cookie) error {
+		r := httptest.NewRequest(http.MethodGet, "/auth/external/mock/callback", nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		_, _, err := h.Service.CompleteExternalLogin(httptest.NewRecorder(), r, "mock", state, "code")
+		return err
+	}
+
+	state, cookie := begin()
+	_, otherCookie := begin()
+
+	if err := complete(state, nil); !errors.Is(err, auth.ErrInvalidOAuthState) {
+		t.Errorf("callback without the cookie: err %v, want ErrInvalidOAuthState", err)
+	}
+	if err := complete(state, otherCookie); !errors.Is(err, auth.ErrInvalidOAuthState) {
+		t.Errorf("callback with another browser's cookie: err %v, want ErrInvalidOAuthState", err)
+	}
+	if exchanged != 0 {
+		t.Fatalf("code exchanged %d times for callbacks from the wrong browser", exchanged)
+	}
+
+	// The starting browser gets as far as the code exchange, which the mock refuses
+	if err := complete(state, cookie); errors.Is(err, auth.ErrInvalidOAuthState) || exchanged != 1 {
+		t.Errorf("callback from the starting browser: err %v, %d exchanges; want the exchange attempted", err, exchanged)
+	}
+	if err := complete(state, cookie); !errors.Is(err, auth.ErrInvalidOAuthState) {
+		t.Errorf("reused state: err %v, want ErrInvalidOAuthState", err)
+	}
+}
@@ -0,0 +1,151 @@
+package authtest_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/authtest"
+)
+
+// TestVerifyOTPConcurrentSmoke is a unit-level smoke test: the store runs
+// each statement under a mutex, so it only shows that the service accepts a
+// code once when its statements are atomic. TestPostgresOTP in package auth
+// checks that PostgreSQL enforces single use.
+func TestVerifyOTPConcurrentSmoke(t *testing.T) {
+	h := authtest.New(t)
+	user := h.NewUser()
+
+	code, err := h.Service.GenerateOTP(user.ID, 6, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const callers = 20
+	var wg sync.WaitGroup
+	results := make(chan bool, callers)
+	start := make(chan struct{})
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			valid, err := h.Service.VerifyOTP(user.ID, code)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- valid
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(results)
+
+	accepted := 0
+	for valid := range results {
+		if valid {
+			accepted++
+		}
+	}
+	if accepted != 1 {
+		t.Errorf("code accepted %d times by %d concurrent calls, want exactly once", accepted, callers)
+	}
+}
+
+func TestVerifyOTPAttemptLimit(t *testing.T) {
+	h := authtest.New(t, authtest.WithConfig(func(c *auth.Config) { c.OTPMaxAttempts = 3 }))
+	user := h.NewUser()
+
+	code, err := h.Service.GenerateOTP(user.ID, 6, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if valid, err := h.Service.VerifyOTP(user.ID, "wrong"); err != nil || valid {
+			t.Fatalf("wrong guess %d: valid %v, err %v", i+1, valid, err)
+		}
+	}
+	if valid, err := h.Service.VerifyOTP(user.ID, code); err != nil || valid {
+		t.Errorf("right code after the attempt limit: valid %v, err %v; want rejected", valid, err)
+	}
+}
+
+func TestVerifyOTPPurpose(t *testing.T) {
+	h := authtest.New(t)
+	user := h.NewUser()
+
+	code, err := h.Service.GenerateOTP(user.ID, 6, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid, err := h.Service.VerifyOTPFor(user.ID, auth.OTPReset, code); err != nil || valid {
+		t.Errorf("login code verified for reset: valid %v, err %v", valid, err)
+	}
+	if _, err := h.Service.VerifyOTPFor(user.ID, auth.OTPPurpose("other"), code); !errors.Is(err, auth.ErrInvalidOTPPurpose) {
+		t.Errorf("unknown purpose: err %v, want ErrInvalidOTPPurpose", err)
+	}
+	if _, err := h.Service.GenerateOTPFor(user.ID, auth.OTPPurpose("other"), 6, 10); !errors.Is(err, auth.ErrInvalidOTPPurpose) {
+		t.Errorf("generating for unknown purpose: err %v, want ErrInvalidOTPPurpose", err)
+	}
+	if valid, err := h.Service.VerifyOTP(user.ID, code); err != nil || !valid {
+		t.Errorf("login code for login: valid %v, err %v; want accepted", valid, err)
+	}
+}
+
+func TestVerifyOTPExpiry(t *testing.T) {
+	h := authtest.New(t)
+	user := h.NewUser()
+
+	code, err := h.Service.GenerateOTP(user.ID, 6, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Clock.Advance(10 * time.Minute)
+	if valid, err := h.Service.VerifyOTP(user.ID, code); err != nil || valid {
+		t.Errorf("expired code: valid %v, err %v; want rejected", valid, err)
+	}
+}
+
+func TestGenerateOTPCooldown(t *testing.T) {
+	h := authtest.New(t)
+	user := h.NewUser()
+
+	first, err := h.Service.GenerateOTP(user.ID, 6, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Service.GenerateOTP(user.ID, 6, 10); !errors.Is(err, auth.ErrOTPCooldown) {
+		t.Fatalf("resend within cooldown: err %v, want ErrOTPCooldown", err)
+	}
+	if _, err := h.Service.GenerateOTPFor(user.ID, auth.OTPReset, 6, 10); err != nil {
+		t.Errorf("cooldown applied across purposes: %v", err)
+	}
+
+	h.Clock.Advance(time.Minute)
+	second, err := h.Service.GenerateOTP(user.ID, 6, 10)
+	if err != nil {
+		t.Fatalf("resend after cooldown: %v", err)
+	}
+	if first != second {
+		if valid, _ := h.Service.VerifyOTP(user.ID, first); valid {
+			t.Error("replaced code still verifies")
+		}
+	}
+	if valid, err := h.Service.VerifyOTP(user.ID, second); err != nil || !valid {
+		t.Fatalf("new code: valid %v, err %v; want accepted", valid, err)
+	}
+
+	// A used code does not hold back the next one
+	if _, err := h.Service.GenerateOTP(user.ID, 6, 10); err != nil {
+		t.Errorf("generating after the code was used: %v", err)
+	}
+}
+
+func TestGenerateOTPUnknownUser(t *testing.T) {
+	h := authtest.New(t)
+	if _, err := h.Service.GenerateOTP(999, 6, 10); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Errorf("err %v, want ErrUserNotFound", err)
+	}
+}
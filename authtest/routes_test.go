@@ -0,0 +1,205 @@
+package authtest_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/authtest"
+)
+
+func TestProtectedRoute(t *testing.T) {
+	h := authtest.New(t)
+	user := h.NewUser()
+
+	resp := h.Do(h.Request(http.MethodGet, "/profile", nil, user))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("GET /profile: %d %s", resp.Code, resp.Body)
+	}
+	var profile struct {
+		UserID   int64  `json:"user_id"`
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.UserID != user.ID || profile.Username != user.Username {
+		t.Errorf("profile = %+v, want user %d %s", profile, user.ID, user.Username)
+	}
+
+	claims := h.Claims(user)
+	revoked := h.TokenFor(claims)
+	h.Store.Revoke(claims.Id)
+
+	expired := h.Claims(user)
+	expired.IssuedAt = h.Clock.Now().Add(-2 * time.Hour).Unix()
+	expired.ExpiresAt = h.Clock.Now().Add(-time.Hour).Unix()
+
+	rejected := []struct {
+		name  string
+		token string
+	}{
+		{"no token", ""},
+		{"malformed token", "not-a-token"},
+		{"revoked token", revoked},
+		{"expired token", h.TokenFor(expired)},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			r := authtest.NewRequest(t, http.MethodGet, "/profile", nil)
+			if tt.token != "" {
+				authtest.WithBearer(r, tt.token)
+			}
+			if resp := h.Do(r); resp.Code != http.StatusUnauthorized {
+				t.Errorf("GET /profile: %d %s, want 401", resp.Code, resp.Body)
+			}
+		})
+	}
+}
+
+func TestTokenExpiresWithClock(t *testing.T) {
+	h := authtest.New(t)
+	user := h.NewUser()
+	token := h.Token(user)
+
+	h.Clock.Advance(h.Config.TokenDuration + time.Second)
+	r := authtest.WithBearer(authtest.NewRequest(t, http.MethodGet, "/profile", nil), token)
+	if resp := h.Do(r); resp.Code != http.StatusUnauthorized {
+		t.Errorf("GET /profile after the token expired: %d, want 401", resp.Code)
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	h := authtest.New(t)
+	user := h.NewUser()
+	superuser := h.NewSuperuser()
+
+	tests := []struct {
+		path string
+		user *auth.User
+		want int
+	}{
+		{"/admin", nil, http.StatusUnauthorized},
+		{"/admin", user, http.StatusForbidden},
+		{"/admin", superuser, http.StatusOK},
+		{"/superuser", user, http.StatusForbidden},
+		{"/superuser", superuser, http.StatusOK},
+	}
+	for _, tt := range tests {
+		resp := h.Do(h.Request(http.MethodGet, tt.path, nil, tt.user))
+		if resp.Code != tt.want {
+			name := "anonymous"
+			if tt.user != nil {
+				name = tt.user.Username
+			}
+			t.Errorf("GET %s as %s: %d %s, want %d", tt.path, name, resp.Code, resp.Body, tt.want)
+		}
+	}
+}
+
+func TestOrganizationRoutes(t *testing.T) {
+	const orgID = 7
+	h := authtest.New(t)
+	owner := h.NewMember(orgID, auth.RoleOwner)
+	member := h.NewMember(orgID, auth.RoleMember)
+	outsider := h.NewUser()
+
+	get := func(path, token string) *http.Response {
+		r := authtest.WithBearer(authtest.NewRequest(t, http.MethodGet, path, nil), token)
+		return h.Do(r).Result()
+	}
+
+	resp := get("/org/members", h.OrgToken(member, orgID, auth.RoleMember))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /org/members as a member: %d", resp.StatusCode)
+	}
+	var members []auth.Member
+	if err := json.NewDecoder(resp.Body).Decode(&members); err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0].UserID != owner.ID || members[1].UserID != member.ID {
+		t.Errorf("members = %+v, want the owner and the member", members)
+	}
+
+	rejected := []struct {
+		name  string
+		path  string
+		token string
+	}{
+		{"token without an organization", "/org/members", h.Token(member)},
+		{"outsider claiming membership", "/org/members", h.OrgToken(outsider, orgID, auth.RoleOwner)},
+		{"member on an admin route", "/org/invitations", h.OrgToken(member, orgID, auth.RoleMember)},
+		{"member whose token claims admin", "/org/invitations", h.OrgToken(member, orgID, auth.RoleAdmin)},
+	}
+	for _, tt := range rejected {
+		if resp := get(tt.path, tt.token); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: GET %s: %d, want 403", tt.name, tt.path, resp.StatusCode)
+		}
+	}
+}
+
+func TestProfileWithinOrganization(t *testing.T) {
+	const orgID = 7
+	h := authtest.New(t)
+	member := h.NewMember(orgID, auth.RoleMember)
+	outsider := h.NewUser()
+
+	profile := func(token string) int {
+		r := authtest.WithBearer(authtest.NewRequest(t, http.MethodGet, "/profile", nil), token)
+		return h.Do(r).Code
+	}
+
+	if code := profile(h.OrgToken(member, orgID, auth.RoleMember)); code != http.StatusOK {
+		t.Errorf("GET /profile as a member: %d, want 200", code)
+	}
+	// A token selecting an organization the user is not in, such as one
+	// issued before they were removed, finds no user
+	if code := profile(h.OrgToken(outsider, orgID, auth.RoleMember)); code != http.StatusNotFound {
+		t.Errorf("GET /profile as a non-member: %d, want 404", code)
+	}
+}
+
+func TestCSRFCookieOnlyForBrowserRequests(t *testing.T) {
+	h := authtest.New(t)
+	user := h.NewUser()
+
+	csrfCookie := func(resp *http.Response) bool {
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == "csrf_token" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if resp := h.Do(h.Request(http.MethodGet, "/profile", nil, user)).Result(); csrfCookie(resp) {
+		t.Error("GET /profile with a bearer token set a CSRF cookie")
+	}
+	r := authtest.WithBearer(authtest.NewRequest(t, http.MethodGet, "/csrf", nil), h.Token(user))
+	if resp := h.Do(r).Result(); csrfCookie(resp) {
+		t.Error("GET /csrf with a bearer token set a CSRF cookie")
+	}
+	if resp := h.Do(authtest.NewRequest(t, http.MethodGet, "/csrf", nil)).Result(); !csrfCookie(resp) {
+		t.Error("GET /csrf without credentials set no CSRF cookie")
+	}
+}
+
+func TestUnsupportedQueryFailsLoudly(t *testing.T) {
+	h := authtest.New(t)
+	admin := h.NewMember(1, auth.RoleAdmin)
+
+	// Listing invitations is not supported by the store, so the route fails
+	// rather than answering with an empty list
+	r := authtest.WithBearer(authtest.NewRequest(t, http.MethodGet, "/org/invitations", nil), h.OrgToken(admin, 1, auth.RoleAdmin))
+	if resp := h.Do(r); resp.Code != http.StatusInternalServerError {
+		t.Errorf("GET /org/invitations: %d %s, want 500", resp.Code, resp.Body)
+	}
+
+	_, err := h.Service.ListInvitations(t.Context(), 1)
+	if err == nil || !strings.Contains(err.Error(), "authtest: unsupported query") {
+		t.Errorf("ListInvitations: err %v, want the unsupported query named", err)
+	}
+}
@@ -0,0 +1,192 @@
+package authtest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/authtest"
+	"github.com/rb4807/Golang-Utlis/metrics"
+)
+
+// memorySessions is a session store for tests that need cookie sessions
+type memorySessions struct {
+	mu       sync.Mutex
+	sessions map[string]*auth.Session
+}
+
+func (m *memorySessions) Create(_ context.Context, session *auth.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := *session
+	m.sessions[session.ID] = &stored
+	return nil
+}
+
+func (m *memorySessions) Get(_ context.Context, id string) (*auth.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, auth.ErrSessionNotFound
+	}
+	stored := *session
+	return &stored, nil
+}
+
+func (m *memorySessions) Touch(_ context.Context, id string, lastSeen time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if session, ok := m.sessions[id]; ok {
+		session.LastSeenAt = lastSeen
+	}
+	return nil
+}
+
+func (m *memorySessions) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *memorySessions) List(_ context.Context, userID int64) ([]*auth.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var sessions []*auth.Session
+	for _, session := range m.sessions {
+		if session.UserID == userID {
+			stored := *session
+			sessions = append(sessions, &stored)
+		}
+	}
+	return sessions, nil
+}
+
+func newSessionHarness(t *testing.T) (*authtest.Harness, *memorySessions) {
+	store := &memorySessions{sessions: make(map[string]*auth.Session)}
+	h := authtest.New(t, authtest.WithConfig(func(c *auth.Config) {
+		c.Sessions = &auth.SessionConfig{IdleTimeout: 30 * time.Minute, Store: store}
+	}))
+	return h, store
+}
+
+func TestListSessionsSkipsIdleSessions(t *testing.T) {
+	h, store := newSessionHarness(t)
+	user := h.NewUser()
+	now := h.Clock.Now()
+	store.Create(context.Background(), &auth.Session{ID: "active", UserID: user.ID, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)})
+	store.Create(context.Background(), &auth.Session{ID: "idle", UserID: user.ID, LastSeenAt: now.Add(-31 * time.Minute), ExpiresAt: now.Add(time.Hour)})
+
+	sessions, err := h.Service.ListSessions(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != "active" {
+		t.Errorf("sessions = %+v, want only the active one", sessions)
+	}
+}
+
+func TestRevokeOtherSessionsKeepsCurrent(t *testing.T) {
+	h, store := newSessionHarness(t)
+	user := h.NewUser()
+	now := h.Clock.Now()
+	for _, id := range []string{"a", "b"} {
+		store.Create(context.Background(), &auth.Session{ID: id, UserID: user.ID, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)})
+	}
+
+	if err := h.Service.RevokeOtherSessions(context.Background(), user.ID, "unknown"); !errors.Is(err, auth.ErrSessionNotFound) {
+		t.Fatalf("unknown keep ID: err %v, want ErrSessionNotFound", err)
+	}
+	if len(store.sessions) != 2 {
+		t.Fatalf("%d sessions left after an unknown keep ID, want 2", len(store.sessions))
+	}
+
+	if err := h.Service.RevokeOtherSessions(context.Background(), user.ID, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.sessions["a"]; !ok || len(store.sessions) != 1 {
+		t.Errorf("sessions left: %v, want only a", store.sessions)
+	}
+}
+
+func TestSignOutOthersRequiresLoginSession(t *testing.T) {
+	h, store := newSessionHarness(t)
+	user := h.NewUser()
+	now := h.Clock.Now()
+	store.Create(context.Background(), &auth.Session{ID: "browser", UserID: user.ID, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)})
+
+	// A token without an ID, as API keys and old tokens have, has no session to keep
+	r := authtest.NewRequest(t, http.MethodDelete, "/sessions", nil)
+	authtest.WithBearer(r, h.TokenFor(auth.TokenClaims{UserID: user.ID, Username: user.Username}))
+
+	resp := h.Do(r)
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("DELETE /sessions without a session ID: %d %s, want 403", resp.Code, resp.Body)
+	}
+	if len(store.sessions) != 1 {
+		t.Error("sessions were revoked")
+	}
+}
+
+func TestSessionVerificationsCounted(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	store := &memorySessions{sessions: make(map[string]*auth.Session)}
+	h := authtest.New(t, authtest.WithConfig(func(c *auth.Config) {
+		c.Sessions = &auth.SessionConfig{Store: store}
+		c.Metrics = metrics.NewWithRegistry(registry, registry)
+	}))
+	user := h.NewUser()
+
+	login := httptest.NewRecorder()
+	if err := h.Service.CreateSession(login, authtest.NewRequest(t, http.MethodPost, "/session/login", nil), user); err != nil {
+		t.Fatal(err)
+	}
+	cookie := login.Result().Cookies()[0]
+
+	profile := func(cookie *http.Cookie) {
+		r := authtest.NewRequest(t, http.MethodGet, "/profile", nil)
+		r.AddCookie(cookie)
+		h.Do(r)
+	}
+	profile(cookie)
+	profile(&http.Cookie{Name: cookie.Name, Value: "unknown"})
+	h.Clock.Advance(time.Hour)
+	profile(cookie)
+	h.Do(authtest.NewRequest(t, http.MethodGet, "/profile", nil))
+
+	want := map[string]float64{"valid": 1, "invalid": 1, "expired": 1}
+	got := map[string]float64{}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != metrics.Namespace+"_token_verifications_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["type"] == "session" {
+				got[labels["result"]] = metric.GetCounter().GetValue()
+			}
+		}
+	}
+	for result, n := range want {
+		if got[result] != n {
+			t.Errorf("session verifications %s = %v, want %v (all: %v)", result, got[result], n, got)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("session verifications %v, want %v", got, want)
+	}
+}
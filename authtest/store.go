@@ -0,0 +1,408 @@
+package authtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+)
+
+// Store is the fake database behind a Harness. It keeps users,
+// organization memberships, revoked token IDs, one-time passwords and
+// pending external logins in maps, and answers only the statements listed
+// in queries and execs, the ones the package documentation names. Any other
+// statement fails with an error naming it.
+//
+// Each statement runs atomically, as a single PostgreSQL statement would.
+type Store struct {
+	mu          sync.Mutex
+	users       map[int64]*auth.User
+	memberships map[[2]int64]*auth.Membership
+	revoked     map[string]bool
+	otps        map[otpKey]*otp
+	states      map[string]*externalState
+	nextUserID  int64
+}
+
+// otpKey identifies a one-time password: a user and a purpose
+type otpKey struct {
+	userID  int64
+	purpose string
+}
+
+// otp is a row of the users_otp table
+type otp struct {
+	codeHash  string
+	attempts  int64
+	createdAt time.Time
+	expiresAt time.Time
+	verified  bool
+}
+
+// externalState is a row of the external_auth_states table
+type externalState struct {
+	provider    string
+	verifier    string
+	browserHash string
+	linkUserID  driver.Value
+	expiresAt   time.Time
+}
+
+func newStore() *Store {
+	return &Store{
+		users:       make(map[int64]*auth.User),
+		memberships: make(map[[2]int64]*auth.Membership),
+		revoked:     make(map[string]bool),
+		otps:        make(map[otpKey]*otp),
+		states:      make(map[string]*externalState),
+	}
+}
+
+// DB returns a connection pool backed by the store
+func (s *Store) DB() *sql.DB {
+	return sql.OpenDB(connector{s})
+}
+
+// AddUser stores a copy of user, assigning the next ID if it has none
+func (s *Store) AddUser(user auth.User) *auth.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user.ID == 0 {
+		s.nextUserID++
+		user.ID = s.nextUserID
+	} else if user.ID > s.nextUserID {
+		s.nextUserID = user.ID
+	}
+	s.users[user.ID] = &user
+	stored := user
+	return &stored
+}
+
+// AddMembership makes the user a member of the organization with role
+func (s *Store) AddMembership(orgID, userID int64, role string, createdAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.memberships[[2]int64{orgID, userID}] = &auth.Membership{
+		OrganizationID: orgID,
+		UserID:         userID,
+		Role:           role,
+		CreatedAt:      createdAt,
+	}
+}
+
+// Revoke adds a token ID to the revocation list
+func (s *Store) Revoke(tokenID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked[tokenID] = true
+}
+
+// userColumns are the columns auth.Service selects for a User
+var userColumns = []string{
+	"id", "username", "email", "password", "first_name", "last_name",
+	"is_active", "is_superuser", "date_joined", "last_login", "password_changed",
+}
+
+// userRow returns user's values in userColumns order
+func userRow(user *auth.User) []driver.Value {
+	return []driver.Value{
+		user.ID, user.Username, user.Email, user.Password, user.FirstName, user.LastName,
+		user.IsActive, user.IsSuperuser, user.DateJoined, optionalTime(user.LastLogin), optionalTime(user.PasswordChanged),
+	}
+}
+
+func optionalTime(t *time.Time) driver.Value {
+	if t == nil {
+		return nil
+	}
+	return *t
+}
+
+// queries maps each supported query, with whitespace collapsed, to the
+// function answering it
+var queries = map[string]func(s *Store, args []driver.Value) (*rows, error){
+	"SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = $1 AND expires_at > $2)": func(s *Store, args []driver.Value) (*rows, error) {
+		return singleRow([]string{"exists"}, []driver.Value{s.revoked[asString(args[0])]}), nil
+	},
+	"SELECT " + strings.Join(userColumns, ", ") + " FROM users WHERE id = $1": func(s *Store, args []driver.Value) (*rows, error) {
+		user, ok := s.users[asInt(args[0])]
+		if !ok {
+			return &rows{columns: userColumns}, nil
+		}
+		return singleRow(userColumns, userRow(user)), nil
+	},
+	"SELECT " + strings.Join(userColumns, ", ") + " FROM users WHERE username = $1": func(s *Store, args []driver.Value) (*rows, error) {
+		for _, user := range s.users {
+			if user.Username == asString(args[0]) {
+				return singleRow(userColumns, userRow(user)), nil
+			}
+		}
+		return &rows{columns: userColumns}, nil
+	},
+	"SELECT u." + strings.Join(userColumns, ", u.") + " FROM users u JOIN organization_members m ON m.user_id = u.id WHERE m.organization_id = $1 AND u.id = $2": func(s *Store, args []driver.Value) (*rows, error) {
+		user, ok := s.users[asInt(args[1])]
+		if _, member := s.memberships[[2]int64{asInt(args[0]), asInt(args[1])}]; !ok || !member {
+			return &rows{columns: userColumns}, nil
+		}
+		return singleRow(userColumns, userRow(user)), nil
+	},
+	"SELECT role, created_at FROM organization_members WHERE organization_id = $1 AND user_id = $2": func(s *Store, args []driver.Value) (*rows, error) {
+		columns := []string{"role", "created_at"}
+		membership, ok := s.memberships[[2]int64{asInt(args[0]), asInt(args[1])}]
+		if !ok {
+			return &rows{columns: columns}, nil
+		}
+		return singleRow(columns, []driver.Value{membership.Role, membership.CreatedAt}), nil
+	},
+	"SELECT m.organization_id, m.user_id, m.role, m.created_at, u.username, u.email FROM organization_members m JOIN users u ON u.id = m.user_id WHERE m.organization_id = $1 ORDER BY u.username": func(s *Store, args []driver.Value) (*rows, error) {
+		result := &rows{columns: []string{"organization_id", "user_id", "role", "created_at", "username", "email"}}
+		for key, membership := range s.memberships {
+			if key[0] != asInt(args[0]) {
+				continue
+			}
+			user := s.users[membership.UserID]
+			result.values = append(result.values, []driver.Value{membership.OrganizationID, membership.UserID, membership.Role, membership.CreatedAt, user.Username, user.Email})
+		}
+		sort.Slice(result.values, func(i, j int) bool {
+			return result.values[i][4].(string) < result.values[j][4].(string)
+		})
+		return result, nil
+	},
+
+	// Login sessions, listed with the user's sessions. The store does not
+	// keep them, so there are none.
+	"SELECT jti, ip, user_agent, created_at, last_seen_at, expires_at FROM login_sessions WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2": func(*Store, []driver.Value) (*rows, error) {
+		return &rows{columns: []string{"jti", "ip", "user_agent", "created_at", "last_seen_at", "expires_at"}}, nil
+	},
+
+	// One-time passwords
+	"SELECT id FROM users WHERE id = $1 FOR UPDATE": func(s *Store, args []driver.Value) (*rows, error) {
+		columns := []string{"id"}
+		user, ok := s.users[asInt(args[0])]
+		if !ok {
+			return &rows{columns: columns}, nil
+		}
+		return singleRow(columns, []driver.Value{user.ID}), nil
+	},
+	"SELECT created_at FROM users_otp WHERE user_id = $1 AND purpose = $2 AND verified = false": func(s *Store, args []driver.Value) (*rows, error) {
+		columns := []string{"created_at"}
+		code, ok := s.otps[otpKey{asInt(args[0]), asString(args[1])}]
+		if !ok || code.verified {
+			return &rows{columns: columns}, nil
+		}
+		return singleRow(columns, []driver.Value{code.createdAt}), nil
+	},
+	"UPDATE users_otp SET attempts = attempts + 1, verified = (code_hash = ANY($3)) WHERE user_id = $1 AND purpose = $2 AND expires_at > $4 AND verified = false AND attempts < $5 RETURNING verified, attempts": func(s *Store, args []driver.Value) (*rows, error) {
+		columns := []string{"verified", "attempts"}
+		code, ok := s.otps[otpKey{asInt(args[0]), asString(args[1])}]
+		if !ok || !code.expiresAt.After(asTime(args[3])) || code.verified || code.attempts >= asInt(args[4]) {
+			return &rows{columns: columns}, nil
+		}
+		code.attempts++
+		for _, hash := range asArray(args[2]) {
+			if hash == code.codeHash {
+				code.verified = true
+			}
+		}
+		return singleRow(columns, []driver.Value{code.verified, code.attempts}), nil
+	},
+
+	// External logins
+	"DELETE FROM external_auth_states WHERE state = $1 AND provider = $2 AND browser_hash = $3 AND expires_at > $4 RETURNING code_verifier, link_user_id": func(s *Store, args []driver.Value) (*rows, error) {
+		columns := []string{"code_verifier", "link_user_id"}
+		state, ok := s.states[asString(args[0])]
+		if !ok || state.provider != asString(args[1]) || state.browserHash != asString(args[2]) || !state.expiresAt.After(asTime(args[3])) {
+			return &rows{columns: columns}, nil
+		}
+		delete(s.states, asString(args[0]))
+		return singleRow(columns, []driver.Value{state.verifier, state.linkUserID}), nil
+	},
+}
+
+// execs maps each supported statement to the function applying it, which
+// returns the number of rows affected
+var execs = map[string]func(s *Store, args []driver.Value) (int64, error){
+	// Login session activity, recorded for each authenticated request, and
+	// sign-outs. The store does not keep login sessions.
+	"UPDATE login_sessions SET last_seen_at = $2 WHERE jti = $1 AND last_seen_at < $3": func(*Store, []driver.Value) (int64, error) {
+		return 0, nil
+	},
+	"UPDATE login_sessions SET revoked_at = $3 WHERE jti = $1 AND user_id = $2 AND revoked_at IS NULL": func(*Store, []driver.Value) (int64, error) {
+		return 0, nil
+	},
+	"INSERT INTO users_otp (user_id, purpose, code_hash, attempts, created_at, expires_at, verified) VALUES ($1, $2, $3, 0, $4, $5, false) ON CONFLICT (user_id, purpose) DO UPDATE SET code_hash = EXCLUDED.code_hash, attempts = 0, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at, verified = false": func(s *Store, args []driver.Value) (int64, error) {
+		s.otps[otpKey{asInt(args[0]), asString(args[1])}] = &otp{
+			codeHash:  asString(args[2]),
+			createdAt: asTime(args[3]),
+			expiresAt: asTime(args[4]),
+		}
+		return 1, nil
+	},
+	"INSERT INTO external_auth_states (state, provider, code_verifier, browser_hash, link_user_id, expires_at) VALUES ($1, $2, $3, $4, $5, $6)": func(s *Store, args []driver.Value) (int64, error) {
+		if _, ok := s.states[asString(args[0])]; ok {
+			return 0, errors.New("authtest: duplicate external_auth_states key")
+		}
+		s.states[asString(args[0])] = &externalState{
+			provider:    asString(args[1]),
+			verifier:    asString(args[2]),
+			browserHash: asString(args[3]),
+			linkUserID:  args[4],
+			expiresAt:   asTime(args[5]),
+		}
+		return 1, nil
+	},
+}
+
+// answered records the statements the stores have answered, so this
+// package's tests can check that they cover every one
+var answered sync.Map
+
+func normalize(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+func asString(v driver.Value) string {
+	s, _ := v.(string)
+	return s
+}
+
+func asInt(v driver.Value) int64 {
+	n, _ := v.(int64)
+	return n
+}
+
+func asTime(v driver.Value) time.Time {
+	t, _ := v.(time.Time)
+	return t
+}
+
+// asArray decodes a text array sent with pq.Array. The elements must not
+// contain commas.
+func asArray(v driver.Value) []string {
+	literal := strings.Trim(asString(v), "{}")
+	if literal == "" {
+		return nil
+	}
+	elements := strings.Split(literal, ",")
+	for i, element := range elements {
+		elements[i] = strings.Trim(element, `"`)
+	}
+	return elements
+}
+
+// connector opens connections to a Store
+type connector struct {
+	store *Store
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	return conn{c.store}, nil
+}
+
+func (c connector) Driver() driver.Driver {
+	return storeDriver{}
+}
+
+// storeDriver only exists to satisfy driver.Connector; stores are opened
+// with Store.DB
+type storeDriver struct{}
+
+func (storeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("authtest: open stores with Store.DB")
+}
+
+// conn runs statements against a Store. Transactions are accepted but not
+// isolated: statements apply as they run.
+type conn struct {
+	store *Store
+}
+
+func (c conn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("authtest: unsupported statement: %s", normalize(query))
+}
+
+func (c conn) Close() error {
+	return nil
+}
+
+func (c conn) Begin() (driver.Tx, error) {
+	return tx{}, nil
+}
+
+func (c conn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return tx{}, nil
+}
+
+func (c conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	answer, ok := queries[normalize(query)]
+	if !ok {
+		return nil, fmt.Errorf("authtest: unsupported query: %s", normalize(query))
+	}
+	answered.Store(normalize(query), true)
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	return answer(c.store, values(args))
+}
+
+func (c conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	apply, ok := execs[normalize(query)]
+	if !ok {
+		return nil, fmt.Errorf("authtest: unsupported statement: %s", normalize(query))
+	}
+	answered.Store(normalize(query), true)
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	affected, err := apply(c.store, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(affected), nil
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	vs := make([]driver.Value, len(args))
+	for i, arg := range args {
+		vs[i] = arg.Value
+	}
+	return vs
+}
+
+type tx struct{}
+
+func (tx) Commit() error   { return nil }
+func (tx) Rollback() error { return nil }
+
+// rows is a result set held in memory
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func singleRow(columns []string, values []driver.Value) *rows {
+	return &rows{columns: columns, values: [][]driver.Value{values}}
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,84 @@
+package authtest
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+)
+
+// TestMain fails the run if a statement the store answers was never
+// exercised, since the store would no longer be checked against the SQL in
+// package auth
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if code == 0 && ranAllTests() {
+		var missing []string
+		for query := range queries {
+			if _, ok := answered.Load(query); !ok {
+				missing = append(missing, query)
+			}
+		}
+		for statement := range execs {
+			if _, ok := answered.Load(statement); !ok {
+				missing = append(missing, statement)
+			}
+		}
+		for _, statement := range missing {
+			fmt.Fprintf(os.Stderr, "authtest: no test exercises: %s\n", statement)
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// ranAllTests reports whether every test ran, rather than those selected
+// with -run
+func ranAllTests() bool {
+	run := flag.Lookup("test.run")
+	return run == nil || run.Value.String() == ""
+}
+
+func TestOTPStoredHashedInUTC(t *testing.T) {
+	h := New(t)
+	h.Clock.Set(h.Clock.Now().In(time.FixedZone("UTC+5", 5*60*60)))
+	user := h.NewUser()
+
+	code, err := h.Service.GenerateOTP(user.ID, 6, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, ok := h.Store.otps[otpKey{user.ID, string(auth.OTPLogin)}]
+	if !ok {
+		t.Fatal("no code stored")
+	}
+	if strings.Contains(stored.codeHash, code) {
+		t.Errorf("stored %q contains the code %q", stored.codeHash, code)
+	}
+	if stored.createdAt.Location() != time.UTC {
+		t.Errorf("created_at written in %s, want UTC", stored.createdAt.Location())
+	}
+}
+
+func TestGetUserByUsername(t *testing.T) {
+	h := New(t)
+	user := h.NewUser()
+
+	got, err := h.Service.GetUserByUsername(context.Background(), user.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != user.ID {
+		t.Errorf("got user %d, want %d", got.ID, user.ID)
+	}
+
+	if _, err := h.Service.GetUserByUsername(context.Background(), "nobody"); err != auth.ErrUserNotFound {
+		t.Errorf("unknown username: got %v, want %v", err, auth.ErrUserNotFound)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/db"
+)
+
+func runMigrate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	database, err := db.InitDB()
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	if err := auth.InitDB(database); err != nil {
+		return err
+	}
+	fmt.Println("Authentication tables are up to date")
+	return nil
+}
+
+func runCreateSuperuser(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("createsuperuser", flag.ContinueOnError)
+	username := fs.String("username", "", "username (prompted for if empty)")
+	email := fs.String("email", "", "email address (prompted for if empty)")
+	password := fs.String("password", "", "password (prompted for if empty; visible in the process list)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var err error
+	if *username == "" {
+		if *username, err = prompt("Username: "); err != nil {
+			return err
+		}
+	}
+	if *email == "" {
+		if *email, err = prompt("Email address: "); err != nil {
+			return err
+		}
+	}
+	if *password == "" {
+		if *password, err = promptNewPassword(); err != nil {
+			return err
+		}
+	}
+
+	authService, err := newService(false, 0)
+	if err != nil {
+		return err
+	}
+
+	userID, err := authService.RegisterContext(ctx, auth.User{
+		Username:    *username,
+		Email:       *email,
+		IsActive:    true,
+		IsSuperuser: true,
+	}, *password)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Superuser %q created with ID %d\n", *username, userID)
+	return nil
+}
+
+func runChangePassword(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("changepassword", flag.ContinueOnError)
+	username := fs.String("username", "", "user whose password to set (required)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("-username is required")
+	}
+
+	authService, err := newService(false, 0)
+	if err != nil {
+		return err
+	}
+	user, err := authService.GetUserByUsername(ctx, *username)
+	if err != nil {
+		return err
+	}
+
+	password, err := promptNewPassword()
+	if err != nil {
+		return err
+	}
+	if err := authService.ResetPasswordContext(ctx, user.ID, password); err != nil {
+		return err
+	}
+
+	fmt.Printf("Password changed for %q\n", user.Username)
+	return nil
+}
+
+func runUsers(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("users", flag.ContinueOnError)
+	offset := fs.Int("offset", 0, "number of users to skip")
+	limit := fs.Int("limit", 100, "maximum number of users to list")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	authService, err := newService(false, 0)
+	if err != nil {
+		return err
+	}
+	users, err := authService.ListUsers(ctx, *offset, *limit)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSERNAME\tEMAIL\tACTIVE\tSUPERUSER\tLAST LOGIN")
+	for _, user := range users {
+		lastLogin := "never"
+		if user.LastLogin != nil {
+			lastLogin = user.LastLogin.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%t\t%t\t%s\n",
+			user.ID, user.Username, user.Email, user.IsActive, user.IsSuperuser, lastLogin)
+	}
+	return tw.Flush()
+}
+
+func runDeactivate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("deactivate", flag.ContinueOnError)
+	username := fs.String("username", "", "user to deactivate (required)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("-username is required")
+	}
+
+	authService, err := newService(false, 0)
+	if err != nil {
+		return err
+	}
+	user, err := authService.GetUserByUsername(ctx, *username)
+	if err != nil {
+		return err
+	}
+
+	user.IsActive = false
+	if err := authService.UpdateUserContext(ctx, user); err != nil {
+		return err
+	}
+
+	// Tokens already issued stay valid until revoked
+	if err := authService.RevokeOtherSessions(ctx, user.ID, ""); err != nil {
+		return fmt.Errorf("deactivated %q, but signing them out failed: %w", user.Username, err)
+	}
+
+	fmt.Printf("User %q deactivated and signed out\n", user.Username)
+	return nil
+}
+
+func runOTP(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("otp", flag.ContinueOnError)
+	username := fs.String("username", "", "user to generate the OTP for (required)")
+	purpose := fs.String("purpose", string(auth.OTPLogin), "what the OTP is for: login, email_verify, reset or step_up")
+	length := fs.Int("length", 6, "number of digits")
+	validity := fs.Int("validity", 15, "minutes until the OTP expires")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("-username is required")
+	}
+	if !auth.OTPPurpose(*purpose).Valid() {
+		return auth.ErrInvalidOTPPurpose
+	}
+
+	authService, err := newService(false, 0)
+	if err != nil {
+		return err
+	}
+	user, err := authService.GetUserByUsername(ctx, *username)
+	if err != nil {
+		return err
+	}
+
+	otp, err := authService.GenerateOTPForContext(ctx, user.ID, auth.OTPPurpose(*purpose), *length, *validity)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(otp)
+	return nil
+}
+
+func runMintToken(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("mint-token", flag.ContinueOnError)
+	username := fs.String("username", "", "user to issue the token for (required)")
+	duration := fs.Duration("duration", time.Hour, "token lifetime")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("-username is required")
+	}
+
+	authService, err := newService(true, *duration)
+	if err != nil {
+		return err
+	}
+	user, err := authService.GetUserByUsername(ctx, *username)
+	if err != nil {
+		return err
+	}
+	if !user.IsActive {
+		return fmt.Errorf("user %q is not active", user.Username)
+	}
+
+	token, err := authService.GenerateJWT(user)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(token)
+	return nil
+}
+
+func runVerifyToken(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("verify-token", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: authctl verify-token <token>")
+	}
+
+	authService, err := newService(true, 0)
+	if err != nil {
+		return err
+	}
+
+	claims, err := authService.VerifyJWTContext(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(claims); err != nil {
+		return err
+	}
+	fmt.Printf("Expires at %s\n", time.Unix(claims.ExpiresAt, 0).Format(time.RFC3339))
+	return nil
+}
+
+func runCreateClient(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("create-client", flag.ContinueOnError)
+	name := fs.String("name", "", "name of the service using the client (required)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return errors.New("-name is required")
+	}
+
+	authService, err := newService(false, 0)
+	if err != nil {
+		return err
+	}
+	client, secret, err := authService.CreateClientContext(ctx, *name)
+	if err != nil {
+		return err
+	}
+
+	// The secret is stored hashed, so this is the only time it is shown
+	fmt.Printf("Client %q created\n", client.Name)
+	fmt.Printf("client_id:     %s\n", client.ClientID)
+	fmt.Printf("client_secret: %s\n", secret)
+	return nil
+}
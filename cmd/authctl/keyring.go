@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+
+	"github.com/rb4807/Golang-Utlis/secrets"
+)
+
+// keyringPassphraseEnv supplies the keyring passphrase without a prompt,
+// as it does for the server
+const keyringPassphraseEnv = "SECRETS_KEYRING_PASSPHRASE"
+
+func runKeyring(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("keyring", flag.ContinueOnError)
+	path := flags.String("file", os.Getenv("SECRETS_KEYRING"), "keyring file (env SECRETS_KEYRING)")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: authctl keyring [-file path] list | set <name> | delete <name>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return errors.New("-file is required")
+	}
+
+	action, name := flags.Arg(0), flags.Arg(1)
+	switch {
+	case action == "list" && flags.NArg() == 1:
+	case (action == "set" || action == "delete") && flags.NArg() == 2:
+	default:
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	passphrase := os.Getenv(keyringPassphraseEnv)
+	if passphrase == "" {
+		var err error
+		if passphrase, err = promptPassword("Keyring passphrase: "); err != nil {
+			return err
+		}
+	}
+
+	// A missing keyring is created by the first set
+	values, err := secrets.ReadKeyring(*path, passphrase)
+	if errors.Is(err, fs.ErrNotExist) && action == "set" {
+		values, err = map[string]string{}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "list":
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+	case "set":
+		value, err := promptPassword("Value for " + name + ": ")
+		if err != nil {
+			return err
+		}
+		if value == "" {
+			return errors.New("value must not be empty")
+		}
+		values[name] = value
+	case "delete":
+		if _, ok := values[name]; !ok {
+			return fmt.Errorf("no secret named %q", name)
+		}
+		delete(values, name)
+	}
+
+	return secrets.WriteKeyring(*path, passphrase, values)
+}
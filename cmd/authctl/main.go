@@ -0,0 +1,139 @@
+// Command authctl manages the authentication database, in the style of
+// Django's manage.py. It connects with the same DB_* environment variables
+// as the server (see db.InitDB).
+//
+// Usage:
+//
+//	authctl migrate
+//	authctl createsuperuser [-username name] [-email address] [-password password]
+//	authctl changepassword -username name
+//	authctl users [-offset n] [-limit n]
+//	authctl deactivate -username name
+//	authctl otp -username name [-purpose purpose] [-length n] [-validity minutes]
+//	authctl mint-token -username name [-duration d]
+//	authctl verify-token token
+//	authctl create-client -name name
+//	authctl keyring [-file path] list | set name | delete name
+//
+// mint-token and verify-token sign and check tokens with the JWT_SECRET
+// environment variable, or the file named by JWT_SECRET_FILE, which must
+// match the server's secret. create-client registers an OAuth client for
+// the introspection and revocation endpoints and prints its credentials;
+// the secret cannot be shown again. keyring edits the encrypted keyring the server
+// reads secrets from (see the secrets package).
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/db"
+	"github.com/rb4807/Golang-Utlis/logging"
+	"github.com/rb4807/Golang-Utlis/secrets"
+)
+
+// command is an authctl subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"migrate", "create or update the authentication tables", runMigrate},
+	{"createsuperuser", "create a superuser, prompting for missing details", runCreateSuperuser},
+	{"changepassword", "set a user's password", runChangePassword},
+	{"users", "list users", runUsers},
+	{"deactivate", "deactivate a user", runDeactivate},
+	{"otp", "generate a one-time password for a user", runOTP},
+	{"mint-token", "issue a JWT for a user, for debugging", runMintToken},
+	{"verify-token", "verify a JWT and print its claims", runVerifyToken},
+	{"create-client", "register an OAuth client for token introspection and revocation", runCreateClient},
+	{"keyring", "list or edit the secrets in an encrypted keyring", runKeyring},
+}
+
+func main() {
+	// The database package logs at info level; only warnings concern the CLI
+	slog.SetDefault(logging.New(logging.Options{
+		Level:  slog.LevelWarn,
+		Format: logging.FormatText,
+	}))
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := cmd.run(context.Background(), os.Args[2:]); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				os.Exit(2)
+			}
+			fmt.Fprintf(os.Stderr, "authctl %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "authctl: unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: authctl <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, `Run "authctl <command> -h" for a command's flags.`)
+}
+
+// newService connects to the database and creates an auth service. Commands
+// that sign or verify tokens need the server's JWT_SECRET; the others run
+// with a random secret, as they never see a token.
+func newService(needSecret bool, tokenDuration time.Duration) (*auth.Service, error) {
+	secret, err := secrets.Env{}.Secret(context.Background(), auth.DefaultJWTSecretName)
+	if err != nil && !errors.Is(err, secrets.ErrNotFound) {
+		return nil, err
+	}
+	if secret == "" {
+		if needSecret {
+			return nil, errors.New("JWT_SECRET or JWT_SECRET_FILE must be set to the server's JWT secret")
+		}
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(b)
+	}
+
+	if tokenDuration <= 0 {
+		tokenDuration = 24 * time.Hour
+	}
+
+	database, err := db.InitDB()
+	if err != nil {
+		return nil, err
+	}
+	return auth.NewService(auth.Config{
+		JWTSecret:     secret,
+		TokenDuration: tokenDuration,
+		DBConnection:  database,
+		Logger:        slog.Default(),
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// stdin is shared by the prompts so buffered input is not lost between them
+var stdin = bufio.NewReader(os.Stdin)
+
+// prompt asks for a line of input
+func prompt(label string) (string, error) {
+	fmt.Fprint(os.Stderr, label)
+	line, err := stdin.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// promptPassword asks for a password without echoing it when stdin is a
+// terminal; otherwise it reads a line, so passwords can be piped in
+func promptPassword(label string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return prompt(label)
+	}
+
+	fmt.Fprint(os.Stderr, label)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(password), nil
+}
+
+// promptNewPassword asks for a password twice. Piped input is read once.
+func promptNewPassword() (string, error) {
+	password, err := promptPassword("Password: ")
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", errors.New("password must not be empty")
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return password, nil
+	}
+
+	confirm, err := promptPassword("Password (again): ")
+	if err != nil {
+		return "", err
+	}
+	if confirm != password {
+		return "", errors.New("passwords do not match")
+	}
+	return password, nil
+}
@@ -0,0 +1,311 @@
+// Package config loads the server configuration into a single struct. Values
+// come from defaults, then a YAML, TOML or JSON file, then environment
+// variables, then command-line flags, each overriding the last:
+//
+//	fs := flag.NewFlagSet("server", flag.ExitOnError)
+//	cfg, err := config.Load(fs, os.Args[1:])
+//
+// Every field has a flag named after its path in the file, such as
+// -database.host, and most have an environment variable, such as DB_HOST.
+// Load validates the result, so a misconfigured server fails at startup.
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/db"
+	"github.com/rb4807/Golang-Utlis/health"
+	"github.com/rb4807/Golang-Utlis/secrets"
+	"github.com/rb4807/Golang-Utlis/server"
+)
+
+// Config is the server configuration. Fields tagged secret are redacted by
+// Dump.
+type Config struct {
+	Server   Server   `json:"server"`
+	Database Database `json:"database"`
+	Token    Token    `json:"token"`
+	Password Password `json:"password"`
+	Logging  Logging  `json:"logging"`
+	Tracing  Tracing  `json:"tracing"`
+	Features Features `json:"features"`
+	Secrets  Secrets  `json:"secrets"`
+	Health   Health   `json:"health"`
+	Webhooks Webhooks `json:"webhooks"`
+}
+
+// Server configures the HTTP listener (see the server package)
+type Server struct {
+	Addr              string   `json:"addr" env:"SERVER_ADDR"`                  // Default: ":8080"
+	ReadHeaderTimeout Duration `json:"read_header_timeout"`                     // Default: 10s
+	ReadTimeout       Duration `json:"read_timeout"`                            // Default: 30s
+	WriteTimeout      Duration `json:"write_timeout"`                           // Default: 30s
+	IdleTimeout       Duration `json:"idle_timeout"`                            // Default: 2m
+	ShutdownTimeout   Duration `json:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"` // Default: 30s
+	TLSCert           string   `json:"tls_cert" env:"TLS_CERT_FILE"`            // Optional: serve HTTPS with this certificate chain
+	TLSKey            string   `json:"tls_key" env:"TLS_KEY_FILE"`              // Optional: private key for tls_cert
+}
+
+// Config returns the configuration for server.New
+func (s Server) Config() server.Config {
+	config := server.Config{
+		Addr:              s.Addr,
+		ReadHeaderTimeout: s.ReadHeaderTimeout.Std(),
+		ReadTimeout:       s.ReadTimeout.Std(),
+		WriteTimeout:      s.WriteTimeout.Std(),
+		IdleTimeout:       s.IdleTimeout.Std(),
+		ShutdownTimeout:   s.ShutdownTimeout.Std(),
+	}
+	if s.TLSCert != "" {
+		config.TLS = &server.TLSConfig{CertFile: s.TLSCert, KeyFile: s.TLSKey}
+	}
+	return config
+}
+
+// Database configures the PostgreSQL connection
+type Database struct {
+	Host     string `json:"host" env:"DB_HOST"` // Default: "localhost"
+	Port     int    `json:"port" env:"DB_PORT"` // Default: 5432
+	User     string `json:"user" env:"DB_USER"`
+	Password string `json:"password" env:"DB_PASSWORD" secret:"true"`
+	Name     string `json:"name" env:"DB_NAME"`
+	SSLMode  string `json:"sslmode" env:"DB_SSLMODE"` // Default: "disable"
+
+	Replicas        []string `json:"replicas" env:"DB_REPLICAS"`                     // Optional: read replica hosts, as host or host:port, comma-separated in DB_REPLICAS
+	MaxOpenConns    int      `json:"max_open_conns" env:"DB_MAX_OPEN_CONNS"`         // Default: 25
+	MaxIdleConns    int      `json:"max_idle_conns" env:"DB_MAX_IDLE_CONNS"`         // Default: 25
+	ConnMaxLifetime Duration `json:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"`   // Default: 30m
+	ConnMaxIdleTime Duration `json:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME"` // Default: 5m
+	ConnectAttempts int      `json:"connect_attempts" env:"DB_CONNECT_ATTEMPTS"`     // Attempts to reach the database at startup. Default: 5
+}
+
+// DSN returns the connection string for lib/pq
+func (d Database) DSN() string {
+	return d.dsn(net.JoinHostPort(d.Host, strconv.Itoa(d.Port)))
+}
+
+// ReplicaDSNs returns the connection strings for the read replicas, which
+// share the primary's credentials and database name
+func (d Database) ReplicaDSNs() []string {
+	dsns := make([]string, len(d.Replicas))
+	for i, host := range d.Replicas {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, strconv.Itoa(d.Port))
+		}
+		dsns[i] = d.dsn(host)
+	}
+	return dsns
+}
+
+// dsn returns the connection string for the database on host:port
+func (d Database) dsn(hostport string) string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(d.User, d.Password),
+		Host:     hostport,
+		Path:     "/" + d.Name,
+		RawQuery: url.Values{"sslmode": {d.SSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+// Options returns the pool and retry settings for db.Connect
+func (d Database) Options() db.Options {
+	return db.Options{
+		Pool: db.PoolConfig{
+			MaxOpenConns:    d.MaxOpenConns,
+			MaxIdleConns:    d.MaxIdleConns,
+			ConnMaxLifetime: d.ConnMaxLifetime.Std(),
+			ConnMaxIdleTime: d.ConnMaxIdleTime.Std(),
+		},
+		Retry: db.RetryConfig{
+			Attempts: d.ConnectAttempts,
+		},
+	}
+}
+
+// Token configures the JWTs issued at login
+type Token struct {
+	Secret   string   `json:"secret" env:"JWT_SECRET" secret:"true"` // At least 32 characters
+	Duration Duration `json:"duration" env:"TOKEN_DURATION"`         // Default: 24h
+}
+
+// Password configures the policy for new passwords
+type Password struct {
+	MinLength     int  `json:"min_length" env:"PASSWORD_MIN_LENGTH"` // Default: 8
+	RequireUpper  bool `json:"require_upper" env:"PASSWORD_REQUIRE_UPPER"`
+	RequireLower  bool `json:"require_lower" env:"PASSWORD_REQUIRE_LOWER"`
+	RequireDigit  bool `json:"require_digit" env:"PASSWORD_REQUIRE_DIGIT"`
+	RequireSymbol bool `json:"require_symbol" env:"PASSWORD_REQUIRE_SYMBOL"`
+}
+
+// Policy returns the policy for auth.Config
+func (p Password) Policy() *auth.PasswordPolicy {
+	return &auth.PasswordPolicy{
+		MinLength:     p.MinLength,
+		RequireUpper:  p.RequireUpper,
+		RequireLower:  p.RequireLower,
+		RequireDigit:  p.RequireDigit,
+		RequireSymbol: p.RequireSymbol,
+	}
+}
+
+// Logging configures the logger (see the logging package)
+type Logging struct {
+	Level  string `json:"level" env:"LOG_LEVEL"`   // Default: "info"
+	Format string `json:"format" env:"LOG_FORMAT"` // "json" or "text". Default: "json"
+}
+
+// Tracing configures span export (see the telemetry package)
+type Tracing struct {
+	Exporter    string  `json:"exporter" env:"OTEL_TRACES_EXPORTER"` // "none", "stdout" or "otlp". Default: "none"
+	Endpoint    string  `json:"endpoint"`                            // Optional: OTLP collector host:port
+	Insecure    bool    `json:"insecure"`                            // Optional: plain HTTP to the collector
+	SampleRatio float64 `json:"sample_ratio"`                        // Default: 1
+}
+
+// Features switches optional functionality on or off
+type Features struct {
+	Metrics            bool `json:"metrics" env:"FEATURE_METRICS"`                         // Serve Prometheus metrics. Default: true
+	Sessions           bool `json:"sessions" env:"FEATURE_SESSIONS"`                       // Enable cookie sessions. Default: false
+	PublicRegistration bool `json:"public_registration" env:"FEATURE_PUBLIC_REGISTRATION"` // Serve /register. Default: true
+	Webhooks           bool `json:"webhooks" env:"FEATURE_WEBHOOKS"`                       // Queue and deliver outbound webhooks. Default: false
+}
+
+// Secrets configures where secrets that are not set directly are read from
+// (see the secrets package). Environment variables, including the *_FILE
+// variants, are always consulted first.
+type Secrets struct {
+	Dir               string   `json:"dir" env:"SECRETS_DIR"`         // Optional: directory of mounted secret files, such as /run/secrets
+	Keyring           string   `json:"keyring" env:"SECRETS_KEYRING"` // Optional: encrypted keyring file
+	KeyringPassphrase string   `json:"keyring_passphrase" env:"SECRETS_KEYRING_PASSPHRASE" secret:"true"`
+	RefreshInterval   Duration `json:"refresh_interval" env:"SECRETS_REFRESH_INTERVAL"` // Default: 1m
+}
+
+// Provider returns a provider that reads the environment, then Dir, then
+// Keyring
+func (s Secrets) Provider() (secrets.Provider, error) {
+	providers := []secrets.Provider{secrets.Env{}}
+	if s.Dir != "" {
+		providers = append(providers, secrets.Dir{Path: s.Dir})
+	}
+	if s.Keyring != "" {
+		keyring, err := secrets.OpenKeyring(s.Keyring, s.KeyringPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, keyring)
+	}
+	return secrets.Chain(providers...), nil
+}
+
+// Health configures the readiness checks (see the health package)
+type Health struct {
+	CacheTTL Duration `json:"cache_ttl" env:"HEALTH_CACHE_TTL"` // How long results are reused. Default: 5s
+	Timeout  Duration `json:"timeout" env:"HEALTH_TIMEOUT"`     // Time limit for each check. Default: 2s
+}
+
+// Webhooks configures outbound webhook delivery when features.webhooks is
+// set. Zero values use the defaults of auth.WebhookConfig.
+type Webhooks struct {
+	MaxAttempts  int      `json:"max_attempts" env:"WEBHOOK_MAX_ATTEMPTS"`   // Default: 8
+	BaseDelay    Duration `json:"base_delay"`                                // Default: 30s
+	MaxDelay     Duration `json:"max_delay"`                                 // Default: 1h
+	Timeout      Duration `json:"timeout" env:"WEBHOOK_TIMEOUT"`             // Default: 10s
+	PollInterval Duration `json:"poll_interval" env:"WEBHOOK_POLL_INTERVAL"` // Default: 5s
+	BatchSize    int      `json:"batch_size"`                                // Default: 50
+}
+
+// Config returns the configuration for auth.Config
+func (w Webhooks) Config() *auth.WebhookConfig {
+	return &auth.WebhookConfig{
+		MaxAttempts:  w.MaxAttempts,
+		BaseDelay:    w.BaseDelay.Std(),
+		MaxDelay:     w.MaxDelay.Std(),
+		Timeout:      w.Timeout.Std(),
+		PollInterval: w.PollInterval.Std(),
+		BatchSize:    w.BatchSize,
+	}
+}
+
+// Default returns the configuration used for anything not set elsewhere
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr:              ":8080",
+			ReadHeaderTimeout: Duration(server.DefaultReadHeaderTimeout),
+			ReadTimeout:       Duration(server.DefaultReadTimeout),
+			WriteTimeout:      Duration(server.DefaultWriteTimeout),
+			IdleTimeout:       Duration(server.DefaultIdleTimeout),
+			ShutdownTimeout:   Duration(server.DefaultShutdownTimeout),
+		},
+		Database: Database{
+			Host:    "localhost",
+			Port:    5432,
+			SSLMode: "disable",
+
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: Duration(30 * time.Minute),
+			ConnMaxIdleTime: Duration(5 * time.Minute),
+			ConnectAttempts: db.DefaultConnectAttempts,
+		},
+		Token: Token{
+			Duration: Duration(24 * time.Hour),
+		},
+		Password: Password{
+			MinLength: 8,
+		},
+		Logging: Logging{
+			Level:  "info",
+			Format: "json",
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+		Features: Features{
+			Metrics:            true,
+			PublicRegistration: true,
+		},
+		Secrets: Secrets{
+			RefreshInterval: Duration(time.Minute),
+		},
+		Health: Health{
+			CacheTTL: Duration(health.DefaultCacheTTL),
+			Timeout:  Duration(health.DefaultTimeout),
+		},
+	}
+}
+
+// Duration is a time.Duration written as a string such as "24h" or "90m"
+type Duration time.Duration
+
+// Std returns d as a time.Duration
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", text)
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,225 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/rb4807/Golang-Utlis/logging"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the environment variable that can point at the
+// configuration file instead of the -config flag
+const FileEnv = "CONFIG_FILE"
+
+// Load builds the configuration from defaults, the file named by -config
+// (or CONFIG_FILE), environment variables and the flags in args, and
+// validates it. It registers its flags on fs, so callers can add their own
+// before calling Load. A .env file in the working directory, if present, is
+// loaded into the environment first.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	// Variables already set take precedence over the .env file
+	_ = godotenv.Load()
+
+	cfg := Default()
+	fields := cfg.fields()
+
+	path := fs.String("config", os.Getenv(FileEnv), "configuration file (.yaml, .yml, .toml or .json)")
+
+	// Flags are applied after the file and environment, so only their raw
+	// values are collected while parsing
+	type override struct{ name, value string }
+	var overrides []override
+	for _, f := range fields {
+		name := f.path
+		usage := "overrides " + name
+		if f.env != "" {
+			usage += " (env " + f.env + ")"
+		}
+		record := func(value string) error {
+			overrides = append(overrides, override{name, value})
+			return nil
+		}
+		if f.value.Kind() == reflect.Bool {
+			fs.BoolFunc(name, usage, record)
+		} else {
+			fs.Func(name, usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		if err := cfg.readFile(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range fields {
+		if f.env == "" {
+			continue
+		}
+		if value, ok := os.LookupEnv(f.env); ok && value != "" {
+			if err := f.set(value); err != nil {
+				return nil, fmt.Errorf("config: %s: %w", f.env, err)
+			}
+		}
+	}
+
+	byPath := make(map[string]field, len(fields))
+	for _, f := range fields {
+		byPath[f.path] = f
+	}
+	for _, o := range overrides {
+		if err := byPath[o.name].set(o.value); err != nil {
+			return nil, fmt.Errorf("config: -%s: %w", o.name, err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// readFile decodes a configuration file over cfg. YAML and TOML files are
+// converted to JSON first, so every format uses the json field names.
+// Unknown keys are rejected to catch typos.
+func (cfg *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	var doc map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	case ".json":
+		err = json.Unmarshal(data, &doc)
+	default:
+		return fmt.Errorf("config: unsupported file type %q", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+
+	normalized, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(normalized))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	return nil
+}
+
+// Dump writes the configuration as JSON with secrets redacted
+func (cfg *Config) Dump(w io.Writer) error {
+	redacted := *cfg
+	for _, f := range redacted.fields() {
+		if f.secret && f.value.String() != "" {
+			f.value.SetString(logging.Redacted)
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(redacted)
+}
+
+// field is a settable leaf of the configuration
+type field struct {
+	path   string // Dotted json names, such as "database.host"
+	env    string
+	secret bool
+	value  reflect.Value
+}
+
+// fields lists the leaves of cfg in declaration order
+func (cfg *Config) fields() []field {
+	var fields []field
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			name := strings.Split(sf.Tag.Get("json"), ",")[0]
+			path := prefix + name
+			if sf.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), path+".")
+				continue
+			}
+			fields = append(fields, field{
+				path:   path,
+				env:    sf.Tag.Get("env"),
+				secret: sf.Tag.Get("secret") == "true",
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "")
+	return fields
+}
+
+// set parses s into the field
+func (f field) set(s string) error {
+	if u, ok := f.value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	switch f.value.Kind() {
+	case reflect.String:
+		f.value.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		f.value.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		f.value.SetInt(int64(n))
+	case reflect.Float64:
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		f.value.SetFloat(x)
+	case reflect.Slice:
+		if f.value.Type().Elem().Kind() != reflect.String {
+			return errors.New("unsupported field type " + f.value.Type().String())
+		}
+		// Lists are comma-separated; an empty value clears the list
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(items))
+	default:
+		return errors.New("unsupported field type " + f.value.Type().String())
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/logging"
+	"github.com/rb4807/Golang-Utlis/secrets"
+	"github.com/rb4807/Golang-Utlis/telemetry"
+)
+
+// minSecretLength is the shortest JWT secret accepted, in bytes. HS256 keys
+// should carry at least 256 bits.
+const minSecretLength = 32
+
+// minSecretVariety is the fewest distinct bytes a secret may contain, which
+// rejects repetitive secrets such as "aaaa..." or "abcabc..."
+const minSecretVariety = 10
+
+// placeholderSecrets are example secrets that must never reach production
+var placeholderSecrets = []string{"your-secret-key", "secret", "changeme", "change-me", "jwt-secret"}
+
+// sslModes are the sslmode values lib/pq accepts
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// Validate checks the configuration and reports every problem found
+func (cfg *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, path, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	check(cfg.Server.Addr != "", "server.addr", "is required")
+	check(cfg.Server.ReadHeaderTimeout > 0, "server.read_header_timeout", "must be positive")
+	check(cfg.Server.ReadTimeout > 0, "server.read_timeout", "must be positive")
+	check(cfg.Server.WriteTimeout > 0, "server.write_timeout", "must be positive")
+	check(cfg.Server.IdleTimeout > 0, "server.idle_timeout", "must be positive")
+	check(cfg.Server.ShutdownTimeout > 0, "server.shutdown_timeout", "must be positive")
+	check((cfg.Server.TLSCert == "") == (cfg.Server.TLSKey == ""), "server.tls_key", "must be set together with server.tls_cert")
+
+	check(cfg.Database.Host != "", "database.host", "is required")
+	check(cfg.Database.Port > 0 && cfg.Database.Port < 65536, "database.port", "must be between 1 and 65535")
+	check(cfg.Database.User != "", "database.user", "is required")
+	check(cfg.Database.Name != "", "database.name", "is required")
+	check(slices.Contains(sslModes, cfg.Database.SSLMode), "database.sslmode", "must be one of %s", strings.Join(sslModes, ", "))
+	check(cfg.Database.MaxOpenConns >= 0, "database.max_open_conns", "must not be negative")
+	check(cfg.Database.MaxIdleConns >= 0, "database.max_idle_conns", "must not be negative")
+	check(cfg.Database.ConnMaxLifetime >= 0, "database.conn_max_lifetime", "must not be negative")
+	check(cfg.Database.ConnMaxIdleTime >= 0, "database.conn_max_idle_time", "must not be negative")
+	check(cfg.Database.ConnectAttempts > 0, "database.connect_attempts", "must be positive")
+	for _, host := range cfg.Database.Replicas {
+		check(!strings.ContainsAny(host, "/@?"), "database.replicas", "%q must be a host or host:port", host)
+	}
+
+	check(cfg.Secrets.Keyring == "" || cfg.Secrets.KeyringPassphrase != "", "secrets.keyring_passphrase", "is required with secrets.keyring")
+	check(cfg.Health.CacheTTL > 0, "health.cache_ttl", "must be positive")
+	check(cfg.Health.Timeout > 0, "health.timeout", "must be positive")
+
+	check(cfg.Secrets.RefreshInterval > 0, "secrets.refresh_interval", "must be positive")
+
+	check(cfg.Webhooks.MaxAttempts >= 0, "webhooks.max_attempts", "must not be negative")
+	check(cfg.Webhooks.BaseDelay >= 0, "webhooks.base_delay", "must not be negative")
+	check(cfg.Webhooks.MaxDelay >= 0, "webhooks.max_delay", "must not be negative")
+	check(cfg.Webhooks.Timeout >= 0, "webhooks.timeout", "must not be negative")
+	check(cfg.Webhooks.PollInterval >= 0, "webhooks.poll_interval", "must not be negative")
+	check(cfg.Webhooks.BatchSize >= 0, "webhooks.batch_size", "must not be negative")
+
+	// A secret not set directly is checked as the provider returns it now
+	secret := cfg.Token.Secret
+	if secret == "" {
+		var err error
+		secret, err = cfg.Secrets.lookup(auth.DefaultJWTSecretName)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("secrets: %w", err))
+		}
+	}
+	if err := checkSecret(secret); err != nil {
+		errs = append(errs, fmt.Errorf("token.secret: %w", err))
+	}
+	check(cfg.Token.Duration > 0, "token.duration", "must be positive")
+
+	check(cfg.Password.MinLength >= 8 && cfg.Password.MinLength <= 72, "password.min_length", "must be between 8 and 72")
+
+	_, err := logging.ParseLevel(cfg.Logging.Level)
+	check(err == nil, "logging.level", "must be debug, info, warn or error")
+	check(cfg.Logging.Format == logging.FormatJSON || cfg.Logging.Format == logging.FormatText,
+		"logging.format", "must be %s or %s", logging.FormatJSON, logging.FormatText)
+
+	exporters := []string{"", telemetry.ExporterNone, telemetry.ExporterStdout, telemetry.ExporterOTLP}
+	check(slices.Contains(exporters, cfg.Tracing.Exporter), "tracing.exporter", "must be none, stdout or otlp")
+	check(cfg.Tracing.SampleRatio >= 0 && cfg.Tracing.SampleRatio <= 1, "tracing.sample_ratio", "must be between 0 and 1")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("config: invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// lookup reads the named secret from the configured sources, returning ""
+// if none of them holds it
+func (s Secrets) lookup(name string) (string, error) {
+	if s.Keyring != "" && s.KeyringPassphrase == "" {
+		return "", nil
+	}
+	provider, err := s.Provider()
+	if err != nil {
+		return "", err
+	}
+	value, err := provider.Secret(context.Background(), name)
+	if errors.Is(err, secrets.ErrNotFound) {
+		return "", nil
+	}
+	return value, err
+}
+
+// checkSecret rejects JWT secrets that are missing, short, repetitive or
+// copied from an example
+func checkSecret(secret string) error {
+	if secret == "" {
+		return errors.New("is required (set JWT_SECRET or JWT_SECRET_FILE, or add jwt_secret to secrets.dir or secrets.keyring)")
+	}
+	for _, placeholder := range placeholderSecrets {
+		if strings.EqualFold(secret, placeholder) {
+			return errors.New("is an example value; generate a random secret")
+		}
+	}
+	if len(secret) < minSecretLength {
+		return fmt.Errorf("must be at least %d characters", minSecretLength)
+	}
+
+	distinct := make(map[byte]bool)
+	for i := 0; i < len(secret); i++ {
+		distinct[secret[i]] = true
+	}
+	if len(distinct) < minSecretVariety {
+		return errors.New("is too repetitive; generate a random secret")
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+)
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type CreateAPIKeyResponse struct {
+	auth.APIKey
+	Key string `json:"key"`
+}
+
+// Handlers
+
+// APIKeysHandler lists (GET) or creates (POST) the current user's API keys
+func APIKeysHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			http.Error(w, "User not found", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			keys, err := authService.ListAPIKeys(r.Context(), claims.UserID)
+			if err != nil {
+				http.Error(w, "Error retrieving API keys", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(keys)
+
+		case http.MethodPost:
+			var req CreateAPIKeyRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			if req.ExpiresAt != nil && req.ExpiresAt.Before(authService.Now()) {
+				http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+				return
+			}
+
+			apiKey, key, err := authService.CreateAPIKey(auth.RequestContext(r), claims.UserID, req.Name, req.Scopes, req.ExpiresAt)
+			if err != nil {
+				if errors.Is(err, auth.ErrAPIKeyNameRequired) || errors.Is(err, auth.ErrInvalidScope) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				if errors.Is(err, auth.ErrAPIKeyManagement) {
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				}
+				http.Error(w, "Error creating API key", http.StatusInternalServerError)
+				return
+			}
+
+			// The key is only ever shown in this response
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(CreateAPIKeyResponse{APIKey: *apiKey, Key: key})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// RevokeAPIKeyHandler revokes one of the current user's API keys
+func RevokeAPIKeyHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			http.Error(w, "User not found", http.StatusUnauthorized)
+			return
+		}
+
+		keyID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := authService.RevokeAPIKey(auth.RequestContext(r), claims.UserID, keyID); err != nil {
+			if errors.Is(err, auth.ErrAPIKeyNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, auth.ErrAPIKeyManagement) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			http.Error(w, "Error revoking API key", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
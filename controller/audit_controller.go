@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+)
+
+// Handlers
+
+// AuditEventsHandler lists audit events, filtered by the user_id, type,
+// from and to (RFC 3339) and limit query parameters
+func AuditEventsHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		params := r.URL.Query()
+		query := auth.AuditQuery{Type: params.Get("type")}
+
+		var err error
+		if v := params.Get("user_id"); v != "" {
+			if query.UserID, err = strconv.ParseInt(v, 10, 64); err != nil {
+				http.Error(w, "Invalid user_id", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := params.Get("from"); v != "" {
+			if query.From, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, "Invalid from, expected RFC 3339", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := params.Get("to"); v != "" {
+			if query.To, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, "Invalid to, expected RFC 3339", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := params.Get("limit"); v != "" {
+			if query.Limit, err = strconv.Atoi(v); err != nil {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		events, err := authService.ListAuditEvents(r.Context(), query)
+		if err != nil {
+			http.Error(w, "Error retrieving audit events", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(events)
+	}
+}
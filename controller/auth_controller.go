@@ -1,16 +1,19 @@
 package controller
 
 import (
-	"fmt"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
+
+	"github.com/dgrijalva/jwt-go"
 	"github.com/rb4807/Golang-Utlis/auth"
 )
 
 type LoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username       string `json:"username"`
+	Password       string `json:"password"`
+	OrganizationID int64  `json:"organization_id,omitempty"` // Optional: organization to select in the token or session
 }
 
 type RegisterRequest struct {
@@ -27,6 +30,21 @@ type TokenResponse struct {
 	UserID    int64     `json:"user_id"`
 }
 
+// newTokenResponse reports the expiry written into a token the service has
+// just issued, so it follows the configured token duration
+func newTokenResponse(token string, userID int64) (TokenResponse, error) {
+	var claims auth.TokenClaims
+	// The service signed the token moments ago, so it needs no verifying
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, &claims); err != nil {
+		return TokenResponse{}, err
+	}
+	return TokenResponse{
+		Token:     token,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+		UserID:    userID,
+	}, nil
+}
+
 // Handlers
 
 func RegisterHandler(authService *auth.Service) http.HandlerFunc {
@@ -50,7 +68,7 @@ func RegisterHandler(authService *auth.Service) http.HandlerFunc {
 			IsActive:  true,
 		}
 
-		userID, err := authService.Register(user, req.Password)
+		userID, err := authService.RegisterContext(auth.RequestContext(r), user, req.Password)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -77,18 +95,30 @@ func LoginHandler(authService *auth.Service) http.HandlerFunc {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
-		user, token, err := authService.Login(req.Username, req.Password)
+		var user *auth.User
+		var token string
+		var err error
+		if req.OrganizationID != 0 {
+			user, token, err = authService.LoginToOrganization(auth.RequestContext(r), req.Username, req.Password, req.OrganizationID)
+		} else {
+			user, token, err = authService.LoginContext(auth.RequestContext(r), req.Username, req.Password)
+		}
+		if errors.Is(err, auth.ErrNotOrganizationMember) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 		if err != nil {
-			fmt.Println("Error",err)
+			if !errors.Is(err, auth.ErrInvalidCredentials) {
+				authService.Logger().ErrorContext(r.Context(), "login failed", "username", req.Username, "error", err)
+			}
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			return
 		}
 
-		expiresAt := time.Now().Add(24 * time.Hour)
-		response := TokenResponse{
-			Token:     token,
-			ExpiresAt: expiresAt,
-			UserID:    user.ID,
+		response, err := newTokenResponse(token, user.ID)
+		if err != nil {
+			http.Error(w, "Error issuing token", http.StatusInternalServerError)
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -104,7 +134,11 @@ func ProfileHandler(authService *auth.Service) http.HandlerFunc {
 			return
 		}
 
-		user, err := authService.GetUserByID(claims.UserID)
+		user, err := authService.GetUserByIDContext(r.Context(), claims.UserID)
+		if errors.Is(err, auth.ErrUserNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Error retrieving user", http.StatusInternalServerError)
 			return
@@ -0,0 +1,30 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rb4807/Golang-Utlis/auth"
+)
+
+func TestNewTokenResponseUsesTokenExpiry(t *testing.T) {
+	expiresAt := time.Date(2026, 3, 1, 13, 30, 0, 0, time.UTC)
+	claims := auth.TokenClaims{UserID: 7, StandardClaims: jwt.StandardClaims{ExpiresAt: expiresAt.Unix()}}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := newTokenResponse(token, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.ExpiresAt.Equal(expiresAt) || response.Token != token || response.UserID != 7 {
+		t.Errorf("got %+v, want the token with expiry %v", response, expiresAt)
+	}
+
+	if _, err := newTokenResponse("not a token", 7); err == nil {
+		t.Error("no error for a malformed token")
+	}
+}
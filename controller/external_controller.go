@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+)
+
+// Handlers
+
+// ExternalLoginHandler redirects the user to the external provider's sign-in page
+func ExternalLoginHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		authURL, err := authService.BeginExternalLogin(auth.RequestContext(r), w, r.PathValue("provider"), 0)
+		if err != nil {
+			writeExternalError(w, err)
+			return
+		}
+
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// ExternalCallbackHandler completes an external login and issues a token
+func ExternalCallbackHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		if providerErr := query.Get("error"); providerErr != "" {
+			http.Error(w, "External login failed: "+providerErr, http.StatusUnauthorized)
+			return
+		}
+
+		user, token, err := authService.CompleteExternalLogin(w, r, r.PathValue("provider"), query.Get("state"), query.Get("code"))
+		if err != nil {
+			writeExternalError(w, err)
+			return
+		}
+
+		response, err := newTokenResponse(token, user.ID)
+		if err != nil {
+			http.Error(w, "Error issuing token", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// ExternalLinkHandler starts linking an external account to the current user.
+// It returns the provider URL rather than redirecting, since the request is
+// made by an API client holding a bearer token. The response sets the cookie
+// the callback requires, so the request must come from the browser that
+// then visits the URL, e.g. a fetch with credentials included.
+func ExternalLinkHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			http.Error(w, "User not found", http.StatusUnauthorized)
+			return
+		}
+
+		authURL, err := authService.BeginExternalLogin(auth.RequestContext(r), w, r.PathValue("provider"), claims.UserID)
+		if err != nil {
+			writeExternalError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"authorization_url": authURL,
+		})
+	}
+}
+
+// ExternalIdentitiesHandler lists the external accounts linked to the current user
+func ExternalIdentitiesHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			http.Error(w, "User not found", http.StatusUnauthorized)
+			return
+		}
+
+		identities, err := authService.ListExternalIdentitiesContext(r.Context(), claims.UserID)
+		if err != nil {
+			http.Error(w, "Error retrieving external accounts", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(identities)
+	}
+}
+
+// writeExternalError maps external login errors to HTTP responses
+func writeExternalError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrUnknownProvider):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, auth.ErrInvalidOAuthState):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, auth.ErrIdentityLinked), errors.Is(err, auth.ErrExternalEmailInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+	case errors.Is(err, auth.ErrRegistrationDisabled):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "External login failed", http.StatusBadGateway)
+	}
+}
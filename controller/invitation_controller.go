@@ -0,0 +1,277 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+)
+
+type CreateInvitationRequest struct {
+	Email          string `json:"email"`
+	OrganizationID int64  `json:"organization_id"` // Ignored on /org routes, which use the token's organization
+	Role           string `json:"role"`
+}
+
+// CreateInvitationResponse includes the invitation token only when no
+// notifier delivers it, so the inviter can pass it on
+type CreateInvitationResponse struct {
+	auth.Invitation
+	Token string `json:"token,omitempty"`
+}
+
+type AcceptInvitationRequest struct {
+	Token     string `json:"token"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+// Handlers
+
+// InvitationsHandler lists (GET) pending invitations, optionally filtered
+// by the organization_id query parameter, or creates (POST) an invitation
+// to any organization or none
+func InvitationsHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			var orgID int64
+			if v := r.URL.Query().Get("organization_id"); v != "" {
+				parsed, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					http.Error(w, "Invalid organization_id", http.StatusBadRequest)
+					return
+				}
+				orgID = parsed
+			}
+			listInvitations(w, r, authService, orgID)
+
+		case http.MethodPost:
+			var req CreateInvitationRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			createInvitation(w, r, authService, req)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// OrganizationInvitationsHandler lists (GET) pending invitations to the
+// organization selected in the token, or creates (POST) one. Invitations
+// cannot grant a role above the inviter's.
+func OrganizationInvitationsHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			http.Error(w, "User not found", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			listInvitations(w, r, authService, claims.OrgID)
+
+		case http.MethodPost:
+			var req CreateInvitationRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			if req.Role == "" {
+				req.Role = auth.RoleMember
+			}
+			if !canManageRole(claims, req.Role) {
+				http.Error(w, "Insufficient organization role", http.StatusForbidden)
+				return
+			}
+			req.OrganizationID = claims.OrgID
+			createInvitation(w, r, authService, req)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// RevokeInvitationHandler revokes any pending invitation
+func RevokeInvitationHandler(authService *auth.Service) http.HandlerFunc {
+	return revokeInvitationHandler(authService, anyOrganization)
+}
+
+// OrganizationRevokeInvitationHandler revokes a pending invitation to the
+// organization selected in the token
+func OrganizationRevokeInvitationHandler(authService *auth.Service) http.HandlerFunc {
+	return revokeInvitationHandler(authService, selectedOrganization)
+}
+
+// ResendInvitationHandler resends any pending invitation
+func ResendInvitationHandler(authService *auth.Service) http.HandlerFunc {
+	return resendInvitationHandler(authService, anyOrganization)
+}
+
+// OrganizationResendInvitationHandler resends a pending invitation to the
+// organization selected in the token
+func OrganizationResendInvitationHandler(authService *auth.Service) http.HandlerFunc {
+	return resendInvitationHandler(authService, selectedOrganization)
+}
+
+// AcceptInvitationHandler creates an account from an invitation token
+func AcceptInvitationHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req AcceptInvitationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		user := auth.User{
+			Username:  req.Username,
+			FirstName: req.FirstName,
+			LastName:  req.LastName,
+		}
+
+		userID, err := authService.AcceptInvitation(auth.RequestContext(r), req.Token, user, req.Password)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"user_id":  userID,
+			"username": req.Username,
+			"message":  "User registered successfully",
+		})
+	}
+}
+
+// anyOrganization scopes invitation changes to every invitation
+func anyOrganization(*auth.TokenClaims) int64 {
+	return 0
+}
+
+// selectedOrganization scopes invitation changes to the token's organization
+func selectedOrganization(claims *auth.TokenClaims) int64 {
+	return claims.OrgID
+}
+
+func revokeInvitationHandler(authService *auth.Service, scope func(*auth.TokenClaims) int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, invitationID, ok := invitationRequest(w, r)
+		if !ok {
+			return
+		}
+
+		if err := authService.RevokeInvitation(auth.RequestContext(r), scope(claims), invitationID); err != nil {
+			writeInvitationError(w, err, "Error revoking invitation")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func resendInvitationHandler(authService *auth.Service, scope func(*auth.TokenClaims) int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, invitationID, ok := invitationRequest(w, r)
+		if !ok {
+			return
+		}
+
+		token, err := authService.ResendInvitation(auth.RequestContext(r), scope(claims), invitationID)
+		if err != nil {
+			writeInvitationError(w, err, "Error resending invitation")
+			return
+		}
+
+		if authService.NotificationsEnabled() {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"token": token})
+	}
+}
+
+// invitationRequest reads the claims and the invitation ID path value,
+// writing an error response if either is missing
+func invitationRequest(w http.ResponseWriter, r *http.Request) (*auth.TokenClaims, int64, bool) {
+	claims, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return nil, 0, false
+	}
+
+	invitationID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return nil, 0, false
+	}
+
+	return claims, invitationID, true
+}
+
+func listInvitations(w http.ResponseWriter, r *http.Request, authService *auth.Service, orgID int64) {
+	invitations, err := authService.ListInvitations(r.Context(), orgID)
+	if err != nil {
+		http.Error(w, "Error retrieving invitations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+func createInvitation(w http.ResponseWriter, r *http.Request, authService *auth.Service, req CreateInvitationRequest) {
+	invitation, token, err := authService.CreateInvitation(auth.RequestContext(r), req.Email, req.OrganizationID, req.Role)
+	if err != nil {
+		writeInvitationError(w, err, "Error creating invitation")
+		return
+	}
+
+	response := CreateInvitationResponse{Invitation: *invitation}
+	if !authService.NotificationsEnabled() {
+		response.Token = token
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeInvitationError maps invitation errors to responses
+func writeInvitationError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidEmail), errors.Is(err, auth.ErrInvalidRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, auth.ErrInvitationNotFound), errors.Is(err, auth.ErrOrganizationNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, auth.ErrEmailInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, message, http.StatusInternalServerError)
+	}
+}
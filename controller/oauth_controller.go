@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+)
+
+// Handlers
+
+// IntrospectHandler implements the token introspection endpoint (RFC 7662)
+func IntrospectHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !authenticateClient(authService, w, r) {
+			return
+		}
+
+		token := r.PostForm.Get("token")
+		if token == "" {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		response, err := authService.IntrospectTokenContext(r.Context(), token, r.PostForm.Get("token_type_hint"))
+		if err != nil {
+			http.Error(w, "Error introspecting token", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// RevokeHandler implements the token revocation endpoint (RFC 7009)
+func RevokeHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !authenticateClient(authService, w, r) {
+			return
+		}
+
+		token := r.PostForm.Get("token")
+		if token == "" {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		err := authService.RevokeToken(auth.RequestContext(r), token, r.PostForm.Get("token_type_hint"))
+		if errors.Is(err, auth.ErrUnsupportedTokenType) {
+			writeOAuthError(w, http.StatusBadRequest, "unsupported_token_type")
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error revoking token", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// authenticateClient parses the form and checks the client credentials, sent
+// either with HTTP Basic authentication or as client_id/client_secret fields.
+// It writes the error response and returns false if authentication fails.
+func authenticateClient(authService *auth.Service, w http.ResponseWriter, r *http.Request) bool {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request")
+		return false
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	if _, err := authService.AuthenticateClientContext(r.Context(), clientID, secret); err != nil {
+		if err != auth.ErrInvalidClient {
+			http.Error(w, "Error authenticating client", http.StatusInternalServerError)
+			return false
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client")
+		return false
+	}
+
+	return true
+}
+
+// writeOAuthError writes an OAuth 2.0 error response (RFC 6749 section 5.2)
+func writeOAuthError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
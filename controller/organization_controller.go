@@ -0,0 +1,308 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+)
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type SwitchOrganizationRequest struct {
+	OrganizationID int64 `json:"organization_id"` // 0 selects no organization
+}
+
+type SwitchOrganizationResponse struct {
+	Token          string `json:"token,omitempty"` // Empty when a cookie session was switched
+	OrganizationID int64  `json:"organization_id,omitempty"`
+}
+
+type AddMemberRequest struct {
+	UserID int64  `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+type UpdateMemberRequest struct {
+	Role string `json:"role"`
+}
+
+// Handlers
+
+// OrganizationsHandler lists (GET) the current user's organizations, or
+// creates (POST) an organization owned by the current user
+func OrganizationsHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			http.Error(w, "User not found", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			orgs, err := authService.ListUserOrganizations(r.Context(), claims.UserID)
+			if err != nil {
+				http.Error(w, "Error retrieving organizations", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(orgs)
+
+		case http.MethodPost:
+			var req CreateOrganizationRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+
+			org, err := authService.CreateOrganization(auth.RequestContext(r), req.Name, req.Slug, claims.UserID)
+			if err != nil {
+				if errors.Is(err, auth.ErrInvalidOrganization) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				http.Error(w, "Error creating organization", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(org)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// SwitchOrganizationHandler issues a token for the current login with
+// another organization selected. A cookie session is switched in place
+// instead, and no token is returned.
+func SwitchOrganizationHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			http.Error(w, "User not found", http.StatusUnauthorized)
+			return
+		}
+
+		var req SwitchOrganizationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		// AuthMiddleware authenticates requests without an Authorization
+		// header by their session cookie
+		if r.Header.Get("Authorization") == "" && authService.SessionsEnabled() {
+			err := authService.SwitchSessionOrganization(w, r.WithContext(auth.RequestContext(r)), req.OrganizationID)
+			if err != nil {
+				if errors.Is(err, auth.ErrNotOrganizationMember) {
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				}
+				http.Error(w, "Error switching organization", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(SwitchOrganizationResponse{OrganizationID: req.OrganizationID})
+			return
+		}
+
+		token, err := authService.SwitchOrganization(auth.RequestContext(r), claims, req.OrganizationID)
+		if err != nil {
+			switch {
+			case errors.Is(err, auth.ErrNotOrganizationMember):
+				http.Error(w, err.Error(), http.StatusForbidden)
+			case errors.Is(err, auth.ErrInvalidToken):
+				http.Error(w, "Only tokens issued at login can switch organization", http.StatusBadRequest)
+			default:
+				http.Error(w, "Error switching organization", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SwitchOrganizationResponse{Token: token, OrganizationID: req.OrganizationID})
+	}
+}
+
+// OrganizationMembersHandler lists (GET) the members of the organization
+// selected in the token, or adds (POST) a member. Adding members requires
+// the admin role, and only owners can add owners.
+func OrganizationMembersHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			http.Error(w, "User not found", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			members, err := authService.ListMembers(r.Context(), claims.OrgID)
+			if err != nil {
+				http.Error(w, "Error retrieving members", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(members)
+
+		case http.MethodPost:
+			var req AddMemberRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			if !canManageRole(claims, req.Role) {
+				http.Error(w, "Insufficient organization role", http.StatusForbidden)
+				return
+			}
+
+			membership, err := authService.AddMember(auth.RequestContext(r), claims.OrgID, req.UserID, req.Role)
+			if err != nil {
+				writeMembershipError(w, err, "Error adding member")
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(membership)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// OrganizationMemberHandler returns (GET) a member of the organization
+// selected in the token, changes their role (PATCH), or removes them
+// (DELETE). Changes require the admin role, and only owners can change
+// owners; any member may remove themselves.
+func OrganizationMemberHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			http.Error(w, "User not found", http.StatusUnauthorized)
+			return
+		}
+
+		userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			user, err := authService.GetOrganizationUser(r.Context(), claims.OrgID, userID)
+			if err != nil {
+				if errors.Is(err, auth.ErrUserNotFound) {
+					http.Error(w, err.Error(), http.StatusNotFound)
+					return
+				}
+				http.Error(w, "Error retrieving user", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(user)
+
+		case http.MethodPatch:
+			var req UpdateMemberRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			allowed, err := canManageMember(r, authService, claims, userID)
+			if err != nil {
+				http.Error(w, "Error updating member", http.StatusInternalServerError)
+				return
+			}
+			if !allowed || !canManageRole(claims, req.Role) {
+				http.Error(w, "Insufficient organization role", http.StatusForbidden)
+				return
+			}
+
+			if err := authService.UpdateMemberRole(auth.RequestContext(r), claims.OrgID, userID, req.Role); err != nil {
+				writeMembershipError(w, err, "Error updating member")
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+
+		case http.MethodDelete:
+			if userID != claims.UserID {
+				allowed, err := canManageMember(r, authService, claims, userID)
+				if err != nil {
+					http.Error(w, "Error removing member", http.StatusInternalServerError)
+					return
+				}
+				if !allowed {
+					http.Error(w, "Insufficient organization role", http.StatusForbidden)
+					return
+				}
+			}
+
+			if err := authService.RemoveMember(auth.RequestContext(r), claims.OrgID, userID); err != nil {
+				writeMembershipError(w, err, "Error removing member")
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// canManageRole reports whether the caller may grant role: admins grant
+// admin and member, owners grant any role
+func canManageRole(claims *auth.TokenClaims, role string) bool {
+	return auth.RoleAtLeast(claims.OrgRole, auth.RoleAdmin) && auth.RoleAtLeast(claims.OrgRole, role)
+}
+
+// canManageMember reports whether the caller may change userID's
+// membership; only owners can change owners. Unknown members pass, so the
+// service reports them as not found.
+func canManageMember(r *http.Request, authService *auth.Service, claims *auth.TokenClaims, userID int64) (bool, error) {
+	if !auth.RoleAtLeast(claims.OrgRole, auth.RoleAdmin) {
+		return false, nil
+	}
+	membership, err := authService.GetMembership(r.Context(), claims.OrgID, userID)
+	if err != nil {
+		if errors.Is(err, auth.ErrNotOrganizationMember) {
+			return true, nil
+		}
+		return false, err
+	}
+	return auth.RoleAtLeast(claims.OrgRole, membership.Role), nil
+}
+
+// writeMembershipError maps membership errors to responses
+func writeMembershipError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidRole), errors.Is(err, auth.ErrLastOwner):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, auth.ErrUserNotFound), errors.Is(err, auth.ErrNotOrganizationMember):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, auth.ErrAlreadyMember):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, message, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,208 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+)
+
+// Handlers
+
+// SessionLoginHandler authenticates a user and starts a cookie session
+func SessionLoginHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req LoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		var user *auth.User
+		var membership *auth.Membership
+		var err error
+		if req.OrganizationID != 0 {
+			user, membership, err = authService.AuthenticateToOrganization(auth.RequestContext(r), req.Username, req.Password, req.OrganizationID)
+		} else {
+			user, err = authService.AuthenticateContext(auth.RequestContext(r), req.Username, req.Password)
+		}
+		if errors.Is(err, auth.ErrNotOrganizationMember) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			return
+		}
+
+		if err := authService.CreateOrganizationSession(w, r, user, membership); err != nil {
+			http.Error(w, "Error creating session", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"user_id":  user.ID,
+			"username": user.Username,
+			"message":  "Logged in successfully",
+		})
+	}
+}
+
+// SessionLogoutHandler ends the current cookie session
+func SessionLogoutHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := authService.DestroySession(w, r); err != nil {
+			http.Error(w, "Error ending session", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// CSRFTokenHandler returns the CSRF token for JSON clients
+func CSRFTokenHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"csrf_token": auth.CSRFTokenFromContext(r.Context()),
+	})
+}
+
+// SessionsHandler lists (GET) the current user's sessions, or signs out of
+// all other sessions (DELETE). Signing out requires a login token or cookie
+// session to keep; API keys and tokens without an ID are refused rather
+// than signing the user out everywhere.
+func SessionsHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			http.Error(w, "User not found", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeSessions(w, r, authService, claims.UserID)
+
+		case http.MethodDelete:
+			if claims.Id == "" || claims.APIKeyID != 0 {
+				http.Error(w, "Signing out other sessions requires a login session", http.StatusForbidden)
+				return
+			}
+			if err := authService.RevokeOtherSessions(auth.RequestContext(r), claims.UserID, claims.Id); err != nil {
+				if errors.Is(err, auth.ErrSessionNotFound) {
+					http.Error(w, "Current session not found", http.StatusForbidden)
+					return
+				}
+				http.Error(w, "Error revoking sessions", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// RevokeSessionHandler signs the current user out of one session
+func RevokeSessionHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			http.Error(w, "User not found", http.StatusUnauthorized)
+			return
+		}
+
+		revokeSession(w, r, authService, claims.UserID)
+	}
+}
+
+// AdminUserSessionsHandler lists (GET) or revokes all (DELETE) sessions of any user
+func AdminUserSessionsHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeSessions(w, r, authService, userID)
+
+		case http.MethodDelete:
+			if err := authService.RevokeOtherSessions(auth.RequestContext(r), userID, ""); err != nil {
+				http.Error(w, "Error revoking sessions", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// AdminRevokeSessionHandler signs any user out of one session
+func AdminRevokeSessionHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		revokeSession(w, r, authService, userID)
+	}
+}
+
+// writeSessions writes a user's sessions as JSON
+func writeSessions(w http.ResponseWriter, r *http.Request, authService *auth.Service, userID int64) {
+	sessions, err := authService.ListSessions(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Error retrieving sessions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// revokeSession revokes the session named in the path for a user
+func revokeSession(w http.ResponseWriter, r *http.Request, authService *auth.Service, userID int64) {
+	if err := authService.RevokeSession(auth.RequestContext(r), userID, r.PathValue("session")); err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error revoking session", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+)
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// Handlers
+
+// WebhooksHandler lists (GET) or creates (POST) webhook subscriptions
+func WebhooksHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			subscriptions, err := authService.ListWebhookSubscriptions(r.Context())
+			if err != nil {
+				http.Error(w, "Error retrieving webhooks", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(subscriptions)
+
+		case http.MethodPost:
+			var req CreateWebhookRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+
+			subscription, err := authService.CreateWebhookSubscription(r.Context(), req.URL, req.Events)
+			if err != nil {
+				if errors.Is(err, auth.ErrInvalidWebhookURL) || errors.Is(err, auth.ErrInvalidWebhookEvent) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				http.Error(w, "Error creating webhook", http.StatusInternalServerError)
+				return
+			}
+
+			// The signing secret is only ever shown in this response
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(subscription)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// DeleteWebhookHandler deactivates a webhook subscription
+func DeleteWebhookHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		subscriptionID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := authService.DeleteWebhookSubscription(r.Context(), subscriptionID); err != nil {
+			if errors.Is(err, auth.ErrWebhookNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Error deleting webhook", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DeadWebhookDeliveriesHandler lists deliveries that exhausted their retries,
+// up to the limit query parameter
+func DeadWebhookDeliveriesHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		limit := 0
+		if v := r.URL.Query().Get("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		deliveries, err := authService.ListDeadWebhookDeliveries(r.Context(), limit)
+		if err != nil {
+			http.Error(w, "Error retrieving webhook deliveries", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(deliveries)
+	}
+}
+
+// RetryWebhookDeliveryHandler requeues a dead webhook delivery
+func RetryWebhookDeliveryHandler(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		deliveryID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := authService.RetryWebhookDelivery(r.Context(), deliveryID); err != nil {
+			if errors.Is(err, auth.ErrWebhookNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Error retrying webhook delivery", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
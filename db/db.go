@@ -3,24 +3,38 @@ package db
 // POSTGRES CONNECTION
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/rb4807/Golang-Utlis/secrets"
 )
 
-func InitDB() *sql.DB {
+// Open connects to PostgreSQL with a connection string such as
+// config.Database.DSN returns, and checks the connection. Use Connect to
+// tune the pool or retry while the database starts.
+func Open(dsn string) (*sql.DB, error) {
+	return Connect(context.Background(), dsn, Options{Retry: RetryConfig{Attempts: 1}})
+}
+
+// InitDB connects with the DB_* environment variables, retrying while the
+// database is unreachable (see Connect). The password may also be mounted
+// as a file named by DB_PASSWORD_FILE, and is re-read for every new
+// connection (see OpenWithSecrets).
+func InitDB() (*sql.DB, error) {
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found (proceeding with system env variables)")
+		slog.Info("No .env file found (proceeding with system env variables)")
 	}
 
 	// Get environment variables
 	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
 	dbHost := os.Getenv("DB_HOST")
 	dbPort := os.Getenv("DB_PORT")
 	dbName := os.Getenv("DB_NAME")
@@ -30,22 +44,18 @@ func InitDB() *sql.DB {
 		sslMode = "disable"
 	}
 
-	// Build DSN string
-	dsn := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s",
-		dbUser, dbPassword, dbHost, dbPort, dbName, sslMode)
+	// Build DSN string; the password is added per connection
+	dsn := fmt.Sprintf("postgres://%s@%s/%s?sslmode=%s",
+		url.User(dbUser), net.JoinHostPort(dbHost, dbPort), dbName, sslMode)
 
 	// Connect to PostgreSQL
-	db, err := sql.Open("postgres", dsn)
+	db, err := Connect(context.Background(), dsn, Options{Secrets: secrets.Env{}})
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
-	}
-
-	if err := db.Ping(); err != nil {
-		log.Fatalf("Failed to ping database: %v", err)
+		return nil, fmt.Errorf("connecting to %s on %s: %w", dbName, dbHost, err)
 	}
 
-	fmt.Println("Database connected successfully")
-	return db
+	slog.Info("Database connected successfully", "host", dbHost, "database", dbName)
+	return db, nil
 }
 
 // MYSQL CONNECTION
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rb4807/Golang-Utlis/secrets"
+)
+
+// Defaults for zero RetryConfig fields
+const (
+	DefaultConnectAttempts = 5
+	DefaultInitialBackoff  = time.Second
+	DefaultMaxBackoff      = 15 * time.Second
+)
+
+// Options configures Connect
+type Options struct {
+	Pool    PoolConfig
+	Retry   RetryConfig
+	Secrets secrets.Provider // Optional: supplies the password for each new connection (see OpenWithSecrets)
+	Logger  *slog.Logger     // Optional: logs connection retries. Defaults to slog.Default()
+}
+
+// PoolConfig tunes the connection pool. Zero fields keep the database/sql
+// defaults.
+type PoolConfig struct {
+	MaxOpenConns    int           // Optional: most connections open at once. Default: unlimited
+	MaxIdleConns    int           // Optional: most idle connections kept. Default: 2
+	ConnMaxLifetime time.Duration // Optional: age after which connections are replaced. Default: unlimited
+	ConnMaxIdleTime time.Duration // Optional: idle time after which connections are closed. Default: unlimited
+}
+
+// apply configures db's pool
+func (p PoolConfig) apply(db *sql.DB) {
+	if p.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(p.MaxOpenConns)
+	}
+	if p.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(p.MaxIdleConns)
+	}
+	if p.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(p.ConnMaxLifetime)
+	}
+	if p.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(p.ConnMaxIdleTime)
+	}
+}
+
+// RetryConfig controls how Connect waits for the database at startup, for
+// example while it starts alongside the service
+type RetryConfig struct {
+	Attempts       int           // Optional: connection attempts before giving up. Default: 5
+	InitialBackoff time.Duration // Optional: wait after the first failure, doubled after each. Default: 1s
+	MaxBackoff     time.Duration // Optional: longest wait between attempts. Default: 15s
+}
+
+// Connect opens a pool for dsn and checks the connection, retrying with
+// exponential backoff while the database is unreachable. Errors that
+// retrying cannot fix, such as a wrong password or a missing database, are
+// returned at once.
+func Connect(ctx context.Context, dsn string, opts Options) (*sql.DB, error) {
+	retry := opts.Retry
+	if retry.Attempts == 0 {
+		retry.Attempts = DefaultConnectAttempts
+	}
+	if retry.InitialBackoff == 0 {
+		retry.InitialBackoff = DefaultInitialBackoff
+	}
+	if retry.MaxBackoff == 0 {
+		retry.MaxBackoff = DefaultMaxBackoff
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	var db *sql.DB
+	if opts.Secrets != nil {
+		connector, err := newSecretConnector(dsn, opts.Secrets)
+		if err != nil {
+			return nil, err
+		}
+		db = sql.OpenDB(connector)
+	} else {
+		var err error
+		if db, err = sql.Open("postgres", dsn); err != nil {
+			return nil, err
+		}
+	}
+	opts.Pool.apply(db)
+
+	backoff := retry.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return db, nil
+		}
+		if attempt >= retry.Attempts || !retryable(err) || ctx.Err() != nil {
+			db.Close()
+			return nil, err
+		}
+
+		logger.WarnContext(ctx, "Database unavailable, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			db.Close()
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, retry.MaxBackoff)
+	}
+}
+
+// retryable reports whether a failed ping may succeed later. PostgreSQL
+// rejecting the credentials (class 28) or the database name (3D) is final.
+func retryable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		class := pqErr.Code.Class()
+		return class != "28" && class != "3D"
+	}
+	return true
+}
+
+// Cluster is a primary database and its read replicas
+type Cluster struct {
+	Primary  *sql.DB
+	Replicas []*sql.DB
+}
+
+// ConnectCluster connects to the primary and to each replica with Connect.
+// The replicas share opts, so they must accept the same credentials.
+func ConnectCluster(ctx context.Context, primary string, replicas []string, opts Options) (*Cluster, error) {
+	db, err := Connect(ctx, primary, opts)
+	if err != nil {
+		return nil, err
+	}
+	cluster := &Cluster{Primary: db}
+
+	for i, dsn := range replicas {
+		replica, err := Connect(ctx, dsn, opts)
+		if err != nil {
+			cluster.Close()
+			return nil, fmt.Errorf("db: replica %d: %w", i+1, err)
+		}
+		cluster.Replicas = append(cluster.Replicas, replica)
+	}
+	return cluster, nil
+}
+
+// Close closes the primary and every replica
+func (c *Cluster) Close() error {
+	errs := []error{c.Primary.Close()}
+	for _, replica := range c.Replicas {
+		errs = append(errs, replica.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/lib/pq"
+	"github.com/rb4807/Golang-Utlis/secrets"
+)
+
+// PasswordSecret is the name the database password is read under from a
+// secrets.Provider
+const PasswordSecret = "db_password"
+
+// OpenWithSecrets is like Open, but reads the password from provider
+// instead of the connection string, falling back to the connection string's
+// password if the provider does not hold one. The password is read again
+// for every new connection, so a rotated password takes effect as the pool
+// replaces its connections, without a restart.
+func OpenWithSecrets(ctx context.Context, dsn string, provider secrets.Provider) (*sql.DB, error) {
+	return Connect(ctx, dsn, Options{Secrets: provider, Retry: RetryConfig{Attempts: 1}})
+}
+
+// newSecretConnector returns a connector for dsn that takes the password
+// from provider
+func newSecretConnector(dsn string, provider secrets.Provider) (*secretConnector, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("db: invalid connection string: %w", err)
+	}
+	return &secretConnector{dsn: u, provider: provider}, nil
+}
+
+// secretConnector opens lib/pq connections with the current password
+type secretConnector struct {
+	dsn      *url.URL
+	provider secrets.Provider
+}
+
+// Connect implements driver.Connector
+func (c *secretConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	u := *c.dsn
+	password, err := c.provider.Secret(ctx, PasswordSecret)
+	switch {
+	case err == nil:
+		u.User = url.UserPassword(c.dsn.User.Username(), password)
+	case !errors.Is(err, secrets.ErrNotFound):
+		return nil, fmt.Errorf("db: reading password: %w", err)
+	}
+	connector, err := pq.NewConnector(u.String())
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+// Driver implements driver.Connector
+func (c *secretConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
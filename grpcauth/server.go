@@ -0,0 +1,222 @@
+// Package grpcauth authenticates gRPC calls with the tokens accepted by
+// auth.Service.AuthMiddleware. Server interceptors verify the token sent in
+// the "authorization" metadata and store its claims in the context, where
+// auth.GetUserFromContext finds them; Credentials attaches tokens on the
+// client side.
+package grpcauth
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rb4807/Golang-Utlis/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// MetadataKey is the metadata key carrying "Bearer <token>" or "ApiKey <key>"
+const MetadataKey = "authorization"
+
+// Rule decides whether the authenticated caller may invoke a method
+type Rule func(claims *auth.TokenClaims) bool
+
+// RequireSuperuser admits superusers only
+func RequireSuperuser(claims *auth.TokenClaims) bool {
+	return claims.IsSuperuser
+}
+
+// RequireScope admits callers whose token grants scope
+func RequireScope(scope string) Rule {
+	return func(claims *auth.TokenClaims) bool {
+		return claims.HasScope(scope)
+	}
+}
+
+// Option configures the server interceptors
+type Option func(*options)
+
+type options struct {
+	public      map[string]bool
+	rules       map[string]Rule
+	defaultRule Rule
+}
+
+// WithPublic lets calls to methods through without a token. Methods are
+// full names such as "/pkg.Service/Method", or "/pkg.Service/*" for every
+// method of a service.
+func WithPublic(methods ...string) Option {
+	return func(o *options) {
+		for _, method := range methods {
+			o.public[method] = true
+		}
+	}
+}
+
+// WithRule requires callers of method, named as for WithPublic, to pass rule
+func WithRule(method string, rule Rule) Option {
+	return func(o *options) {
+		o.rules[method] = rule
+	}
+}
+
+// WithDefaultRule applies rule to authenticated methods that have no rule
+// of their own
+func WithDefaultRule(rule Rule) Option {
+	return func(o *options) {
+		o.defaultRule = rule
+	}
+}
+
+// UnaryServerInterceptor authenticates unary calls
+func UnaryServerInterceptor(authService *auth.Service, opts ...Option) grpc.UnaryServerInterceptor {
+	a := newAuthenticator(authService, opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor authenticates streaming calls
+func StreamServerInterceptor(authService *auth.Service, opts ...Option) grpc.StreamServerInterceptor {
+	a := newAuthenticator(authService, opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authenticate(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// serverStream overrides the context of a stream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticator holds the configuration shared by both interceptors
+type authenticator struct {
+	authService *auth.Service
+	options
+}
+
+func newAuthenticator(authService *auth.Service, opts []Option) *authenticator {
+	a := &authenticator{
+		authService: authService,
+		options: options{
+			public: make(map[string]bool),
+			rules:  make(map[string]Rule),
+		},
+	}
+	for _, opt := range opts {
+		opt(&a.options)
+	}
+	return a
+}
+
+// authenticate verifies the caller of method and returns ctx with its claims
+// and client information
+func (a *authenticator) authenticate(ctx context.Context, method string) (context.Context, error) {
+	ctx = auth.WithClientInfo(ctx, clientInfo(ctx))
+
+	if lookup(a.public, method) {
+		return ctx, nil
+	}
+
+	claims, err := a.verify(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := lookup(a.rules, method)
+	if rule == nil {
+		rule = a.defaultRule
+	}
+	if rule != nil && !rule(claims) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	return auth.AddUserToContext(ctx, claims), nil
+}
+
+// verify checks the token in the call's metadata
+func (a *authenticator) verify(ctx context.Context) (*auth.TokenClaims, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(MetadataKey)
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "authorization metadata is required")
+	}
+
+	scheme, credential, ok := strings.Cut(values[0], " ")
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "authorization must be Bearer <token> or ApiKey <key>")
+	}
+
+	var claims *auth.TokenClaims
+	var err error
+	switch scheme {
+	case "Bearer":
+		claims, err = a.authService.VerifyJWTContext(ctx, credential)
+	case "ApiKey":
+		claims, err = a.authService.VerifyAPIKeyContext(ctx, credential)
+	default:
+		return nil, status.Error(codes.Unauthenticated, "authorization must be Bearer <token> or ApiKey <key>")
+	}
+	if err != nil {
+		if isTokenError(err) {
+			return nil, status.Error(codes.Unauthenticated, "invalid or expired token")
+		}
+		return nil, status.Error(codes.Internal, "error verifying token")
+	}
+	return claims, nil
+}
+
+// lookup finds the entry for method, falling back to its service's
+// "/pkg.Service/*" entry
+func lookup[V any](entries map[string]V, method string) V {
+	if v, ok := entries[method]; ok {
+		return v
+	}
+	if i := strings.LastIndex(method, "/"); i > 0 {
+		return entries[method[:i+1]+"*"]
+	}
+	var zero V
+	return zero
+}
+
+// isTokenError reports whether err means the token itself is unusable, as
+// opposed to a failure while checking it
+func isTokenError(err error) bool {
+	var validationErr *jwt.ValidationError
+	return errors.As(err, &validationErr) || errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrTokenRevoked)
+}
+
+// clientInfo describes the peer for audit events
+func clientInfo(ctx context.Context) auth.ClientInfo {
+	var info auth.ClientInfo
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		info.IP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(info.IP); err == nil {
+			info.IP = host
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ua := md.Get("user-agent"); len(ua) > 0 {
+			info.UserAgent = ua[0]
+		}
+	}
+	return info
+}
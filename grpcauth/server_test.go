@@ -0,0 +1,240 @@
+package grpcauth_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/authtest"
+	"github.com/rb4807/Golang-Utlis/grpcauth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	checkMethod = "/grpc.health.v1.Health/Check"
+	watchMethod = "/grpc.health.v1.Health/Watch"
+)
+
+// healthServer records the caller that the interceptors stored in each
+// handler's context
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+
+	mu     sync.Mutex
+	caller *auth.TokenClaims
+}
+
+func (s *healthServer) record(ctx context.Context) {
+	claims, _ := auth.GetUserFromContext(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.caller = claims
+}
+
+func (s *healthServer) lastCaller() *auth.TokenClaims {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.caller
+}
+
+func (s *healthServer) Check(ctx context.Context, _ *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	s.record(ctx)
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func (s *healthServer) Watch(_ *healthpb.HealthCheckRequest, stream grpc.ServerStreamingServer[healthpb.HealthCheckResponse]) error {
+	s.record(stream.Context())
+	return stream.Send(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING})
+}
+
+// newClient serves a health service behind the interceptors over an
+// in-memory connection
+func newClient(t *testing.T, h *authtest.Harness, opts ...grpcauth.Option) (healthpb.HealthClient, *healthServer) {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(grpcauth.UnaryServerInterceptor(h.Service, opts...)),
+		grpc.StreamInterceptor(grpcauth.StreamServerInterceptor(h.Service, opts...)),
+	)
+	health := &healthServer{}
+	healthpb.RegisterHealthServer(srv, health)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn), health
+}
+
+// withAuthorization sends value as the authorization metadata
+func withAuthorization(value string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), grpcauth.MetadataKey, value)
+}
+
+// check calls the unary method and returns the status code
+func check(ctx context.Context, client healthpb.HealthClient) codes.Code {
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	return status.Code(err)
+}
+
+// watch calls the streaming method and returns the status code of its
+// first message
+func watch(ctx context.Context, client healthpb.HealthClient) codes.Code {
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return status.Code(err)
+	}
+	_, err = stream.Recv()
+	return status.Code(err)
+}
+
+func TestUnauthenticated(t *testing.T) {
+	h := authtest.New(t)
+	user := h.NewUser()
+	client, _ := newClient(t, h)
+
+	expired := h.Claims(user)
+	expired.IssuedAt = h.Clock.Now().Add(-2 * time.Hour).Unix()
+	expired.ExpiresAt = h.Clock.Now().Add(-time.Hour).Unix()
+	revoked := h.Claims(user)
+	h.Store.Revoke(revoked.Id)
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+	}{
+		{"missing metadata", context.Background()},
+		{"bad scheme", withAuthorization("Token " + h.Token(user))},
+		{"scheme without a token", withAuthorization("Bearer")},
+		{"malformed token", withAuthorization("Bearer not-a-token")},
+		{"expired token", withAuthorization("Bearer " + h.TokenFor(expired))},
+		{"revoked token", withAuthorization("Bearer " + h.TokenFor(revoked))},
+	}
+	for _, tt := range tests {
+		if code := check(tt.ctx, client); code != codes.Unauthenticated {
+			t.Errorf("%s: Check: %v, want Unauthenticated", tt.name, code)
+		}
+		if code := watch(tt.ctx, client); code != codes.Unauthenticated {
+			t.Errorf("%s: Watch: %v, want Unauthenticated", tt.name, code)
+		}
+	}
+}
+
+func TestCallerInContext(t *testing.T) {
+	h := authtest.New(t)
+	user := h.NewUser()
+	client, health := newClient(t, h)
+	ctx := withAuthorization("Bearer " + h.Token(user))
+
+	if code := check(ctx, client); code != codes.OK {
+		t.Fatalf("Check: %v", code)
+	}
+	if caller := health.lastCaller(); caller == nil || caller.UserID != user.ID {
+		t.Errorf("Check handler saw caller %+v, want user %d", caller, user.ID)
+	}
+
+	health.record(context.Background())
+	if code := watch(ctx, client); code != codes.OK {
+		t.Fatalf("Watch: %v", code)
+	}
+	if caller := health.lastCaller(); caller == nil || caller.UserID != user.ID {
+		t.Errorf("Watch handler saw caller %+v, want user %d", caller, user.ID)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	h := authtest.New(t)
+	user := h.NewUser()
+	client, health := newClient(t, h)
+
+	creds := grpcauth.TokenCredentials(h.Token(user))
+	creds.AllowInsecure = true
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}, grpc.PerRPCCredentials(creds))
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if caller := health.lastCaller(); caller == nil || caller.UserID != user.ID {
+		t.Errorf("handler saw caller %+v, want user %d", caller, user.ID)
+	}
+}
+
+func TestWithPublic(t *testing.T) {
+	h := authtest.New(t)
+
+	t.Run("wildcard", func(t *testing.T) {
+		client, health := newClient(t, h, grpcauth.WithPublic("/grpc.health.v1.Health/*"))
+		if code := check(context.Background(), client); code != codes.OK {
+			t.Errorf("Check: %v, want OK", code)
+		}
+		if code := watch(context.Background(), client); code != codes.OK {
+			t.Errorf("Watch: %v, want OK", code)
+		}
+		if caller := health.lastCaller(); caller != nil {
+			t.Errorf("handler saw caller %+v on a public method", caller)
+		}
+	})
+
+	t.Run("method", func(t *testing.T) {
+		client, _ := newClient(t, h, grpcauth.WithPublic(checkMethod))
+		if code := check(context.Background(), client); code != codes.OK {
+			t.Errorf("Check: %v, want OK", code)
+		}
+		if code := watch(context.Background(), client); code != codes.Unauthenticated {
+			t.Errorf("Watch: %v, want Unauthenticated", code)
+		}
+	})
+
+	t.Run("other service", func(t *testing.T) {
+		client, _ := newClient(t, h, grpcauth.WithPublic("/grpc.health.v2.Health/*"))
+		if code := check(context.Background(), client); code != codes.Unauthenticated {
+			t.Errorf("Check: %v, want Unauthenticated", code)
+		}
+	})
+}
+
+func TestRules(t *testing.T) {
+	h := authtest.New(t)
+	user := h.NewUser()
+	superuser := h.NewSuperuser()
+	readOnly := h.Claims(user)
+	readOnly.Scope = auth.ScopeRead
+
+	client, _ := newClient(t, h,
+		grpcauth.WithRule(checkMethod, grpcauth.RequireSuperuser),
+		grpcauth.WithDefaultRule(grpcauth.RequireScope(auth.ScopeAdmin)),
+	)
+
+	tests := []struct {
+		name  string
+		call  func(context.Context, healthpb.HealthClient) codes.Code
+		token string
+		want  codes.Code
+	}{
+		{"Check as a user", check, h.Token(user), codes.PermissionDenied},
+		{"Check as a superuser", check, h.Token(superuser), codes.OK},
+		{"Watch without the admin scope", watch, h.TokenFor(readOnly), codes.PermissionDenied},
+		{"Watch with an unscoped token", watch, h.Token(user), codes.OK},
+	}
+	for _, tt := range tests {
+		if code := tt.call(withAuthorization("Bearer "+tt.token), client); code != tt.want {
+			t.Errorf("%s: %v, want %v", tt.name, code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// LivenessHandler serves /healthz. It runs no checks: a response means the
+// process is serving requests.
+func (r *Registry) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, &Report{Status: StatusOK, CheckedAt: time.Now()})
+	})
+}
+
+// ReadinessHandler serves /readyz. It responds 200 if every critical check
+// passes and 503 otherwise, with the report as JSON.
+func (r *Registry) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, r.Check(req.Context()))
+	})
+}
+
+// writeReport writes report with the status code its status implies
+func writeReport(w http.ResponseWriter, report *Report) {
+	status := http.StatusOK
+	if report.Status == StatusFail {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,217 @@
+// Package health serves liveness and readiness probes. Liveness (/healthz)
+// only shows that the process is serving requests, so an orchestrator
+// restarts it when it hangs; readiness (/readyz) runs the registered
+// dependency checks, so traffic is withheld while, say, Postgres is
+// unreachable, without restarting a process that would recover by itself:
+//
+//	checks := health.New(health.Options{})
+//	checks.Register("database", health.DB(db), health.WithTimeout(time.Second))
+//	checks.Register("notifier", health.CheckerFunc(authService.CheckNotifier), health.NonCritical())
+//
+// Results are cached for Options.CacheTTL, so frequent probes from several
+// sources do not hammer the database.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// Defaults for zero Options fields
+const (
+	DefaultCacheTTL = 5 * time.Second
+	DefaultTimeout  = 2 * time.Second
+)
+
+// Statuses reported for the service and for each check
+const (
+	StatusOK   = "ok"
+	StatusWarn = "warn" // A non-critical check failed; the service is still ready
+	StatusFail = "fail"
+)
+
+// Checker checks one dependency
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc adapts a function, such as auth.Service.CheckSchema, to a
+// Checker
+type CheckerFunc func(ctx context.Context) error
+
+// Check implements Checker
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// DB checks that db can reach the database
+func DB(db *sql.DB) Checker {
+	return CheckerFunc(db.PingContext)
+}
+
+// Options configures a Registry
+type Options struct {
+	CacheTTL time.Duration // Optional: how long results are reused. Default: 5s
+	Timeout  time.Duration // Optional: default time limit for each check. Default: 2s
+}
+
+// CheckOption configures a registered check
+type CheckOption func(*check)
+
+// WithTimeout overrides the registry's time limit for a check
+func WithTimeout(timeout time.Duration) CheckOption {
+	return func(c *check) {
+		c.timeout = timeout
+	}
+}
+
+// NonCritical reports a failing check as a warning without failing
+// readiness, for dependencies only some requests need
+func NonCritical() CheckOption {
+	return func(c *check) {
+		c.critical = false
+	}
+}
+
+type check struct {
+	name     string
+	checker  Checker
+	timeout  time.Duration
+	critical bool
+}
+
+// Registry holds the checks behind the readiness probe
+type Registry struct {
+	options Options
+	checks  []check
+
+	mu       sync.Mutex
+	report   *Report
+	expires  time.Time
+	inflight chan struct{}
+}
+
+// New creates an empty registry
+func New(options Options) *Registry {
+	if options.CacheTTL == 0 {
+		options.CacheTTL = DefaultCacheTTL
+	}
+	if options.Timeout == 0 {
+		options.Timeout = DefaultTimeout
+	}
+	return &Registry{options: options}
+}
+
+// Register adds a check. Checks must be registered before the registry
+// serves probes.
+func (r *Registry) Register(name string, checker Checker, opts ...CheckOption) {
+	c := check{name: name, checker: checker, timeout: r.options.Timeout, critical: true}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	r.checks = append(r.checks, c)
+}
+
+// Report is the result of running the checks
+type Report struct {
+	Status    string            `json:"status"`
+	CheckedAt time.Time         `json:"checked_at"`
+	Checks    map[string]Result `json:"checks,omitempty"`
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Status   string  `json:"status"`
+	Duration float64 `json:"duration_ms"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Check returns the cached report, running the checks if it has expired.
+// Concurrent callers share a single run.
+func (r *Registry) Check(ctx context.Context) *Report {
+	for {
+		r.mu.Lock()
+		if r.report != nil && time.Now().Before(r.expires) {
+			report := r.report
+			r.mu.Unlock()
+			return report
+		}
+		if wait := r.inflight; wait != nil {
+			r.mu.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return &Report{Status: StatusFail, CheckedAt: time.Now()}
+			}
+		}
+		done := make(chan struct{})
+		r.inflight = done
+		r.mu.Unlock()
+
+		// The run is shared, so one caller giving up must not cancel it
+		report := r.run(context.WithoutCancel(ctx))
+
+		r.mu.Lock()
+		r.report = report
+		r.expires = time.Now().Add(r.options.CacheTTL)
+		r.inflight = nil
+		r.mu.Unlock()
+		close(done)
+		return report
+	}
+}
+
+// run runs every check concurrently
+func (r *Registry) run(ctx context.Context) *Report {
+	report := &Report{
+		Status:    StatusOK,
+		CheckedAt: time.Now(),
+		Checks:    make(map[string]Result, len(r.checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range r.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := c.run(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.name] = result
+			switch {
+			case result.Status == StatusFail:
+				report.Status = StatusFail
+			case result.Status == StatusWarn && report.Status == StatusOK:
+				report.Status = StatusWarn
+			}
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+// run runs the check with its time limit
+func (c check) run(ctx context.Context) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.checker.Check(ctx)
+	result := Result{
+		Status:   StatusOK,
+		Duration: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusFail
+		if !c.critical {
+			result.Status = StatusWarn
+		}
+		result.Error = err.Error()
+	}
+	return result
+}
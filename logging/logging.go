@@ -0,0 +1,125 @@
+// Package logging builds log/slog loggers for the service. Records are
+// enriched with the request ID and trace context carried by the context
+// passed to the *Context logging methods, and attributes that look like
+// credentials are redacted before they are written.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Output formats
+const (
+	FormatJSON = "json" // One JSON object per line, for production
+	FormatText = "text" // key=value pairs, for local development
+)
+
+// Redacted replaces the value of sensitive attributes
+const Redacted = "[REDACTED]"
+
+// Options configures New
+type Options struct {
+	Level  slog.Level // Minimum level to write. Default: info
+	Format string     // FormatJSON or FormatText. Default: json
+	Writer io.Writer  // Optional: defaults to os.Stderr
+}
+
+// New creates a logger that redacts sensitive attributes and adds the
+// request ID and trace context from the record's context
+func New(opts Options) *slog.Logger {
+	writer := opts.Writer
+	if writer == nil {
+		writer = os.Stderr
+	}
+
+	handlerOpts := &slog.HandlerOptions{
+		Level:       opts.Level,
+		ReplaceAttr: redactAttr,
+	}
+
+	var handler slog.Handler
+	if opts.Format == FormatText {
+		handler = slog.NewTextHandler(writer, handlerOpts)
+	} else {
+		handler = slog.NewJSONHandler(writer, handlerOpts)
+	}
+	return slog.New(contextHandler{handler})
+}
+
+// ParseLevel parses a level name such as "debug" or "WARN". An empty name
+// is the info level.
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("logging: invalid level %q", name)
+	}
+	return level, nil
+}
+
+// contextHandler adds request-scoped attributes taken from the context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		record.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// sensitiveKeys are substrings of attribute keys whose values are redacted
+var sensitiveKeys = []string{"password", "secret", "token", "authorization", "cookie", "apikey", "api_key"}
+
+// redactAttr replaces the value of attributes whose key names a credential
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if IsSensitive(attr.Key) {
+		return slog.String(attr.Key, Redacted)
+	}
+	return attr
+}
+
+// IsSensitive reports whether an attribute or field named key holds a
+// credential, such as a password, token, OTP or API key
+func IsSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+
+	// "otp" is only matched as a whole word, so keys like "footprint" pass
+	for _, part := range strings.FieldsFunc(key, func(r rune) bool {
+		return r == '_' || r == '-' || r == '.'
+	}) {
+		if part == "otp" {
+			return true
+		}
+	}
+	return false
+}
@@ -1,40 +1,161 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"log"
-	"net/http"
-	"time"
+	"log/slog"
+	"os"
 
 	"github.com/rb4807/Golang-Utlis/auth"
+	"github.com/rb4807/Golang-Utlis/config"
 	"github.com/rb4807/Golang-Utlis/db"
+	"github.com/rb4807/Golang-Utlis/health"
+	"github.com/rb4807/Golang-Utlis/logging"
+	"github.com/rb4807/Golang-Utlis/metrics"
 	"github.com/rb4807/Golang-Utlis/router"
+	"github.com/rb4807/Golang-Utlis/server"
+	"github.com/rb4807/Golang-Utlis/telemetry"
 )
 
 func main() {
-	// Connect to database
-	database := db.InitDB()
-	defer database.Close()
+	// Load configuration from -config, the environment and flags
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	printConfig := fs.Bool("print-config", false, "print the effective configuration, with secrets redacted, and exit")
+	cfg, err := config.Load(fs, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *printConfig {
+		if err := cfg.Dump(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Set up logging
+	level, _ := logging.ParseLevel(cfg.Logging.Level)
+	logger := logging.New(logging.Options{
+		Level:  level,
+		Format: cfg.Logging.Format,
+	})
+	slog.SetDefault(logger)
+
+	// Run returns only after shutdown, so its deferred cleanup always runs
+	if err := run(cfg, logger); err != nil {
+		logger.Error("Server stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run starts the server and its dependencies and blocks until it has shut
+// down. Resources are released in reverse order as it returns: the
+// database pool once background workers have stopped, then buffered spans.
+func run(cfg *config.Config, logger *slog.Logger) error {
+	// Set up tracing
+	shutdownTracing, err := telemetry.Setup(context.Background(), telemetry.Config{
+		ServiceName:  "golang-utils",
+		Exporter:     cfg.Tracing.Exporter,
+		OTLPEndpoint: cfg.Tracing.Endpoint,
+		Insecure:     cfg.Tracing.Insecure,
+		SampleRatio:  cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		return fmt.Errorf("setting up tracing: %w", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// Secrets not set directly are read from the environment, mounted files
+	// or the keyring, and re-read so rotations take effect without a restart
+	secretProvider, err := cfg.Secrets.Provider()
+	if err != nil {
+		return fmt.Errorf("opening secrets: %w", err)
+	}
+
+	// Connect to the database and its read replicas, waiting for them to start
+	dbOptions := cfg.Database.Options()
+	dbOptions.Logger = logger
+	if cfg.Database.Password == "" {
+		dbOptions.Secrets = secretProvider
+	}
+	cluster, err := db.ConnectCluster(context.Background(), cfg.Database.DSN(), cfg.Database.ReplicaDSNs(), dbOptions)
+	if err != nil {
+		return fmt.Errorf("connecting to database: %w", err)
+	}
+	defer cluster.Close()
+	database := cluster.Primary
+	logger.Info("Database connected successfully", "host", cfg.Database.Host, "database", cfg.Database.Name, "replicas", len(cluster.Replicas))
 
 	// Initialize auth DB
 	if err := auth.InitDB(database); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		return fmt.Errorf("initializing database: %w", err)
 	}
 
 	// Initialize auth service
-	authService, err := auth.NewService(auth.Config{
-		JWTSecret:     "your-secret-key",
-		TokenDuration: 24 * time.Hour,
-		DBConnection:  database,
-	})
+	authConfig := auth.Config{
+		JWTSecret:                 cfg.Token.Secret,
+		TokenDuration:             cfg.Token.Duration.Std(),
+		DBConnection:              database,
+		ReadReplicas:              cluster.Replicas,
+		Logger:                    logger,
+		PasswordPolicy:            cfg.Password.Policy(),
+		DisablePublicRegistration: !cfg.Features.PublicRegistration,
+	}
+	if cfg.Token.Secret == "" {
+		authConfig.SecretProvider = secretProvider
+		authConfig.SecretRefreshInterval = cfg.Secrets.RefreshInterval.Std()
+	}
+	if cfg.Features.Sessions {
+		authConfig.Sessions = &auth.SessionConfig{}
+	}
+	if cfg.Features.Webhooks {
+		authConfig.Webhooks = cfg.Webhooks.Config()
+	}
+	routerOptions := []router.Option{
+		router.WithTracing(),
+		router.WithLogger(logger),
+	}
+	if cfg.Features.Metrics {
+		// Collect Prometheus metrics, served on /metrics
+		m := metrics.New()
+		authConfig.Metrics = m
+		routerOptions = append(routerOptions, router.WithMetrics(m))
+	}
+
+	authService, err := auth.NewService(authConfig)
 	if err != nil {
-		log.Fatalf("Failed to create authentication service: %v", err)
+		return fmt.Errorf("creating authentication service: %w", err)
+	}
+
+	// Readiness checks, served on /readyz
+	checks := health.New(health.Options{
+		CacheTTL: cfg.Health.CacheTTL.Std(),
+		Timeout:  cfg.Health.Timeout.Std(),
+	})
+	checks.Register("database", health.DB(database))
+	for i, replica := range cluster.Replicas {
+		// Reads fall back to the primary, so a lost replica is not fatal
+		checks.Register(fmt.Sprintf("replica-%d", i+1), health.DB(replica), health.NonCritical())
 	}
+	checks.Register("schema", health.CheckerFunc(authService.CheckSchema))
+	checks.Register("secrets", health.CheckerFunc(authService.CheckSecrets))
+	checks.Register("notifier", health.CheckerFunc(authService.CheckNotifier), health.NonCritical())
+	routerOptions = append(routerOptions, router.WithHealth(checks))
 
 	// Set up routes
-	r := router.SetupRoutes(authService)
+	r := router.SetupRoutes(authService, routerOptions...)
 
-	// Start server
-	fmt.Println("Server starting on port 8080...")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	// Serve until SIGINT or SIGTERM, then drain requests and stop workers
+	serverConfig := cfg.Server.Config()
+	serverConfig.Logger = logger
+	srv := server.New(serverConfig, r)
+	if authConfig.SecretProvider != nil {
+		srv.Go("secret refresh", authService.RunSecretRefresh)
+	}
+	if authConfig.Webhooks != nil {
+		srv.Go("webhook dispatcher", authService.RunWebhookDispatcher)
+	}
+	return srv.Run(context.Background())
 }
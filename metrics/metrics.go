@@ -0,0 +1,178 @@
+// Package metrics collects Prometheus metrics for the auth service and its
+// HTTP handlers. A nil *Metrics is valid and records nothing, so callers
+// never need to check whether metrics are enabled.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes every metric name
+const Namespace = "auth"
+
+// Metrics holds the collectors shared by auth.Service and the router
+type Metrics struct {
+	registry prometheus.Gatherer
+
+	operations         *prometheus.CounterVec
+	tokenVerifications *prometheus.CounterVec
+	passwordDuration   *prometheus.HistogramVec
+	dbDuration         *prometheus.HistogramVec
+	httpRequests       *prometheus.CounterVec
+	httpDuration       *prometheus.HistogramVec
+}
+
+// New creates the collectors and registers them with a new registry, which
+// also includes the Go runtime and process collectors
+func New() *Metrics {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return NewWithRegistry(registry, registry)
+}
+
+// NewWithRegistry creates the collectors and registers them with reg.
+// gatherer is what the /metrics endpoint serves; it is usually reg itself.
+func NewWithRegistry(reg prometheus.Registerer, gatherer prometheus.Gatherer) *Metrics {
+	m := &Metrics{
+		registry: gatherer,
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "operations_total",
+			Help:      "Auth operations, such as logins and password changes, by outcome.",
+		}, []string{"operation", "outcome"}),
+		tokenVerifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "token_verifications_total",
+			Help:      "Token verifications by token type and result.",
+		}, []string{"type", "result"}),
+		passwordDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "password_duration_seconds",
+			Help:      "Time spent hashing and verifying passwords.",
+			Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"operation"}),
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "db_duration_seconds",
+			Help:      "Time spent in database calls, by call type and outcome.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"call", "outcome"}),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+	}
+
+	reg.MustRegister(
+		m.operations,
+		m.tokenVerifications,
+		m.passwordDuration,
+		m.dbDuration,
+		m.httpRequests,
+		m.httpDuration,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Operation counts an auth operation with its outcome
+func (m *Metrics) Operation(operation, outcome string) {
+	if m == nil {
+		return
+	}
+	m.operations.WithLabelValues(operation, outcome).Inc()
+}
+
+// TokenVerification counts a token verification. tokenType is "jwt",
+// "api_key" or "session"; result is "valid" or the reason it was rejected.
+func (m *Metrics) TokenVerification(tokenType, result string) {
+	if m == nil {
+		return
+	}
+	m.tokenVerifications.WithLabelValues(tokenType, result).Inc()
+}
+
+// ObservePassword records the duration of a password "hash" or "verify"
+// operation started at start
+func (m *Metrics) ObservePassword(operation string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.passwordDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// ObserveDB records the duration of a database call started at start
+func (m *Metrics) ObserveDB(call string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.dbDuration.WithLabelValues(call, outcome(err)).Observe(time.Since(start).Seconds())
+}
+
+// Middleware instruments h, labelling its requests with route. Use the
+// route pattern rather than the request path to keep label cardinality low.
+func (m *Metrics) Middleware(route string, h http.Handler) http.Handler {
+	if m == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+
+		m.httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		m.httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// outcome maps an error to the "success" or "failure" label value
+func outcome(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
@@ -1,23 +1,181 @@
 package router
 
 import (
+	"log/slog"
 	"net/http"
 
 	"github.com/rb4807/Golang-Utlis/auth"
 	"github.com/rb4807/Golang-Utlis/controller"
+	"github.com/rb4807/Golang-Utlis/health"
+	"github.com/rb4807/Golang-Utlis/logging"
+	"github.com/rb4807/Golang-Utlis/metrics"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
-func SetupRoutes(authService *auth.Service) *http.ServeMux {
+// Option configures optional routes and middleware in SetupRoutes
+type Option func(*options)
+
+type options struct {
+	metrics *metrics.Metrics
+	tracing bool
+	logger  *slog.Logger
+	health  *health.Registry
+}
+
+// WithLogger assigns every request an ID, taken from the X-Request-ID header
+// when the client sends one, and logs each request to logger
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithTracing starts a span for every request, continuing any trace passed
+// in W3C trace context headers. Spans go to the global tracer provider; see
+// the telemetry package.
+func WithTracing() Option {
+	return func(o *options) {
+		o.tracing = true
+	}
+}
+
+// WithMetrics instruments every route with m and serves m on /metrics
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// WithHealth serves the liveness probe on /healthz and the readiness probe,
+// which runs the checks in registry, on /readyz
+func WithHealth(registry *health.Registry) Option {
+	return func(o *options) {
+		o.health = registry
+	}
+}
+
+// Route is a path pattern and the handler serving it, with its middleware
+// already applied. Patterns use net/http syntax: "/api-keys/{id}" matches
+// any method, and handlers read path parameters with Request.PathValue.
+type Route struct {
+	Pattern string
+	Handler http.Handler
+}
+
+func SetupRoutes(authService *auth.Service, opts ...Option) *http.ServeMux {
 	mux := http.NewServeMux()
+	for _, route := range Routes(authService, opts...) {
+		mux.Handle(route.Pattern, route.Handler)
+	}
+	return mux
+}
+
+// Routes returns the routes SetupRoutes registers, for mounting on other
+// routers (see the adapters packages)
+func Routes(authService *auth.Service, opts ...Option) []Route {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var routes []Route
+
+	// handle adds a route for pattern, with the metrics, logging and tracing
+	// middleware that are enabled. Each is labelled with the pattern.
+	handle := func(pattern string, h http.Handler) {
+		h = o.metrics.Middleware(pattern, h)
+		if o.logger != nil {
+			h = logging.RequestID(logging.AccessLog(o.logger, pattern, h))
+		}
+		if o.tracing {
+			h = otelhttp.NewHandler(h, pattern)
+		}
+		routes = append(routes, Route{Pattern: pattern, Handler: h})
+	}
+
+	// CSRF protection applies to cookie-authenticated state-changing requests;
+	// bearer-token and cookie-less requests pass through unchecked
+	csrf := authService.CSRFMiddleware
+	protected := func(h http.Handler) http.Handler {
+		return csrf(authService.AuthMiddleware(h))
+	}
 
 	// Public routes
-	mux.HandleFunc("/register", controller.RegisterHandler(authService))
-	mux.HandleFunc("/login", controller.LoginHandler(authService))
+	if authService.PublicRegistrationEnabled() {
+		handle("/register", csrf(controller.RegisterHandler(authService)))
+	}
+	handle("/invitations/accept", csrf(controller.AcceptInvitationHandler(authService)))
+	// Login returns the token in the response body and sets no cookie, so a
+	// forged request gains nothing; a stale session cookie must not block it
+	handle("/login", controller.LoginHandler(authService))
+	handle("/csrf", csrf(http.HandlerFunc(controller.CSRFTokenHandler)))
+
+	// OAuth client routes (authenticated with client credentials)
+	handle("/oauth/introspect", controller.IntrospectHandler(authService))
+	handle("/oauth/revoke", controller.RevokeHandler(authService))
+
+	// External identity provider routes
+	handle("/auth/external/{provider}/login", controller.ExternalLoginHandler(authService))
+	handle("/auth/external/{provider}/callback", controller.ExternalCallbackHandler(authService))
+
+	// Cookie session routes
+	if authService.SessionsEnabled() {
+		handle("/session/login", csrf(controller.SessionLoginHandler(authService)))
+		handle("/session/logout", csrf(controller.SessionLogoutHandler(authService)))
+	}
 
 	// Protected routes
-	mux.Handle("/profile", authService.AuthMiddleware(http.HandlerFunc(controller.ProfileHandler(authService))))
-	mux.Handle("/admin", authService.AdminMiddleware(http.HandlerFunc(controller.AdminHandler)))
-	mux.Handle("/superuser", authService.SuperuserMiddleware(http.HandlerFunc(controller.SuperuserHandler)))
+	handle("/profile", protected(controller.ProfileHandler(authService)))
+	handle("/auth/external", protected(controller.ExternalIdentitiesHandler(authService)))
+	handle("/auth/external/{provider}/link", protected(controller.ExternalLinkHandler(authService)))
+	handle("/api-keys", protected(controller.APIKeysHandler(authService)))
+	handle("/api-keys/{id}", protected(controller.RevokeAPIKeyHandler(authService)))
+	handle("/sessions", protected(controller.SessionsHandler(authService)))
+	handle("/sessions/{session}", protected(controller.RevokeSessionHandler(authService)))
+	handle("/orgs", protected(controller.OrganizationsHandler(authService)))
+	handle("/orgs/switch", protected(controller.SwitchOrganizationHandler(authService)))
 
-	return mux
+	// Organization routes, scoped to the organization selected in the token
+	org := func(h http.Handler) http.Handler {
+		return csrf(authService.OrganizationMiddleware(h))
+	}
+	handle("/org/members", org(controller.OrganizationMembersHandler(authService)))
+	handle("/org/members/{id}", org(controller.OrganizationMemberHandler(authService)))
+
+	orgAdmin := func(h http.Handler) http.Handler {
+		return csrf(authService.RequireOrganizationRole(auth.RoleAdmin)(h))
+	}
+	handle("/org/invitations", orgAdmin(controller.OrganizationInvitationsHandler(authService)))
+	handle("/org/invitations/{id}", orgAdmin(controller.OrganizationRevokeInvitationHandler(authService)))
+	handle("/org/invitations/{id}/resend", orgAdmin(controller.OrganizationResendInvitationHandler(authService)))
+
+	// Admin routes
+	admin := func(h http.Handler) http.Handler {
+		return csrf(authService.AdminMiddleware(h))
+	}
+	handle("/admin/users/{id}/sessions", admin(controller.AdminUserSessionsHandler(authService)))
+	handle("/admin/users/{id}/sessions/{session}", admin(controller.AdminRevokeSessionHandler(authService)))
+	handle("/admin/audit-events", admin(controller.AuditEventsHandler(authService)))
+	handle("/admin/invitations", admin(controller.InvitationsHandler(authService)))
+	handle("/admin/invitations/{id}", admin(controller.RevokeInvitationHandler(authService)))
+	handle("/admin/invitations/{id}/resend", admin(controller.ResendInvitationHandler(authService)))
+	handle("/admin/webhooks", admin(controller.WebhooksHandler(authService)))
+	handle("/admin/webhooks/{id}", admin(controller.DeleteWebhookHandler(authService)))
+	handle("/admin/webhooks/dead-letters", admin(controller.DeadWebhookDeliveriesHandler(authService)))
+	handle("/admin/webhooks/deliveries/{id}/retry", admin(controller.RetryWebhookDeliveryHandler(authService)))
+	handle("/admin", csrf(authService.AdminMiddleware(http.HandlerFunc(controller.AdminHandler))))
+	handle("/superuser", csrf(authService.SuperuserMiddleware(http.HandlerFunc(controller.SuperuserHandler))))
+
+	// Metrics endpoint
+	if o.metrics != nil {
+		routes = append(routes, Route{Pattern: "/metrics", Handler: o.metrics.Handler()})
+	}
+
+	// Health probes, which are polled too often to log or trace
+	if o.health != nil {
+		routes = append(routes, Route{Pattern: "/healthz", Handler: o.health.LivenessHandler()})
+		routes = append(routes, Route{Pattern: "/readyz", Handler: o.health.ReadinessHandler()})
+	}
+
+	return routes
 }
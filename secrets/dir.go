@@ -0,0 +1,45 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Dir reads secrets from files in a directory, one secret per file named
+// after the secret, as Docker mounts them under /run/secrets and
+// Kubernetes mounts a Secret volume. Files are read on every call, so
+// rotated secrets are picked up as soon as the mount is updated.
+type Dir struct {
+	Path string
+}
+
+// Secret implements Provider
+func (d Dir) Secret(ctx context.Context, name string) (string, error) {
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("secrets: invalid secret name %q", name)
+	}
+	value, err := readFile(filepath.Join(d.Path, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return value, err
+}
+
+// readFile reads a secret file, dropping the trailing newline most tools
+// write when the secret is created
+func readFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("secrets: %w", err)
+	}
+	value := strings.TrimRight(string(data), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("secrets: %s is empty", path)
+	}
+	return value, nil
+}
@@ -0,0 +1,28 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Env reads secrets from environment variables named after the secret in
+// upper case, so "db_password" is read from DB_PASSWORD. Following the
+// Docker convention, if that variable is unset but DB_PASSWORD_FILE is set,
+// the secret is read from the file it names instead.
+type Env struct {
+	Prefix string // Optional: prepended to variable names, such as "APP_"
+}
+
+// Secret implements Provider
+func (e Env) Secret(ctx context.Context, name string) (string, error) {
+	key := e.Prefix + strings.ToUpper(name)
+	if value := os.Getenv(key); value != "" {
+		return value, nil
+	}
+	if path := os.Getenv(key + "_FILE"); path != "" {
+		return readFile(path)
+	}
+	return "", fmt.Errorf("%w: %s", ErrNotFound, key)
+}
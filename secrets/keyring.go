@@ -0,0 +1,211 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// keyringVersion is the format version written to keyring files
+const keyringVersion = 1
+
+// scrypt parameters for deriving the keyring key from its passphrase
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// ErrBadPassphrase is returned when a keyring cannot be decrypted
+var ErrBadPassphrase = errors.New("secrets: wrong passphrase or corrupt keyring")
+
+// keyringFile is the on-disk form of a keyring. The secrets are encrypted
+// with AES-256-GCM under a key derived from the passphrase with scrypt.
+type keyringFile struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Keyring reads secrets from an encrypted local keyring file written by
+// WriteKeyring. The file is decrypted again whenever it changes on disk.
+type Keyring struct {
+	path       string
+	passphrase string
+
+	mu      sync.Mutex
+	modTime time.Time
+	secrets map[string]string
+}
+
+// OpenKeyring opens the keyring at path and checks that passphrase
+// decrypts it
+func OpenKeyring(path, passphrase string) (*Keyring, error) {
+	k := &Keyring{path: path, passphrase: passphrase}
+	if _, err := k.load(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Secret implements Provider
+func (k *Keyring) Secret(ctx context.Context, name string) (string, error) {
+	secrets, err := k.load()
+	if err != nil {
+		return "", err
+	}
+	value, ok := secrets[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return value, nil
+}
+
+// Names lists the secrets in the keyring
+func (k *Keyring) Names() ([]string, error) {
+	secrets, err := k.load()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// load returns the decrypted secrets, reading the file again only if it
+// has been modified since the last read
+func (k *Keyring) load() (map[string]string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: %w", err)
+	}
+	if k.secrets != nil && info.ModTime().Equal(k.modTime) {
+		return k.secrets, nil
+	}
+
+	secrets, err := ReadKeyring(k.path, k.passphrase)
+	if err != nil {
+		return nil, err
+	}
+	k.secrets = secrets
+	k.modTime = info.ModTime()
+	return secrets, nil
+}
+
+// ReadKeyring decrypts the keyring at path. A missing file is reported as
+// an error wrapping fs.ErrNotExist.
+func ReadKeyring(path, passphrase string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: %w", err)
+	}
+
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("secrets: %s: %w", path, err)
+	}
+	if file.Version != keyringVersion {
+		return nil, fmt.Errorf("secrets: %s: unsupported keyring version %d", path, file.Version)
+	}
+
+	aead, err := keyringCipher(passphrase, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Nonce) != aead.NonceSize() {
+		return nil, ErrBadPassphrase
+	}
+	plaintext, err := aead.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+
+	secrets := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("secrets: %s: %w", path, err)
+	}
+	return secrets, nil
+}
+
+// WriteKeyring encrypts secrets with passphrase and writes them to path.
+// The file is replaced atomically, so a running Keyring never reads a
+// partial write.
+func WriteKeyring(path, passphrase string, secrets map[string]string) error {
+	if passphrase == "" {
+		return errors.New("secrets: keyring passphrase must not be empty")
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := keyringCipher(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(keyringFile{
+		Version:    keyringVersion,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, nil),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keyring-*")
+	if err != nil {
+		return fmt.Errorf("secrets: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("secrets: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("secrets: %w", err)
+	}
+	// CreateTemp already creates the file readable only by its owner
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("secrets: %w", err)
+	}
+	return nil
+}
+
+// keyringCipher derives the AES-256-GCM cipher for a passphrase and salt
+func keyringCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,62 @@
+// Package secrets reads secrets such as the JWT secret and the database
+// password from pluggable sources: environment variables, files mounted by
+// Docker or Kubernetes, and an encrypted keyring file. Providers are read
+// on demand, so a Value that refreshes periodically picks up rotated
+// secrets without a restart:
+//
+//	provider := secrets.Chain(secrets.Env{}, secrets.Dir{Path: "/run/secrets"})
+//	jwtSecret, err := secrets.Load(ctx, provider, "jwt_secret")
+//
+// Secret names are lowercase with underscores, such as "db_password"; each
+// provider maps them onto its own naming scheme.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is returned by providers that do not hold the named secret
+var ErrNotFound = errors.New("secret not found")
+
+// Provider is a source of secrets
+type Provider interface {
+	// Secret returns the current value of the named secret, or an error
+	// wrapping ErrNotFound if the provider does not hold it
+	Secret(ctx context.Context, name string) (string, error)
+}
+
+// Map is a fixed set of secrets, for literal configuration and tests
+type Map map[string]string
+
+// Secret implements Provider
+func (m Map) Secret(ctx context.Context, name string) (string, error) {
+	value, ok := m[name]
+	if !ok || value == "" {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return value, nil
+}
+
+// Chain returns a provider that asks each provider in turn and returns the
+// first secret found. Errors other than ErrNotFound stop the search, so a
+// broken source is not silently skipped.
+func Chain(providers ...Provider) Provider {
+	return chain(providers)
+}
+
+type chain []Provider
+
+func (c chain) Secret(ctx context.Context, name string) (string, error) {
+	for _, p := range c {
+		value, err := p.Secret(ctx, name)
+		if err == nil {
+			return value, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+}
@@ -0,0 +1,77 @@
+package secrets
+
+import (
+	"context"
+	"sync"
+)
+
+// Value holds a secret loaded from a provider. Refresh re-reads it; the
+// value it replaced stays available as Previous, so holders can keep
+// accepting material signed with the old secret during a rotation.
+type Value struct {
+	provider Provider
+	name     string
+
+	mu       sync.RWMutex
+	current  string
+	previous string
+	err      error
+}
+
+// Load reads the named secret from provider
+func Load(ctx context.Context, provider Provider, name string) (*Value, error) {
+	v := &Value{provider: provider, name: name}
+	if _, err := v.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Name returns the name of the secret
+func (v *Value) Name() string {
+	return v.name
+}
+
+// Get returns the current value of the secret
+func (v *Value) Get() string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.current
+}
+
+// Previous returns the value the secret had before its last change, or ""
+// if it has not changed since it was loaded
+func (v *Value) Previous() string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.previous
+}
+
+// Err returns the error from the last Refresh, or nil if it succeeded
+func (v *Value) Err() error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.err
+}
+
+// Refresh reads the secret from the provider again and reports whether it
+// changed. On error the current value is kept.
+func (v *Value) Refresh(ctx context.Context) (changed bool, err error) {
+	value, err := v.provider.Secret(ctx, v.name)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.err = err
+	if err != nil {
+		return false, err
+	}
+	if value == v.current {
+		return false, nil
+	}
+	loaded := v.current != ""
+	if loaded {
+		v.previous = v.current
+	}
+	v.current = value
+	return loaded, nil
+}
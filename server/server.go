@@ -0,0 +1,199 @@
+// Package server runs an HTTP handler in production: it sets timeouts so
+// slow clients cannot hold connections open, optionally serves TLS with
+// certificates reloaded from disk, and on SIGINT or SIGTERM drains
+// in-flight requests before stopping its background workers:
+//
+//	defer db.Close()
+//	srv := server.New(server.Config{Addr: ":8080"}, handler)
+//	srv.Go("secret refresh", authService.RunSecretRefresh)
+//	err := srv.Run(ctx)
+//
+// Run returns only once requests have drained and workers have stopped, so
+// resources they share, such as the database pool, can be closed with
+// defer.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Defaults for zero Config fields
+const (
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultReadTimeout       = 30 * time.Second
+	DefaultWriteTimeout      = 30 * time.Second
+	DefaultIdleTimeout       = 2 * time.Minute
+	DefaultShutdownTimeout   = 30 * time.Second
+)
+
+// Config configures the HTTP server
+type Config struct {
+	Addr              string        // Listen address, such as ":8080"
+	ReadHeaderTimeout time.Duration // Optional: time to read request headers. Default: 10s
+	ReadTimeout       time.Duration // Optional: time to read the whole request. Default: 30s
+	WriteTimeout      time.Duration // Optional: time to write the response. Default: 30s
+	IdleTimeout       time.Duration // Optional: keep-alive timeout. Default: 2m
+	ShutdownTimeout   time.Duration // Optional: time allowed to drain requests and stop workers. Default: 30s
+	TLS               *TLSConfig    // Optional: serves HTTPS
+	Logger            *slog.Logger  // Optional: defaults to slog.Default()
+}
+
+// Server runs an HTTP server with its background workers
+type Server struct {
+	config  Config
+	http    *http.Server
+	logger  *slog.Logger
+	workers []worker
+	certs   *certReloader
+}
+
+// worker is a background task started with the server
+type worker struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// New creates a server for handler. Nothing listens until Run.
+func New(config Config, handler http.Handler) *Server {
+	if config.ReadHeaderTimeout == 0 {
+		config.ReadHeaderTimeout = DefaultReadHeaderTimeout
+	}
+	if config.ReadTimeout == 0 {
+		config.ReadTimeout = DefaultReadTimeout
+	}
+	if config.WriteTimeout == 0 {
+		config.WriteTimeout = DefaultWriteTimeout
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = DefaultIdleTimeout
+	}
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = DefaultShutdownTimeout
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &Server{
+		config: config,
+		logger: logger,
+		http: &http.Server{
+			Addr:              config.Addr,
+			Handler:           handler,
+			ReadHeaderTimeout: config.ReadHeaderTimeout,
+			ReadTimeout:       config.ReadTimeout,
+			WriteTimeout:      config.WriteTimeout,
+			IdleTimeout:       config.IdleTimeout,
+			ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+		},
+	}
+}
+
+// Go registers a background worker, such as a webhook dispatcher, to run
+// while the server does. Its context is cancelled once requests have
+// drained; a worker returning an error shuts the server down.
+func (s *Server) Go(name string, run func(ctx context.Context) error) {
+	s.workers = append(s.workers, worker{name, run})
+}
+
+// Run serves until ctx is cancelled, the process receives SIGINT or
+// SIGTERM, or a worker fails, then shuts down gracefully. It returns nil
+// after a clean shutdown.
+func (s *Server) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if s.config.TLS != nil {
+		certs, err := newCertReloader(*s.config.TLS, s.logger)
+		if err != nil {
+			return err
+		}
+		s.certs = certs
+		s.http.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+	}
+
+	listener, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return fmt.Errorf("server: %w", err)
+	}
+
+	// Workers outlive ctx so they keep running while requests drain
+	workerCtx, cancelWorkers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWorkers()
+	failed := make(chan error, len(s.workers)+1)
+	var wg sync.WaitGroup
+	for _, w := range s.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.run(workerCtx); err != nil && workerCtx.Err() == nil {
+				failed <- fmt.Errorf("server: %s: %w", w.name, err)
+			}
+		}()
+	}
+
+	go func() {
+		var err error
+		if s.certs != nil {
+			err = s.http.ServeTLS(listener, "", "")
+		} else {
+			err = s.http.Serve(listener)
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			failed <- fmt.Errorf("server: %w", err)
+		}
+	}()
+	s.logger.Info("Server starting", "addr", listener.Addr().String(), "tls", s.certs != nil)
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		s.logger.Info("Shutting down", "timeout", s.config.ShutdownTimeout)
+	case runErr = <-failed:
+		s.logger.Error("Shutting down after failure", "error", runErr)
+	}
+
+	// Stop accepting connections and wait for in-flight requests
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.config.ShutdownTimeout)
+	defer cancel()
+	if err := s.http.Shutdown(shutdownCtx); err != nil {
+		s.logger.Error("Requests did not drain in time", "error", err)
+		s.http.Close()
+		runErr = errors.Join(runErr, fmt.Errorf("server: shutdown: %w", err))
+	}
+
+	// Then stop the workers, which may still be finishing work for requests
+	cancelWorkers()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		s.logger.Error("Background workers did not stop in time")
+		runErr = errors.Join(runErr, errors.New("server: background workers did not stop in time"))
+	}
+
+	if runErr == nil {
+		s.logger.Info("Server stopped")
+	}
+	return runErr
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a loopback address nothing is listening on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+// events records the order things happened in
+type events struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (e *events) add(event string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.list = append(e.list, event)
+}
+
+func (e *events) String() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return strings.Join(e.list, ", ")
+}
+
+func quietLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestRunDrainsRequestsBeforeStoppingWorkers(t *testing.T) {
+	var log events
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		log.add("request done")
+	})
+
+	addr := freeAddr(t)
+	srv := New(Config{Addr: addr, Logger: quietLogger()}, handler)
+	srv.Go("worker", func(ctx context.Context) error {
+		<-ctx.Done()
+		// Work still finishing after cancellation is waited for
+		time.Sleep(50 * time.Millisecond)
+		log.add("worker stopped")
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- srv.Run(ctx) }()
+
+	response := make(chan error, 1)
+	go func() {
+		var resp *http.Response
+		var err error
+		// Retry until the server is listening
+		for i := 0; i < 100; i++ {
+			if resp, err = http.Get("http://" + addr); err == nil {
+				resp.Body.Close()
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		response <- err
+	}()
+	<-started
+
+	cancel()
+	select {
+	case err := <-result:
+		t.Fatalf("Run returned %v with a request in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-response; err != nil {
+		t.Fatalf("in-flight request: %v", err)
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got, want := log.String(), "request done, worker stopped"; got != want {
+		t.Errorf("events %q, want %q", got, want)
+	}
+}
+
+func TestRunReportsStuckWorkers(t *testing.T) {
+	srv := New(Config{Addr: "127.0.0.1:0", ShutdownTimeout: 50 * time.Millisecond, Logger: quietLogger()}, http.NotFoundHandler())
+	stuck := make(chan struct{})
+	t.Cleanup(func() { close(stuck) })
+	srv.Go("stuck", func(context.Context) error {
+		<-stuck
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := srv.Run(ctx)
+	if err == nil || !strings.Contains(err.Error(), "background workers did not stop in time") {
+		t.Errorf("Run: err %v, want the stuck worker reported", err)
+	}
+}
+
+func TestRunStopsWhenAWorkerFails(t *testing.T) {
+	srv := New(Config{Addr: "127.0.0.1:0", Logger: quietLogger()}, http.NotFoundHandler())
+	failure := errors.New("lost connection")
+	srv.Go("dispatcher", func(context.Context) error { return failure })
+
+	var stopped bool
+	srv.Go("other", func(ctx context.Context) error {
+		<-ctx.Done()
+		stopped = true
+		return nil
+	})
+
+	err := srv.Run(context.Background())
+	if !errors.Is(err, failure) || !strings.Contains(err.Error(), "dispatcher") {
+		t.Errorf("Run: err %v, want the dispatcher's failure", err)
+	}
+	if !stopped {
+		t.Error("the other worker was not stopped before Run returned")
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultCertReloadInterval is how often certificate files are checked for
+// changes unless TLSConfig.ReloadInterval is set
+const DefaultCertReloadInterval = time.Minute
+
+// TLSConfig configures HTTPS. The certificate and key are reloaded when
+// either file changes, so renewed certificates (for example from
+// cert-manager or certbot) are served without a restart.
+type TLSConfig struct {
+	CertFile       string        // PEM certificate chain
+	KeyFile        string        // PEM private key
+	ReloadInterval time.Duration // Optional: how often to check the files for changes. Default: 1m
+}
+
+// certReloader serves the current certificate, checking the files for
+// changes at most once per interval during handshakes
+type certReloader struct {
+	config TLSConfig
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// newCertReloader loads the certificate, failing if it is unusable
+func newCertReloader(config TLSConfig, logger *slog.Logger) (*certReloader, error) {
+	if config.ReloadInterval == 0 {
+		config.ReloadInterval = DefaultCertReloadInterval
+	}
+	r := &certReloader{config: config, logger: logger}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checkedAt) >= r.config.ReloadInterval {
+		// A half-written renewal fails to parse; keep serving the old
+		// certificate and try again at the next check
+		if err := r.load(); err != nil {
+			r.logger.Error("Failed to reload TLS certificate", "error", err)
+		}
+	}
+	return r.cert, nil
+}
+
+// load reads the certificate if either file changed since the last load.
+// The caller must hold r.mu, except during construction.
+func (r *certReloader) load() error {
+	r.checkedAt = time.Now()
+
+	modTime, err := latestModTime(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert != nil && modTime.Equal(r.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("server: loading TLS certificate: %w", err)
+	}
+	if r.cert != nil {
+		r.logger.Info("TLS certificate reloaded", "cert", r.config.CertFile)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// latestModTime returns the most recent modification time of the files
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("server: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
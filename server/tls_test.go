@@ -0,0 +1,120 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate with serial to the files and
+// sets their modification time
+func writeCert(t *testing.T, certFile, keyFile string, serial int64, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), modTime)
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), modTime)
+}
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// File systems with coarse timestamps could otherwise hide a rewrite
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servedSerial returns the serial number of the certificate r serves
+func servedSerial(t *testing.T, r *certReloader) int64 {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+func TestCertReloaderPicksUpRotatedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeCert(t, certFile, keyFile, 1, modTime)
+
+	r, err := newCertReloader(TLSConfig{CertFile: certFile, KeyFile: keyFile, ReloadInterval: time.Nanosecond}, quietLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial := servedSerial(t, r); serial != 1 {
+		t.Fatalf("serving certificate %d, want 1", serial)
+	}
+
+	writeCert(t, certFile, keyFile, 2, modTime.Add(time.Minute))
+	if serial := servedSerial(t, r); serial != 2 {
+		t.Errorf("serving certificate %d after rotation, want 2", serial)
+	}
+
+	// A half-written renewal keeps the previous certificate in service
+	writeFile(t, certFile, []byte("-----BEGIN CERTIFICATE-----\n"), modTime.Add(2*time.Minute))
+	if serial := servedSerial(t, r); serial != 2 {
+		t.Errorf("serving certificate %d after a broken renewal, want 2", serial)
+	}
+}
+
+func TestCertReloaderChecksAtMostOncePerInterval(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeCert(t, certFile, keyFile, 1, modTime)
+
+	r, err := newCertReloader(TLSConfig{CertFile: certFile, KeyFile: keyFile, ReloadInterval: time.Hour}, quietLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeCert(t, certFile, keyFile, 2, modTime.Add(time.Minute))
+	if serial := servedSerial(t, r); serial != 1 {
+		t.Errorf("serving certificate %d before the interval passed, want 1", serial)
+	}
+}
+
+func TestNewCertReloaderRejectsMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	_, err := newCertReloader(TLSConfig{CertFile: filepath.Join(dir, "tls.crt"), KeyFile: filepath.Join(dir, "tls.key")}, quietLogger())
+	if err == nil {
+		t.Error("newCertReloader succeeded without certificate files")
+	}
+}
@@ -0,0 +1,98 @@
+// Package telemetry configures OpenTelemetry tracing. Setup installs a
+// global tracer provider and the W3C trace context propagator, which the
+// auth service and the router's tracing middleware pick up automatically.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Exporters supported by Setup
+const (
+	ExporterNone   = "none"   // Propagate trace context but export nothing
+	ExporterStdout = "stdout" // Write spans as JSON to Config.Writer
+	ExporterOTLP   = "otlp"   // Send spans to an OTLP/HTTP collector
+)
+
+// Config selects and configures the span exporter
+type Config struct {
+	ServiceName  string    // Reported as service.name
+	Exporter     string    // One of the Exporter constants; "console" is accepted for stdout. Default: none
+	OTLPEndpoint string    // Optional: host:port of the collector; defaults to the OTEL_EXPORTER_OTLP_* variables
+	Insecure     bool      // Optional: use plain HTTP for OTLP
+	SampleRatio  float64   // Optional: fraction of new traces to sample; 0 samples everything
+	Writer       io.Writer // Optional: destination for the stdout exporter; defaults to os.Stdout
+}
+
+// Setup installs the global tracer provider and propagator. The returned
+// function flushes buffered spans and must be called before the program
+// exits.
+func Setup(ctx context.Context, config Config) (shutdown func(context.Context) error, err error) {
+	// Incoming and outgoing requests carry W3C traceparent/tracestate and baggage
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	switch config.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout, "console":
+		writer := config.Writer
+		if writer == nil {
+			writer = os.Stdout
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(writer))
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if config.OTLPEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(config.OTLPEndpoint))
+		}
+		if config.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("telemetry: unknown exporter %q", config.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = "golang-utils"
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", serviceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if config.SampleRatio > 0 && config.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(config.SampleRatio)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}